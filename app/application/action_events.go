@@ -0,0 +1,42 @@
+package application
+
+import (
+	"context"
+	"github.com/JoseBeteta/surfe/app/domain"
+	"log/slog"
+)
+
+// PublishingActionWriteRepository stores actions publishing an ActionRecorded event for each of them
+type PublishingActionWriteRepository struct {
+	actionWriteRepository domain.ActionWriteRepository
+	publisher             domain.EventPublisher
+	logger                slog.Logger
+}
+
+// NewPublishingActionWriteRepository wraps the write repository to publish recorded actions
+func NewPublishingActionWriteRepository(
+	actionWriteRepository domain.ActionWriteRepository,
+	publisher domain.EventPublisher,
+	logger slog.Logger,
+) *PublishingActionWriteRepository {
+	return &PublishingActionWriteRepository{
+		actionWriteRepository,
+		publisher,
+		logger,
+	}
+}
+
+// Save stores the action and publishes it once stored
+// A failure publishing is logged but not returned, the action is already stored and retrying would duplicate it
+func (r *PublishingActionWriteRepository) Save(ctx context.Context, action domain.Action) (domain.Action, error) {
+	stored, err := r.actionWriteRepository.Save(ctx, action)
+	if err != nil {
+		return domain.Action{}, err
+	}
+
+	if err := r.publisher.Publish(domain.ActionRecordedEvent, domain.ActionRecorded{Action: stored}); err != nil {
+		r.logger.ErrorContext(ctx, "error publishing recorded action", "id", stored.ID, "error", err.Error())
+	}
+
+	return stored, nil
+}
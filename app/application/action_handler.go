@@ -1,59 +1,180 @@
 package application
 
 import (
+	"cmp"
+	"errors"
 	"github.com/JoseBeteta/surfe/app/domain"
 	"github.com/JoseBeteta/surfe/app/infrastructure/common/http"
 	"github.com/gin-gonic/gin"
+	"io"
 	"log/slog"
+	"math"
+	"slices"
 	"strconv"
+	"time"
 )
 
 const (
 	userIdParameterKey   = "id"
 	actionIdParameterKey = "action"
-	referUser            = "REFER_USER"
 )
 
 type ReferralGraph map[int][]int
 
 // ActionHandler of action handler http requests
 type ActionHandler struct {
-	actionReadRepository domain.ActionReadRepository
-	logger               slog.Logger
-	httpMapper           *http.Mapper
+	actionReadRepository  domain.ActionReadRepository
+	actionWriteRepository domain.ActionWriteRepository
+	userReadRepository    domain.UserReadRepository
+	logger                slog.Logger
+	httpMapper            *http.Mapper
+
+	probabilityResponses http.ResponseMappers[nextActionProbabilities]
+	referralResponses    http.ResponseMappers[map[int]int]
 }
 
 // NewActionHandler creates a new handler for action info
 func NewActionHandler(
 	actionReadRepository domain.ActionReadRepository,
+	actionWriteRepository domain.ActionWriteRepository,
+	userReadRepository domain.UserReadRepository,
 	logger slog.Logger,
 	httpMapper *http.Mapper,
 ) *ActionHandler {
-	return &ActionHandler{
-		actionReadRepository,
-		logger,
-		httpMapper,
+	h := &ActionHandler{
+		actionReadRepository:  actionReadRepository,
+		actionWriteRepository: actionWriteRepository,
+		userReadRepository:    userReadRepository,
+		logger:                logger,
+		httpMapper:            httpMapper,
+	}
+
+	// v1 responds with maps keyed by action type and user, v2 with lists carrying counts and user metadata
+	h.probabilityResponses = http.ResponseMappers[nextActionProbabilities]{
+		http.V1: func(_ *gin.Context, result nextActionProbabilities) (any, error) {
+			return result.probabilities, nil
+		},
+		http.V2: h.nextActionProbabilitiesV2,
 	}
+	h.referralResponses = http.ResponseMappers[map[int]int]{
+		http.V2: h.referralIndexV2,
+	}
+
+	return h
 }
 
 func (h *ActionHandler) Initialize(r *gin.Engine, middlewares ...gin.HandlerFunc) {
 	group := r.Group("api/actions")
 
 	group.Use(
-		http.Consume(http.V1),
-		http.Produce(http.V1),
+		http.Consume(http.V1, http.V2),
+		http.Produce(http.V1, http.V2),
 	)
 	group.Use(middlewares...)
 
 	group.GET("users/:id", h.HandleGetActionCountInfo)
 	group.GET("probability/users/:action", h.HandleGetNextActionProbability)
 	group.GET("referral", h.HandleCalculationReferralIndex)
+	group.POST("", h.HandleCreateAction)
 }
 
 type CountResponse struct {
 	Count int `json:"count"`
 }
 
+// NextActionProbabilitiesResponse are the actions following an action type, in v2
+type NextActionProbabilitiesResponse struct {
+	Action string `json:"action"`
+	// Total is the number of transitions from the action
+	Total int                  `json:"total"`
+	Next  []NextActionResponse `json:"next"`
+}
+
+type NextActionResponse struct {
+	Action      string  `json:"action"`
+	Probability float64 `json:"probability"`
+	Count       int     `json:"count"`
+}
+
+// ReferralResponse is the referral index of a user, in v2
+// The metadata is left out for users missing from the users repository
+type ReferralResponse struct {
+	UserID    int    `json:"userId"`
+	Name      string `json:"name,omitempty"`
+	CreatedAt string `json:"createdAt,omitempty"`
+	Referrals int    `json:"referrals"`
+}
+
+// nextActionProbabilities is the result of HandleGetNextActionProbability
+// The counts are only set when the repository keeps them, the probabilities are then derived from them
+type nextActionProbabilities struct {
+	action        string
+	probabilities map[string]float64
+	counts        map[string]int
+}
+
+type CreateActionRequest struct {
+	ID         *int       `json:"id" binding:"omitempty,min=0"`
+	Type       string     `json:"type" binding:"required"`
+	UserID     *int       `json:"userId" binding:"required,min=0"`
+	TargetUser int        `json:"targetUser" binding:"min=0"`
+	CreatedAt  *time.Time `json:"createdAt"`
+}
+
+type ActionResponse struct {
+	ID         int    `json:"id"`
+	Type       string `json:"type"`
+	UserID     int    `json:"userId"`
+	TargetUser int    `json:"targetUser,omitempty"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+// newActionResponse maps a domain action into its response
+func newActionResponse(action domain.Action) ActionResponse {
+	return ActionResponse{
+		ID:         action.ID,
+		Type:       action.Type,
+		UserID:     action.UserID,
+		TargetUser: action.TargetUser,
+		CreatedAt:  action.CreatedAt.Format(time.RFC3339Nano),
+	}
+}
+
+// HandleCreateAction records a new action
+func (h *ActionHandler) HandleCreateAction(c *gin.Context) {
+	var request CreateActionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = http.ErrEmptyBody
+		}
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	action := domain.Action{
+		ID:         domain.UnassignedID,
+		Type:       request.Type,
+		UserID:     *request.UserID,
+		TargetUser: request.TargetUser,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if request.ID != nil {
+		action.ID = *request.ID
+	}
+	if request.CreatedAt != nil {
+		action.CreatedAt = request.CreatedAt.UTC()
+	}
+
+	stored, err := h.actionWriteRepository.Save(c.Request.Context(), action)
+	if err != nil {
+		h.logger.WarnContext(c.Request.Context(), "action could not be stored", "id", action.ID, "type", action.Type)
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	h.httpMapper.CreatedResponse(c, newActionResponse(stored))
+}
+
 // HandleGetActionCountInfo retrieves action count info
 func (h *ActionHandler) HandleGetActionCountInfo(c *gin.Context) {
 	idStr := c.Param(userIdParameterKey)
@@ -64,9 +185,9 @@ func (h *ActionHandler) HandleGetActionCountInfo(c *gin.Context) {
 		return
 	}
 
-	count, err := h.actionReadRepository.CountByUserID(userId)
+	count, err := h.actionReadRepository.CountByUserID(c.Request.Context(), userId)
 	if err != nil {
-		h.logger.Warn("actions not found for this user", "id", userId)
+		h.logger.WarnContext(c.Request.Context(), "actions not found for this user", "id", userId)
 		h.httpMapper.ErrorResponse(c, err)
 		return
 	}
@@ -80,21 +201,105 @@ func (h *ActionHandler) HandleGetActionCountInfo(c *gin.Context) {
 func (h *ActionHandler) HandleGetNextActionProbability(c *gin.Context) {
 	action := c.Param(actionIdParameterKey)
 
-	probabilities, err := h.actionReadRepository.GetNextActionProbabilities(action)
+	result, err := h.readNextActionProbabilities(c, action)
 	if err != nil {
-		h.logger.Warn("probabilities not found for this action", "action", action)
+		h.logger.WarnContext(c.Request.Context(), "probabilities not found for this action", "action", action)
 		h.httpMapper.ErrorResponse(c, err)
 		return
 	}
 
-	h.httpMapper.OkResponse(c, probabilities)
+	response, err := h.probabilityResponses.Map(c, result)
+	if err != nil {
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	h.httpMapper.OkResponse(c, response)
+}
+
+// readNextActionProbabilities reads the probabilities of the actions following the action
+// Read models keeping the counts answer both from a single read, so they can't disagree
+func (h *ActionHandler) readNextActionProbabilities(c *gin.Context, action string) (nextActionProbabilities, error) {
+	if reader, ok := h.actionReadRepository.(domain.NextActionCountReader); ok {
+		counts, err := reader.GetNextActionCounts(c.Request.Context(), action)
+		if err != nil {
+			return nextActionProbabilities{}, err
+		}
+
+		return nextActionProbabilities{action: action, probabilities: probabilitiesOf(counts), counts: counts}, nil
+	}
+
+	probabilities, err := h.actionReadRepository.GetNextActionProbabilities(c.Request.Context(), action)
+	if err != nil {
+		return nextActionProbabilities{}, err
+	}
+
+	return nextActionProbabilities{action: action, probabilities: probabilities}, nil
+}
+
+// nextActionProbabilitiesV2 lists the actions following the action with the count of each transition,
+// the most frequent first
+func (h *ActionHandler) nextActionProbabilitiesV2(c *gin.Context, result nextActionProbabilities) (any, error) {
+	counts, probabilities := result.counts, result.probabilities
+	if counts == nil {
+		// the counts are taken from the timelines, the probabilities from the same actions so they match
+		actions, err := h.actionReadRepository.GetAll(c.Request.Context())
+		if err != nil {
+			return nil, err
+		}
+		counts = countNextActions(actions, result.action)
+		probabilities = probabilitiesOf(counts)
+	}
+
+	response := NextActionProbabilitiesResponse{Action: result.action, Next: []NextActionResponse{}}
+	for next, probability := range probabilities {
+		response.Total += counts[next]
+		response.Next = append(response.Next, NextActionResponse{Action: next, Probability: probability, Count: counts[next]})
+	}
+	slices.SortFunc(response.Next, func(a, b NextActionResponse) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Action, b.Action))
+	})
+
+	return response, nil
+}
+
+// probabilitiesOf turns the counts of the actions following an action into probabilities rounded to two decimals
+func probabilitiesOf(counts map[string]int) map[string]float64 {
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+
+	probabilities := make(map[string]float64, len(counts))
+	for next, count := range counts {
+		probabilities[next] = math.Round((float64(count)/float64(total))*100) / 100
+	}
+
+	return probabilities
+}
+
+// countNextActions counts the actions following actionType in the timeline of each user
+func countNextActions(actions []domain.Action, actionType string) map[string]int {
+	timelines := slices.Clone(actions)
+	slices.SortStableFunc(timelines, func(a, b domain.Action) int {
+		return cmp.Or(cmp.Compare(a.UserID, b.UserID), a.CreatedAt.Compare(b.CreatedAt))
+	})
+
+	counts := make(map[string]int)
+	for i := 0; i < len(timelines)-1; i++ {
+		if timelines[i].UserID == timelines[i+1].UserID && timelines[i].Type == actionType {
+			counts[timelines[i+1].Type]++
+		}
+	}
+
+	return counts
 }
 
 // buildReferralGraph group users by referral into a graph
 func buildReferralGraph(actions []domain.Action) ReferralGraph {
 	graph := make(ReferralGraph)
 	for _, action := range actions {
-		if action.Type == referUser {
+		if action.Type == domain.ActionReferUser {
 			graph[action.UserID] = append(graph[action.UserID], action.TargetUser)
 		}
 	}
@@ -102,9 +307,22 @@ func buildReferralGraph(actions []domain.Action) ReferralGraph {
 }
 
 func (h *ActionHandler) HandleCalculationReferralIndex(c *gin.Context) {
-	actions, err := h.actionReadRepository.GetAll()
+	// read models keeping the index up to date spare walking every action
+	if reader, ok := h.actionReadRepository.(domain.ReferralIndexReader); ok {
+		referralIndex, err := reader.GetReferralIndex(c.Request.Context())
+		if err != nil {
+			h.logger.WarnContext(c.Request.Context(), "referral index not found")
+			h.httpMapper.ErrorResponse(c, err)
+			return
+		}
+
+		h.respondReferralIndex(c, referralIndex)
+		return
+	}
+
+	actions, err := h.actionReadRepository.GetAll(c.Request.Context())
 	if err != nil {
-		h.logger.Warn("actions not found")
+		h.logger.WarnContext(c.Request.Context(), "actions not found")
 		h.httpMapper.ErrorResponse(c, err)
 		return
 	}
@@ -147,5 +365,44 @@ func (h *ActionHandler) HandleCalculationReferralIndex(c *gin.Context) {
 	}
 
 	// Return the computed referral index
-	h.httpMapper.OkResponse(c, referralIndex)
+	h.respondReferralIndex(c, referralIndex)
+}
+
+func (h *ActionHandler) respondReferralIndex(c *gin.Context, referralIndex map[int]int) {
+	response, err := h.referralResponses.Map(c, referralIndex)
+	if err != nil {
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	h.httpMapper.OkResponse(c, response)
+}
+
+// referralIndexV2 lists the referral index of every user with the user metadata, the users referring the most first
+// The users are read at once, the ones missing from the repository are listed without metadata
+func (h *ActionHandler) referralIndexV2(c *gin.Context, referralIndex map[int]int) (any, error) {
+	ids := make([]int, 0, len(referralIndex))
+	for userID := range referralIndex {
+		ids = append(ids, userID)
+	}
+	slices.Sort(ids)
+	users, err := h.userReadRepository.GetByIDs(c.Request.Context(), ids)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]ReferralResponse, 0, len(referralIndex))
+	for userID, referrals := range referralIndex {
+		referral := ReferralResponse{UserID: userID, Referrals: referrals}
+		if user, found := users[userID]; found {
+			referral.Name = user.Name
+			referral.CreatedAt = user.CreatedAt.Format(time.RFC3339)
+		}
+		response = append(response, referral)
+	}
+	slices.SortFunc(response, func(a, b ReferralResponse) int {
+		return cmp.Or(cmp.Compare(b.Referrals, a.Referrals), cmp.Compare(a.UserID, b.UserID))
+	})
+
+	return response, nil
 }
@@ -1,7 +1,9 @@
 package application_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	application_action "github.com/JoseBeteta/surfe/app/application"
 	domain_action "github.com/JoseBeteta/surfe/app/domain"
 	common_http "github.com/JoseBeteta/surfe/app/infrastructure/common/http"
@@ -11,7 +13,9 @@ import (
 	"github.com/stretchr/testify/mock"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Mock ActionReadRepository
@@ -19,33 +23,44 @@ type MockActionReadRepository struct {
 	mock.Mock
 }
 
-func (m *MockActionReadRepository) CountByUserID(userID int) (int, error) {
+func (m *MockActionReadRepository) CountByUserID(_ context.Context, userID int) (int, error) {
 	args := m.Called(userID)
 	return args.Int(0), args.Error(1)
 }
 
-func (m *MockActionReadRepository) GetNextActionProbabilities(actionType string) (map[string]float64, error) {
+func (m *MockActionReadRepository) GetNextActionProbabilities(_ context.Context, actionType string) (map[string]float64, error) {
 	args := m.Called(actionType)
 	return args.Get(0).(map[string]float64), args.Error(1)
 }
 
-func (m *MockActionReadRepository) GetAll() ([]domain_action.Action, error) {
+func (m *MockActionReadRepository) GetAll(context.Context) ([]domain_action.Action, error) {
 	args := m.Called()
 	return args.Get(0).([]domain_action.Action), args.Error(1)
 }
 
+// Mock ActionWriteRepository
+type MockActionWriteRepository struct {
+	mock.Mock
+}
+
+func (m *MockActionWriteRepository) Save(_ context.Context, action domain_action.Action) (domain_action.Action, error) {
+	args := m.Called(action)
+	return args.Get(0).(domain_action.Action), args.Error(1)
+}
+
 // Test HandleGetActionCountInfo
 func TestHandleGetActionCountInfo(t *testing.T) {
 	mockRepo := new(MockActionReadRepository)
 	logger := mocks.NewNullLogger()                 // Assuming you have a NullLogger for testing
 	httpMapper := common_http.NewHttpMapper(logger) // Use real httpMapper, not mock
 
-	handler := application_action.NewActionHandler(mockRepo, logger, httpMapper)
+	handler := application_action.NewActionHandler(mockRepo, new(MockActionWriteRepository), nil, logger, httpMapper)
 
 	mockRepo.On("CountByUserID", 1).Return(10, nil)
 
 	rec := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/actions/users/1", nil)
 	c.Params = gin.Params{
 		{Key: "id", Value: "1"},
 	}
@@ -68,7 +83,7 @@ func TestHandleGetNextActionProbability(t *testing.T) {
 	logger := mocks.NewNullLogger()                 // Assuming you have a NullLogger for testing
 	httpMapper := common_http.NewHttpMapper(logger) // Use real httpMapper, not mock
 
-	handler := application_action.NewActionHandler(mockRepo, logger, httpMapper)
+	handler := application_action.NewActionHandler(mockRepo, new(MockActionWriteRepository), nil, logger, httpMapper)
 
 	mockRepo.On("GetNextActionProbabilities", "REFER_USER").Return(map[string]float64{
 		"REFER_USER":   0.75,
@@ -77,6 +92,7 @@ func TestHandleGetNextActionProbability(t *testing.T) {
 
 	rec := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/actions/probability/users/REFER_USER", nil)
 	c.Params = gin.Params{
 		{Key: "action", Value: "REFER_USER"},
 	}
@@ -102,7 +118,7 @@ func TestHandleCalculationReferralIndex(t *testing.T) {
 	logger := mocks.NewNullLogger()                 // Assuming you have a NullLogger for testing
 	httpMapper := common_http.NewHttpMapper(logger) // Use real httpMapper, not mock
 
-	handler := application_action.NewActionHandler(mockRepo, logger, httpMapper)
+	handler := application_action.NewActionHandler(mockRepo, new(MockActionWriteRepository), nil, logger, httpMapper)
 
 	mockRepo.On("GetAll").Return([]domain_action.Action{
 		{UserID: 1, Type: "REFER_USER", TargetUser: 2},
@@ -112,6 +128,7 @@ func TestHandleCalculationReferralIndex(t *testing.T) {
 
 	rec := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/actions/referral", nil)
 
 	handler.HandleCalculationReferralIndex(c)
 
@@ -129,3 +146,230 @@ func TestHandleCalculationReferralIndex(t *testing.T) {
 
 	mockRepo.AssertExpectations(t)
 }
+
+// Test HandleGetNextActionProbability in v2, the counts are taken from the timelines when the repository doesn't keep them
+func TestHandleGetNextActionProbabilityV2(t *testing.T) {
+	mockRepo := new(MockActionReadRepository)
+	logger := mocks.NewNullLogger()
+	httpMapper := common_http.NewHttpMapper(logger)
+
+	handler := application_action.NewActionHandler(mockRepo, new(MockActionWriteRepository), nil, logger, httpMapper)
+
+	day := time.Date(2022, time.December, 12, 0, 0, 0, 0, time.UTC)
+	mockRepo.On("GetNextActionProbabilities", "REFER_USER").Return(map[string]float64{
+		"REFER_USER":  0.67,
+		"ADD_CONTACT": 0.33,
+	}, nil)
+	mockRepo.On("GetAll").Return([]domain_action.Action{
+		{UserID: 1, Type: "REFER_USER", CreatedAt: day.Add(2 * time.Hour)},
+		{UserID: 1, Type: "REFER_USER", CreatedAt: day},
+		{UserID: 1, Type: "REFER_USER", CreatedAt: day.Add(time.Hour)},
+		{UserID: 2, Type: "REFER_USER", CreatedAt: day},
+		{UserID: 2, Type: "ADD_CONTACT", CreatedAt: day.Add(time.Hour)},
+		{UserID: 3, Type: "ADD_CONTACT", CreatedAt: day},
+	}, nil)
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/actions/probability/users/REFER_USER", nil)
+	c.Params = gin.Params{
+		{Key: "action", Value: "REFER_USER"},
+	}
+	c.Set(common_http.VersionKey, common_http.V2)
+
+	handler.HandleGetNextActionProbability(c)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{
+		"action": "REFER_USER",
+		"total": 3,
+		"next": [
+			{"action": "REFER_USER", "probability": 0.67, "count": 2},
+			{"action": "ADD_CONTACT", "probability": 0.33, "count": 1}
+		]
+	}`, rec.Body.String())
+
+	mockRepo.AssertExpectations(t)
+}
+
+// Test HandleCalculationReferralIndex in v2, users missing from the repository are listed without metadata
+func TestHandleCalculationReferralIndexV2(t *testing.T) {
+	mockRepo := new(MockActionReadRepository)
+	mockUserRepo := new(MockUserReadRepository)
+	logger := mocks.NewNullLogger()
+	httpMapper := common_http.NewHttpMapper(logger)
+
+	handler := application_action.NewActionHandler(mockRepo, new(MockActionWriteRepository), mockUserRepo, logger, httpMapper)
+
+	mockRepo.On("GetAll").Return([]domain_action.Action{
+		{UserID: 1, Type: "REFER_USER", TargetUser: 2},
+		{UserID: 2, Type: "REFER_USER", TargetUser: 3},
+	}, nil)
+	mockUserRepo.On("GetByIDs", []int{1, 2, 3}).Return(map[int]domain_action.User{
+		1: {ID: 1, Name: "Ada", CreatedAt: time.Date(2022, time.December, 12, 0, 0, 0, 0, time.UTC)},
+		2: {ID: 2, Name: "Grace", CreatedAt: time.Date(2023, time.January, 2, 0, 0, 0, 0, time.UTC)},
+	}, nil).Once()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/actions/referral", nil)
+	c.Set(common_http.VersionKey, common_http.V2)
+
+	handler.HandleCalculationReferralIndex(c)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[
+		{"userId": 1, "name": "Ada", "createdAt": "2022-12-12T00:00:00Z", "referrals": 2},
+		{"userId": 2, "name": "Grace", "createdAt": "2023-01-02T00:00:00Z", "referrals": 1},
+		{"userId": 3, "referrals": 0}
+	]`, rec.Body.String())
+
+	mockRepo.AssertExpectations(t)
+	mockUserRepo.AssertExpectations(t)
+}
+
+// Test HandleCalculationReferralIndex in v2 fails when the users can't be read
+func TestHandleCalculationReferralIndexV2UsersError(t *testing.T) {
+	mockRepo := new(MockActionReadRepository)
+	mockUserRepo := new(MockUserReadRepository)
+	logger := mocks.NewNullLogger()
+	httpMapper := common_http.NewHttpMapper(logger)
+
+	handler := application_action.NewActionHandler(mockRepo, new(MockActionWriteRepository), mockUserRepo, logger, httpMapper)
+
+	mockRepo.On("GetAll").Return([]domain_action.Action{{UserID: 1, Type: "REFER_USER", TargetUser: 2}}, nil)
+	mockUserRepo.On("GetByIDs", []int{1, 2}).Return(nil, errors.New("users file is corrupt"))
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/actions/referral", nil)
+	c.Set(common_http.VersionKey, common_http.V2)
+
+	handler.HandleCalculationReferralIndex(c)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	mockUserRepo.AssertExpectations(t)
+}
+
+// MockCountingActionReadRepository keeps the transition counts, like the projections
+type MockCountingActionReadRepository struct {
+	MockActionReadRepository
+}
+
+func (m *MockCountingActionReadRepository) GetNextActionCounts(_ context.Context, actionType string) (map[string]int, error) {
+	args := m.Called(actionType)
+	return args.Get(0).(map[string]int), args.Error(1)
+}
+
+// Test HandleGetNextActionProbability reads the counts once and derives the probabilities from them
+func TestHandleGetNextActionProbabilityFromCounts(t *testing.T) {
+	for _, version := range []string{common_http.V1, common_http.V2} {
+		t.Run(version, func(t *testing.T) {
+			mockRepo := new(MockCountingActionReadRepository)
+			logger := mocks.NewNullLogger()
+			httpMapper := common_http.NewHttpMapper(logger)
+
+			handler := application_action.NewActionHandler(mockRepo, new(MockActionWriteRepository), nil, logger, httpMapper)
+
+			mockRepo.On("GetNextActionCounts", "REFER_USER").Return(map[string]int{"REFER_USER": 2, "ADD_CONTACT": 1}, nil).Once()
+
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/actions/probability/users/REFER_USER", nil)
+			c.Params = gin.Params{{Key: "action", Value: "REFER_USER"}}
+			c.Set(common_http.VersionKey, version)
+
+			handler.HandleGetNextActionProbability(c)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			if version == common_http.V1 {
+				assert.JSONEq(t, `{"REFER_USER": 0.67, "ADD_CONTACT": 0.33}`, rec.Body.String())
+			} else {
+				assert.JSONEq(t, `{
+					"action": "REFER_USER",
+					"total": 3,
+					"next": [
+						{"action": "REFER_USER", "probability": 0.67, "count": 2},
+						{"action": "ADD_CONTACT", "probability": 0.33, "count": 1}
+					]
+				}`, rec.Body.String())
+			}
+			mockRepo.AssertExpectations(t)
+			mockRepo.AssertNotCalled(t, "GetNextActionProbabilities", mock.Anything)
+		})
+	}
+}
+
+// Test HandleCreateAction
+func TestHandleCreateAction(t *testing.T) {
+	createdAt := time.Date(2022, time.December, 12, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		body         string
+		saved        domain_action.Action
+		saveErr      error
+		expectedCode int
+	}{
+		{
+			"assigns id when missing",
+			`{"type":"REFER_USER","userId":1,"targetUser":2,"createdAt":"2022-12-12T00:00:00Z"}`,
+			domain_action.Action{ID: domain_action.UnassignedID, Type: "REFER_USER", UserID: 1, TargetUser: 2, CreatedAt: createdAt},
+			nil,
+			http.StatusCreated,
+		},
+		{
+			"duplicated id",
+			`{"id":3,"type":"WELCOME","userId":1,"createdAt":"2022-12-12T00:00:00Z"}`,
+			domain_action.Action{ID: 3, Type: "WELCOME", UserID: 1, CreatedAt: createdAt},
+			domain_action.ActionAlreadyExists,
+			http.StatusConflict,
+		},
+		{
+			"missing user",
+			`{"type":"WELCOME"}`,
+			domain_action.Action{},
+			nil,
+			http.StatusBadRequest,
+		},
+		{
+			"empty body",
+			``,
+			domain_action.Action{},
+			nil,
+			http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockWriteRepo := new(MockActionWriteRepository)
+			logger := mocks.NewNullLogger()
+			httpMapper := common_http.NewHttpMapper(logger)
+
+			handler := application_action.NewActionHandler(new(MockActionReadRepository), mockWriteRepo, nil, logger, httpMapper)
+
+			stored := tt.saved
+			stored.ID = 7
+			if tt.expectedCode != http.StatusBadRequest {
+				mockWriteRepo.On("Save", tt.saved).Return(stored, tt.saveErr)
+			}
+
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/actions", strings.NewReader(tt.body))
+
+			handler.HandleCreateAction(c)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectedCode == http.StatusCreated {
+				var response application_action.ActionResponse
+				assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+				assert.Equal(t, 7, response.ID)
+				assert.Equal(t, 2, response.TargetUser)
+			}
+
+			mockWriteRepo.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"github.com/JoseBeteta/surfe/app/domain"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+const apiKeyIDParameterKey = "id"
+
+// APIKeyHandler of API key administration http requests
+type APIKeyHandler struct {
+	apiKeyService *APIKeyService
+	logger        slog.Logger
+	httpMapper    *http.Mapper
+}
+
+// NewAPIKeyHandler creates a new handler administering API keys
+func NewAPIKeyHandler(
+	apiKeyService *APIKeyService,
+	logger slog.Logger,
+	httpMapper *http.Mapper,
+) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService,
+		logger,
+		httpMapper,
+	}
+}
+
+func (h *APIKeyHandler) Initialize(r *gin.Engine, middlewares ...gin.HandlerFunc) {
+	group := r.Group("api/admin/api-keys")
+
+	group.Use(
+		http.Consume(http.V1, http.V2),
+		http.Produce(http.V1, http.V2),
+	)
+	group.Use(middlewares...)
+
+	group.POST("", h.HandleCreateAPIKey)
+	group.GET("", h.HandleListAPIKeys)
+	group.DELETE("/:id", h.HandleRevokeAPIKey)
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type APIKeyResponse struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	Active     bool     `json:"active"`
+	ExpiresAt  string   `json:"expiresAt,omitempty"`
+	RevokedAt  string   `json:"revokedAt,omitempty"`
+	LastUsedAt string   `json:"lastUsedAt,omitempty"`
+	CreatedAt  string   `json:"createdAt"`
+}
+
+type CreateAPIKeyResponse struct {
+	APIKeyResponse
+	// Key is only returned when the key is created
+	Key string `json:"key"`
+}
+
+// newAPIKeyResponse maps a key into its response, the hash is never returned
+func newAPIKeyResponse(key domain.APIKey) APIKeyResponse {
+	response := APIKeyResponse{
+		ID:        key.ID,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		Active:    key.Active(time.Now()),
+		CreatedAt: key.CreatedAt.Format(time.RFC3339),
+	}
+	if key.ExpiresAt != nil {
+		response.ExpiresAt = key.ExpiresAt.Format(time.RFC3339)
+	}
+	if key.RevokedAt != nil {
+		response.RevokedAt = key.RevokedAt.Format(time.RFC3339)
+	}
+	if key.LastUsedAt != nil {
+		response.LastUsedAt = key.LastUsedAt.Format(time.RFC3339)
+	}
+
+	return response
+}
+
+// HandleCreateAPIKey creates a key bound to scopes, the key is only returned in this response
+func (h *APIKeyHandler) HandleCreateAPIKey(c *gin.Context) {
+	var request CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = http.ErrEmptyBody
+		}
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	for _, scope := range request.Scopes {
+		if !slices.Contains(http.AllScopes, scope) {
+			h.httpMapper.ErrorResponse(c, fmt.Errorf("%w: unknown scope %q", domain.InvalidArgument, scope))
+			return
+		}
+	}
+
+	plain, key, err := h.apiKeyService.Create(request.Name, request.Scopes, request.ExpiresAt)
+	if err != nil {
+		h.logger.WarnContext(c.Request.Context(), "api key could not be created", "name", request.Name)
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	h.logger.InfoContext(c.Request.Context(), "api key created", "id", key.ID, "name", key.Name, "subject", http.Subject(c))
+
+	h.httpMapper.CreatedResponse(c, CreateAPIKeyResponse{
+		APIKeyResponse: newAPIKeyResponse(key),
+		Key:            plain,
+	})
+}
+
+// HandleListAPIKeys retrieves every key, revoked and expired ones included
+func (h *APIKeyHandler) HandleListAPIKeys(c *gin.Context) {
+	keys, err := h.apiKeyService.List()
+	if err != nil {
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	response := make([]APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, newAPIKeyResponse(key))
+	}
+
+	h.httpMapper.OkResponse(c, response)
+}
+
+// HandleRevokeAPIKey revokes a key, it stops authenticating requests right away
+func (h *APIKeyHandler) HandleRevokeAPIKey(c *gin.Context) {
+	id := c.Param(apiKeyIDParameterKey)
+
+	key, err := h.apiKeyService.Revoke(id)
+	if err != nil {
+		h.logger.WarnContext(c.Request.Context(), "api key not found", "id", id)
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	h.logger.InfoContext(c.Request.Context(), "api key revoked", "id", key.ID, "subject", http.Subject(c))
+
+	h.httpMapper.OkResponse(c, newAPIKeyResponse(key))
+}
@@ -0,0 +1,151 @@
+package application
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/JoseBeteta/surfe/app/domain"
+	"golang.org/x/crypto/blake2b"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyPrefix       = "sk_"
+	apiKeyIDLength     = 8
+	apiKeySecretLength = 32
+	apiKeySubject      = "apikey:"
+)
+
+// ErrInvalidAPIKey error when an API key is unknown, revoked or expired
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// APIKeyService creates, revokes and verifies the API keys of service callers
+// Keys look like sk_<id>_<secret>; the id locates the key and only the BLAKE2b hash of the secret is stored
+type APIKeyService struct {
+	repository domain.APIKeyRepository
+	logger     slog.Logger
+	now        func() time.Time
+}
+
+// NewAPIKeyService creates a new API key service
+func NewAPIKeyService(repository domain.APIKeyRepository, logger slog.Logger) *APIKeyService {
+	return &APIKeyService{
+		repository: repository,
+		logger:     logger,
+		now:        time.Now,
+	}
+}
+
+// WithClock allows you to specify the clock used to expire keys
+func (s *APIKeyService) WithClock(now func() time.Time) *APIKeyService {
+	s.now = now
+
+	return s
+}
+
+// Create generates a new key, the plain key returned is not stored and can't be retrieved again
+func (s *APIKeyService) Create(name string, scopes []string, expiresAt *time.Time) (string, domain.APIKey, error) {
+	now := s.now().UTC()
+	if expiresAt != nil && !expiresAt.After(now) {
+		return "", domain.APIKey{}, fmt.Errorf("%w: expiresAt must be in the future", domain.InvalidArgument)
+	}
+
+	id, err := randomHex(apiKeyIDLength)
+	if err != nil {
+		return "", domain.APIKey{}, err
+	}
+	secret, err := randomHex(apiKeySecretLength)
+	if err != nil {
+		return "", domain.APIKey{}, err
+	}
+
+	key := domain.APIKey{
+		ID:        id,
+		Name:      name,
+		Hash:      hashAPIKeySecret(secret),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+	if err := s.repository.Save(key); err != nil {
+		return "", domain.APIKey{}, err
+	}
+
+	return apiKeyPrefix + id + "_" + secret, key, nil
+}
+
+// Revoke revokes the key, revoking a revoked key keeps the time it was first revoked
+func (s *APIKeyService) Revoke(id string) (domain.APIKey, error) {
+	return s.repository.Revoke(id, s.now().UTC())
+}
+
+// List retrieves every key
+func (s *APIKeyService) List() ([]domain.APIKey, error) {
+	return s.repository.GetAll()
+}
+
+// HasActiveKeys reports whether any key is neither revoked nor expired
+func (s *APIKeyService) HasActiveKeys() (bool, error) {
+	keys, err := s.repository.GetAll()
+	if err != nil {
+		return false, err
+	}
+
+	now := s.now().UTC()
+	for _, key := range keys {
+		if key.Active(now) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Verify returns the subject and scopes of an active key, recording it was used
+func (s *APIKeyService) Verify(plain string) (string, []string, error) {
+	id, secret, found := strings.Cut(strings.TrimPrefix(plain, apiKeyPrefix), "_")
+	if !found || !strings.HasPrefix(plain, apiKeyPrefix) {
+		return "", nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.repository.GetByID(id)
+	if errors.Is(err, domain.APIKeyNotFound) {
+		return "", nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(secret)), []byte(key.Hash)) != 1 {
+		return "", nil, ErrInvalidAPIKey
+	}
+
+	now := s.now().UTC()
+	if !key.Active(now) {
+		return "", nil, ErrInvalidAPIKey
+	}
+
+	if err := s.repository.MarkUsed(key.ID, now); err != nil {
+		s.logger.Error("error recording api key use", "id", key.ID, "error", err.Error())
+	}
+
+	return apiKeySubject + key.ID, key.Scopes, nil
+}
+
+func hashAPIKeySecret(secret string) string {
+	hash := blake2b.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomHex(length int) (string, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,161 @@
+package application_test
+
+import (
+	"encoding/json"
+	application_action "github.com/JoseBeteta/surfe/app/application"
+	domain_action "github.com/JoseBeteta/surfe/app/domain"
+	common_http "github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/JoseBeteta/surfe/app/infrastructure/persistence"
+	"github.com/JoseBeteta/surfe/test/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newAPIKeyService(t *testing.T, now *time.Time) (*application_action.APIKeyService, *persistence.APIKeyJSONRepository) {
+	t.Helper()
+
+	repository, err := persistence.NewAPIKeyJSONRepository(filepath.Join(t.TempDir(), "api_keys.json"))
+	require.NoError(t, err)
+
+	service := application_action.NewAPIKeyService(repository, mocks.NewNullLogger()).
+		WithClock(func() time.Time { return *now })
+
+	return service, repository
+}
+
+func TestAPIKeyService(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	service, repository := newAPIKeyService(t, &now)
+
+	expiresAt := now.Add(24 * time.Hour)
+	plain, key, err := service.Create("batch", []string{"actions:write"}, &expiresAt)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(plain, "sk_"+key.ID+"_"))
+	assert.NotContains(t, key.Hash, strings.TrimPrefix(plain, "sk_"+key.ID+"_"))
+
+	subject, scopes, err := service.Verify(plain)
+	require.NoError(t, err)
+	assert.Equal(t, "apikey:"+key.ID, subject)
+	assert.Equal(t, []string{"actions:write"}, scopes)
+
+	stored, err := repository.GetByID(key.ID)
+	require.NoError(t, err)
+	assert.Equal(t, now, *stored.LastUsedAt)
+
+	for name, invalid := range map[string]string{
+		"wrong secret": "sk_" + key.ID + "_" + strings.Repeat("0", 64),
+		"unknown id":   "sk_0000000000000000_" + strings.Repeat("0", 64),
+		"no prefix":    strings.TrimPrefix(plain, "sk_"),
+		"malformed":    "sk_",
+	} {
+		_, _, err := service.Verify(invalid)
+		assert.ErrorIs(t, err, application_action.ErrInvalidAPIKey, name)
+	}
+
+	// expired
+	now = expiresAt
+	_, _, err = service.Verify(plain)
+	assert.ErrorIs(t, err, application_action.ErrInvalidAPIKey)
+
+	// revoked
+	now = expiresAt.Add(-time.Hour)
+	_, err = service.Revoke(key.ID)
+	require.NoError(t, err)
+	_, _, err = service.Verify(plain)
+	assert.ErrorIs(t, err, application_action.ErrInvalidAPIKey)
+
+	_, err = service.Revoke("unknown")
+	assert.ErrorIs(t, err, domain_action.APIKeyNotFound)
+
+	// expiring in the past
+	past := now.Add(-time.Second)
+	_, _, err = service.Create("batch", []string{"actions:write"}, &past)
+	assert.ErrorIs(t, err, domain_action.InvalidArgument)
+}
+
+// a key used while it is revoked stays revoked
+func TestAPIKeyServiceRevokeWhileUsed(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	service, repository := newAPIKeyService(t, &now)
+
+	_, key, err := service.Create("batch", []string{"actions:write"}, nil)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			assert.NoError(t, repository.MarkUsed(key.ID, now.Add(time.Duration(i)*time.Hour)))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		_, err := service.Revoke(key.ID)
+		assert.NoError(t, err)
+	}()
+	wg.Wait()
+
+	stored, err := repository.GetByID(key.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, stored.RevokedAt)
+}
+
+func TestAPIKeyServiceHasActiveKeys(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	service, _ := newAPIKeyService(t, &now)
+
+	active, err := service.HasActiveKeys()
+	require.NoError(t, err)
+	assert.False(t, active)
+
+	_, key, err := service.Create("admin", []string{"admin"}, nil)
+	require.NoError(t, err)
+	active, err = service.HasActiveKeys()
+	require.NoError(t, err)
+	assert.True(t, active)
+
+	_, err = service.Revoke(key.ID)
+	require.NoError(t, err)
+	active, err = service.HasActiveKeys()
+	require.NoError(t, err)
+	assert.False(t, active)
+}
+
+func TestHandleCreateAPIKey(t *testing.T) {
+	now := time.Now()
+	service, _ := newAPIKeyService(t, &now)
+	logger := mocks.NewNullLogger()
+	handler := application_action.NewAPIKeyHandler(service, logger, common_http.NewHttpMapper(logger))
+
+	create := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/admin/api-keys", strings.NewReader(body))
+		handler.HandleCreateAPIKey(c)
+		return rec
+	}
+
+	rec := create(`{"name": "batch", "scopes": ["actions:write", "users:read"]}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	var response application_action.CreateAPIKeyResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.True(t, response.Active)
+	assert.NotContains(t, rec.Body.String(), "hash")
+
+	_, scopes, err := service.Verify(response.Key)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"actions:write", "users:read"}, scopes)
+
+	assert.Equal(t, http.StatusBadRequest, create(`{"name": "batch", "scopes": ["everything"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, create(`{"name": "batch", "scopes": []}`).Code)
+}
@@ -0,0 +1,82 @@
+package application
+
+import (
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/JoseBeteta/surfe/app/infrastructure/consumer"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"time"
+)
+
+// ConsumerStatsReader reads the counters and lag of the actions consumer
+type ConsumerStatsReader interface {
+	Stats() (consumer.Stats, error)
+}
+
+// ConsumerHandler of consumer handler http requests
+type ConsumerHandler struct {
+	statsReader ConsumerStatsReader
+	logger      slog.Logger
+	httpMapper  *http.Mapper
+}
+
+// NewConsumerHandler creates a new handler for the actions consumer
+func NewConsumerHandler(
+	statsReader ConsumerStatsReader,
+	logger slog.Logger,
+	httpMapper *http.Mapper,
+) *ConsumerHandler {
+	return &ConsumerHandler{
+		statsReader,
+		logger,
+		httpMapper,
+	}
+}
+
+func (h *ConsumerHandler) Initialize(r *gin.Engine, middlewares ...gin.HandlerFunc) {
+	group := r.Group("api/consumer")
+
+	group.Use(
+		http.Consume(http.V1, http.V2),
+		http.Produce(http.V1, http.V2),
+	)
+	group.Use(middlewares...)
+
+	group.GET("stats", h.HandleGetStats)
+}
+
+type ConsumerStatsResponse struct {
+	Received        int    `json:"received"`
+	Processed       int    `json:"processed"`
+	Duplicates      int    `json:"duplicates"`
+	Rejected        int    `json:"rejected"`
+	Failed          int    `json:"failed"`
+	Backlog         int    `json:"backlog"`
+	Lag             string `json:"lag"`
+	LastProcessedAt string `json:"lastProcessedAt,omitempty"`
+}
+
+// HandleGetStats retrieves the counters, backlog and lag of the actions consumer
+func (h *ConsumerHandler) HandleGetStats(c *gin.Context) {
+	stats, err := h.statsReader.Stats()
+	if err != nil {
+		h.logger.WarnContext(c.Request.Context(), "consumer stats not available")
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	response := ConsumerStatsResponse{
+		Received:   stats.Received,
+		Processed:  stats.Processed,
+		Duplicates: stats.Duplicates,
+		Rejected:   stats.Rejected,
+		Failed:     stats.Failed,
+		Backlog:    stats.Backlog,
+		Lag:        stats.Lag.String(),
+	}
+	if stats.LastProcessedAt != nil {
+		response.LastProcessedAt = stats.LastProcessedAt.Format(time.RFC3339Nano)
+	}
+
+	h.httpMapper.OkResponse(c, response)
+}
@@ -0,0 +1,13 @@
+package application
+
+import (
+	_ "embed"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// OpenAPISpec returns the OpenAPI 3.1 document describing the routes of the handlers
+func OpenAPISpec() []byte {
+	return openAPISpec
+}
@@ -0,0 +1,71 @@
+package application
+
+import (
+	"context"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"time"
+)
+
+// ProjectionRebuilder rebuilds read models from scratch out of the stored actions
+type ProjectionRebuilder interface {
+	Rebuild(ctx context.Context) (int, error)
+}
+
+// ProjectionHandler of projection handler http requests
+type ProjectionHandler struct {
+	rebuilder  ProjectionRebuilder
+	logger     slog.Logger
+	httpMapper *http.Mapper
+}
+
+// NewProjectionHandler creates a new handler for projection commands
+func NewProjectionHandler(
+	rebuilder ProjectionRebuilder,
+	logger slog.Logger,
+	httpMapper *http.Mapper,
+) *ProjectionHandler {
+	return &ProjectionHandler{
+		rebuilder,
+		logger,
+		httpMapper,
+	}
+}
+
+func (h *ProjectionHandler) Initialize(r *gin.Engine, middlewares ...gin.HandlerFunc) {
+	group := r.Group("api/projections")
+
+	group.Use(
+		http.Consume(http.V1, http.V2),
+		http.Produce(http.V1, http.V2),
+	)
+	group.Use(middlewares...)
+
+	group.POST("rebuild", h.HandleRebuild)
+}
+
+type RebuildResponse struct {
+	Actions  int    `json:"actions"`
+	Duration string `json:"duration"`
+}
+
+// HandleRebuild discards the read models and rebuilds them replaying every stored action
+func (h *ProjectionHandler) HandleRebuild(c *gin.Context) {
+	start := time.Now()
+
+	actions, err := h.rebuilder.Rebuild(c.Request.Context())
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "projections could not be rebuilt")
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	duration := time.Since(start)
+	h.logger.InfoContext(c.Request.Context(), "projections rebuilt", "actions", actions, "duration", duration.String())
+
+	h.httpMapper.OkResponse(c, RebuildResponse{
+		Actions:  actions,
+		Duration: duration.String(),
+	})
+}
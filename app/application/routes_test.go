@@ -0,0 +1,199 @@
+package application_test
+
+import (
+	"encoding/json"
+	application_action "github.com/JoseBeteta/surfe/app/application"
+	"github.com/JoseBeteta/surfe/app/domain"
+	common_http "github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/metrics"
+	"github.com/JoseBeteta/surfe/app/infrastructure/stream"
+	"github.com/JoseBeteta/surfe/test/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// newRouter registers the routes of every handler
+func newRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	logger := mocks.NewNullLogger()
+	httpMapper := common_http.NewHttpMapper(logger)
+	router := gin.New()
+
+	application_action.NewUserHandler(nil, logger, httpMapper).Initialize(router)
+	application_action.NewActionHandler(nil, nil, nil, logger, httpMapper).Initialize(router)
+	application_action.NewProjectionHandler(nil, logger, httpMapper).Initialize(router)
+	application_action.NewStreamHandler(stream.NewHub(1), nil, 0, logger, httpMapper).Initialize(router)
+	application_action.NewWebhookHandler(nil, logger, httpMapper).Initialize(router)
+	application_action.NewConsumerHandler(nil, logger, httpMapper).Initialize(router)
+	application_action.NewAPIKeyHandler(nil, logger, httpMapper).Initialize(router)
+
+	return router
+}
+
+// Every API route must declare the scope it requires, otherwise it is denied to every caller
+func TestEveryRouteHasScopePolicy(t *testing.T) {
+	router := newRouter()
+
+	for _, route := range router.Routes() {
+		_, found := common_http.RouteScope(route.Method, route.Path)
+		assert.True(t, found, "%s %s has no scope policy", route.Method, route.Path)
+	}
+}
+
+type openAPIOperation struct {
+	Scope     string                     `json:"x-scope"`
+	Responses map[string]json.RawMessage `json:"responses"`
+}
+
+type openAPIDocument struct {
+	OpenAPI string                                 `json:"openapi"`
+	Paths   map[string]map[string]openAPIOperation `json:"paths"`
+}
+
+var ginParameter = regexp.MustCompile(`:([^/]+)`)
+
+// Every route must be documented, with the scope it requires, and every documented route must exist
+func TestEveryRouteIsInOpenAPISpec(t *testing.T) {
+	router := newRouter()
+	common_http.RegisterHomeHandler(router)
+	common_http.RegisterHealthHandlers(router, common_http.NewHealthChecker(0))
+	common_http.RegisterMetricsHandler(router, metrics.NewRegistry())
+	common_http.RegisterOpenAPIHandlers(router, application_action.OpenAPISpec())
+
+	var spec openAPIDocument
+	require.NoError(t, json.Unmarshal(application_action.OpenAPISpec(), &spec))
+	assert.Equal(t, "3.1.0", spec.OpenAPI)
+
+	registered := map[string]bool{}
+	for _, route := range router.Routes() {
+		path := ginParameter.ReplaceAllString(route.Path, "{$1}")
+		method := strings.ToLower(route.Method)
+		registered[method+" "+path] = true
+
+		operation, found := spec.Paths[path][method]
+		if !assert.True(t, found, "%s %s is missing from the OpenAPI spec", route.Method, path) {
+			continue
+		}
+
+		scope, _ := common_http.RouteScope(route.Method, route.Path)
+		assert.Equal(t, scope, operation.Scope, "%s %s documents another scope", route.Method, path)
+
+		cacheable := common_http.RouteCacheable(common_http.Route(route.Method, route.Path))
+		_, notModified := operation.Responses["304"]
+		assert.Equal(t, cacheable, notModified, "%s %s can be cached only if it documents the 304 response", route.Method, path)
+	}
+
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			assert.True(t, registered[method+" "+path], "%s %s is documented but not registered", strings.ToUpper(method), path)
+		}
+	}
+}
+
+// Every reference of the spec points to a component
+func TestOpenAPISpecReferences(t *testing.T) {
+	var spec map[string]any
+	require.NoError(t, json.Unmarshal(application_action.OpenAPISpec(), &spec))
+
+	var walk func(node any)
+	walk = func(node any) {
+		switch value := node.(type) {
+		case map[string]any:
+			if ref, ok := value["$ref"].(string); ok {
+				path := strings.Split(strings.TrimPrefix(ref, "#/"), "/")
+				var target any = spec
+				for _, key := range path {
+					parent, _ := target.(map[string]any)
+					target = parent[key]
+				}
+				assert.NotNil(t, target, "%s does not exist", ref)
+			}
+			for _, child := range value {
+				walk(child)
+			}
+		case []any:
+			for _, child := range value {
+				walk(child)
+			}
+		}
+	}
+	walk(spec)
+}
+
+// The action types of the spec are the ones the domain accepts
+func TestOpenAPIActionTypesMatchDomain(t *testing.T) {
+	var spec struct {
+		Components struct {
+			Schemas struct {
+				ActionType struct {
+					Enum []string `json:"enum"`
+				} `json:"ActionType"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(application_action.OpenAPISpec(), &spec))
+
+	assert.ElementsMatch(t, domain.ActionTypes, spec.Components.Schemas.ActionType.Enum)
+}
+
+// The requests not matching the spec are rejected before reaching the handlers, which have no repositories here
+func TestRequestsAreValidatedAgainstOpenAPISpec(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	validator, err := common_http.NewRequestValidator(application_action.OpenAPISpec())
+	require.NoError(t, err)
+
+	logger := mocks.NewNullLogger()
+	httpMapper := common_http.NewHttpMapper(logger)
+	router := gin.New()
+	application_action.NewUserHandler(nil, logger, httpMapper).Initialize(router, validator.Validate())
+	application_action.NewActionHandler(nil, nil, nil, logger, httpMapper).Initialize(router, validator.Validate())
+	application_action.NewStreamHandler(stream.NewHub(1), nil, 0, logger, httpMapper).Initialize(router, validator.Validate())
+	application_action.NewWebhookHandler(nil, logger, httpMapper).Initialize(router, validator.Validate())
+	application_action.NewAPIKeyHandler(nil, logger, httpMapper).Initialize(router, validator.Validate())
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		detail string
+	}{
+		{http.MethodGet, "/api/users/abc", "", `path parameter "id" must be of type integer`},
+		{http.MethodGet, "/api/actions/users/-4", "", `path parameter "id" must be at least 0`},
+		{
+			http.MethodGet, "/api/actions/probability/users/UNKNOWN", "",
+			`path parameter "action" must be one of ADD_CONTACT, CONNECT_CRM, EDIT_CONTACT, REFER_USER, VIEW_CONTACTS, WELCOME`,
+		},
+		{http.MethodGet, "/api/actions/stream?userId=me", "", `query parameter "userId" must be of type integer`},
+		{http.MethodPost, "/api/actions", `{"type": "JUMP", "userId": 1}`, "body.type must be one of ADD_CONTACT, CONNECT_CRM, EDIT_CONTACT, REFER_USER, VIEW_CONTACTS, WELCOME"},
+		{http.MethodPost, "/api/actions", `{"type": "WELCOME"}`, "body.userId is required"},
+		{http.MethodPost, "/api/webhooks", `{"url": "https://example.com", "secret": "short"}`, "body.secret must be at least 16 characters"},
+		{http.MethodPost, "/api/admin/api-keys", `{"name": "ci", "scopes": ["root"]}`, "body.scopes[0] must be one of users:read, actions:read, actions:write, analytics:read, admin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Accept", "*/*")
+			if tt.body != "" {
+				r.Header.Set("Content-Type", common_http.V1)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+
+			var problem map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+			assert.Equal(t, tt.detail, problem["detail"])
+		})
+	}
+}
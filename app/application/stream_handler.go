@@ -0,0 +1,187 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"github.com/JoseBeteta/surfe/app/domain"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/JoseBeteta/surfe/app/infrastructure/stream"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	stdHttp "net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	lastEventIDHeader = "Last-Event-ID"
+	actionEventName   = "action"
+	heartbeatComment  = ": heartbeat\n\n"
+)
+
+// StreamHandler of live action stream http requests
+type StreamHandler struct {
+	hub                  *stream.Hub
+	actionReadRepository domain.ActionReadRepository
+	heartbeatInterval    time.Duration
+	logger               slog.Logger
+	httpMapper           *http.Mapper
+}
+
+// NewStreamHandler creates a new handler streaming recorded actions
+func NewStreamHandler(
+	hub *stream.Hub,
+	actionReadRepository domain.ActionReadRepository,
+	heartbeatInterval time.Duration,
+	logger slog.Logger,
+	httpMapper *http.Mapper,
+) *StreamHandler {
+	return &StreamHandler{
+		hub,
+		actionReadRepository,
+		heartbeatInterval,
+		logger,
+		httpMapper,
+	}
+}
+
+func (h *StreamHandler) Initialize(r *gin.Engine, middlewares ...gin.HandlerFunc) {
+	group := r.Group("api/actions")
+
+	group.Use(
+		http.Produce(http.EventStream),
+	)
+	group.Use(middlewares...)
+
+	group.GET("stream", h.HandleStreamActions)
+}
+
+// HandleStreamActions sends the recorded actions as server-sent events until the client disconnects
+// Clients resuming with Last-Event-ID first receive the stored actions with a greater ID
+// Clients not keeping up are disconnected, they resume from the last action received
+// Streams also end when the hub is closed on shutdown
+func (h *StreamHandler) HandleStreamActions(c *gin.Context) {
+	filter, err := parseStreamFilter(c)
+	if err != nil {
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	lastEventID, resume, err := parseLastEventID(c)
+	if err != nil {
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	// registered before replaying, so actions recorded meanwhile are not missed
+	subscription := h.hub.Register(filter)
+	defer h.hub.Unregister(subscription)
+
+	var replay []domain.Action
+	if resume {
+		replay, err = h.storedActionsAfter(c.Request.Context(), lastEventID, filter)
+		if err != nil {
+			h.logger.WarnContext(c.Request.Context(), "actions to resume the stream not found", "lastEventId", lastEventID)
+			h.httpMapper.ErrorResponse(c, err)
+			return
+		}
+	}
+
+	// the stream outlives the server write timeout
+	_ = stdHttp.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(stdHttp.StatusOK)
+	c.Writer.Flush()
+
+	sent := make(map[int]struct{}, len(replay))
+	for _, action := range replay {
+		h.send(c, action)
+		sent[action.ID] = struct{}{}
+	}
+
+	heartbeat := time.NewTicker(h.heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-subscription.Dropped():
+			h.logger.WarnContext(c.Request.Context(), "stream subscription dropped", "remote", c.ClientIP())
+			return
+		case <-heartbeat.C:
+			if _, err := c.Writer.WriteString(heartbeatComment); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case action := <-subscription.Actions():
+			if _, found := sent[action.ID]; found {
+				delete(sent, action.ID)
+				continue
+			}
+			h.send(c, action)
+		}
+	}
+}
+
+func (h *StreamHandler) send(c *gin.Context, action domain.Action) {
+	c.Render(-1, sse.Event{
+		Id:    strconv.Itoa(action.ID),
+		Event: actionEventName,
+		Data:  newActionResponse(action),
+	})
+	c.Writer.Flush()
+}
+
+// storedActionsAfter returns the stored actions matching filter with an ID greater than id, in ID order
+func (h *StreamHandler) storedActionsAfter(ctx context.Context, id int, filter stream.Filter) ([]domain.Action, error) {
+	actions, err := h.actionReadRepository.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	after := []domain.Action{}
+	for _, action := range actions {
+		if action.ID > id && filter.Matches(action) {
+			after = append(after, action)
+		}
+	}
+	sort.Slice(after, func(i, j int) bool {
+		return after[i].ID < after[j].ID
+	})
+
+	return after, nil
+}
+
+func parseStreamFilter(c *gin.Context) (stream.Filter, error) {
+	filter := stream.Filter{Type: c.Query("type")}
+
+	if value := c.Query("userId"); value != "" {
+		userID, err := strconv.Atoi(value)
+		if err != nil {
+			return stream.Filter{}, fmt.Errorf("%w: userId must be an integer", domain.InvalidArgument)
+		}
+		filter.UserID = &userID
+	}
+
+	return filter, nil
+}
+
+func parseLastEventID(c *gin.Context) (int, bool, error) {
+	value := c.GetHeader(lastEventIDHeader)
+	if value == "" {
+		return 0, false, nil
+	}
+
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false, fmt.Errorf("%w: %s must be an action id", domain.InvalidArgument, lastEventIDHeader)
+	}
+
+	return id, true, nil
+}
@@ -0,0 +1,150 @@
+package application_test
+
+import (
+	"bufio"
+	"context"
+	application_action "github.com/JoseBeteta/surfe/app/application"
+	domain_action "github.com/JoseBeteta/surfe/app/domain"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/eventbus"
+	common_http "github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/JoseBeteta/surfe/app/infrastructure/stream"
+	"github.com/JoseBeteta/surfe/test/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newStreamServer(t *testing.T, mockRepo *MockActionReadRepository) (*httptest.Server, *eventbus.Bus, *stream.Hub) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	bus := eventbus.New()
+	hub := stream.NewHub(10)
+	require.NoError(t, hub.Subscribe(bus))
+
+	logger := mocks.NewNullLogger()
+	router := gin.New()
+	handler := application_action.NewStreamHandler(hub, mockRepo, time.Hour, logger, common_http.NewHttpMapper(logger))
+	handler.Initialize(router)
+
+	// served with the production timeouts, which streams must not be subject to
+	server := httptest.NewServer(common_http.NewServer(common_http.Config{
+		HandlerTimeout: 50 * time.Millisecond,
+	}, router).Handler)
+	t.Cleanup(server.Close)
+
+	return server, bus, hub
+}
+
+func openStream(t *testing.T, url, lastEventID string) (*bufio.Reader, context.CancelFunc) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream"))
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return bufio.NewReader(resp.Body), cancel
+}
+
+// readEvent returns the id and data lines of the next event
+func readEvent(t *testing.T, reader *bufio.Reader) (string, string) {
+	t.Helper()
+
+	var id, data string
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimRight(line, "\n")
+
+		switch {
+		case line == "" && id != "":
+			return id, data
+		case strings.HasPrefix(line, "id:"):
+			id = line[len("id:"):]
+		case strings.HasPrefix(line, "data:"):
+			data = line[len("data:"):]
+		}
+	}
+}
+
+func waitForSubscriptions(t *testing.T, hub *stream.Hub, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool { return hub.Len() == n }, time.Second, 5*time.Millisecond)
+}
+
+func publish(t *testing.T, bus *eventbus.Bus, action domain_action.Action) {
+	t.Helper()
+	require.NoError(t, bus.Publish(domain_action.ActionRecordedEvent, domain_action.ActionRecorded{Action: action}))
+}
+
+func TestHandleStreamActions(t *testing.T) {
+	server, bus, hub := newStreamServer(t, new(MockActionReadRepository))
+
+	reader, cancel := openStream(t, server.URL+"/api/actions/stream?userId=1&type=WELCOME", "")
+	waitForSubscriptions(t, hub, 1)
+
+	publish(t, bus, domain_action.Action{ID: 1, Type: "WELCOME", UserID: 2})
+	publish(t, bus, domain_action.Action{ID: 2, Type: "ADD_CONTACT", UserID: 1})
+	// published after the handler timeout, the stream is still open
+	time.Sleep(100 * time.Millisecond)
+	publish(t, bus, domain_action.Action{ID: 3, Type: "WELCOME", UserID: 1})
+
+	id, data := readEvent(t, reader)
+	assert.Equal(t, "3", id)
+	assert.Contains(t, data, `"type":"WELCOME"`)
+
+	cancel()
+	waitForSubscriptions(t, hub, 0)
+}
+
+func TestHandleStreamActionsResume(t *testing.T) {
+	mockRepo := new(MockActionReadRepository)
+	mockRepo.On("GetAll").Return([]domain_action.Action{
+		{ID: 5, Type: "WELCOME", UserID: 1},
+		{ID: 3, Type: "WELCOME", UserID: 1},
+		{ID: 4, Type: "WELCOME", UserID: 1},
+	}, nil)
+	server, bus, hub := newStreamServer(t, mockRepo)
+
+	reader, cancel := openStream(t, server.URL+"/api/actions/stream", "3")
+	defer cancel()
+	waitForSubscriptions(t, hub, 1)
+
+	// already replayed
+	publish(t, bus, domain_action.Action{ID: 5, Type: "WELCOME", UserID: 1})
+	publish(t, bus, domain_action.Action{ID: 6, Type: "WELCOME", UserID: 1})
+
+	for _, expected := range []string{"4", "5", "6"} {
+		id, _ := readEvent(t, reader)
+		assert.Equal(t, expected, id)
+	}
+}
+
+func TestHandleStreamActionsInvalidFilter(t *testing.T) {
+	server, _, _ := newStreamServer(t, new(MockActionReadRepository))
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/actions/stream?userId=abc", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
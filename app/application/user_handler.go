@@ -38,8 +38,8 @@ func (h *UserHandler) Initialize(r *gin.Engine, middlewares ...gin.HandlerFunc)
 	group := r.Group("api/users")
 
 	group.Use(
-		http.Consume(http.V1),
-		http.Produce(http.V1),
+		http.Consume(http.V1, http.V2),
+		http.Produce(http.V1, http.V2),
 	)
 	group.Use(middlewares...)
 
@@ -62,9 +62,9 @@ func (h *UserHandler) HandleGetUserInfo(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userReadRepository.GetByID(userId)
+	user, err := h.userReadRepository.GetByID(c.Request.Context(), userId)
 	if err != nil {
-		h.logger.Warn("user not found", "id", userId)
+		h.logger.WarnContext(c.Request.Context(), "user not found", "id", userId)
 		h.httpMapper.ErrorResponse(c, err)
 		return
 	}
@@ -1,6 +1,7 @@
 package application_test
 
 import (
+	"context"
 	"encoding/json"
 	user_application "github.com/JoseBeteta/surfe/app/application"
 	domainUser "github.com/JoseBeteta/surfe/app/domain"
@@ -21,11 +22,17 @@ type MockUserReadRepository struct {
 	mock.Mock
 }
 
-func (m *MockUserReadRepository) GetByID(id int) (domainUser.User, error) {
+func (m *MockUserReadRepository) GetByID(_ context.Context, id int) (domainUser.User, error) {
 	args := m.Called(id)
 	return args.Get(0).(domainUser.User), args.Error(1)
 }
 
+func (m *MockUserReadRepository) GetByIDs(_ context.Context, ids []int) (map[int]domainUser.User, error) {
+	args := m.Called(ids)
+	users, _ := args.Get(0).(map[int]domainUser.User)
+	return users, args.Error(1)
+}
+
 func TestHandleGetUserInfo(t *testing.T) {
 	mockRepo := new(MockUserReadRepository)
 
@@ -43,6 +50,7 @@ func TestHandleGetUserInfo(t *testing.T) {
 
 	rec := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/users/1", nil)
 
 	c.Params = gin.Params{
 		{Key: "id", Value: "1"},
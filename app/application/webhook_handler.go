@@ -0,0 +1,198 @@
+package application
+
+import (
+	"errors"
+	"github.com/JoseBeteta/surfe/app/domain"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log/slog"
+	"time"
+)
+
+const (
+	webhookIDParameterKey = "id"
+	webhookIDLength       = 12
+)
+
+// WebhookHandler of webhook handler http requests
+type WebhookHandler struct {
+	webhookRepository domain.WebhookRepository
+	logger            slog.Logger
+	httpMapper        *http.Mapper
+}
+
+// NewWebhookHandler creates a new handler for webhook subscriptions
+func NewWebhookHandler(
+	webhookRepository domain.WebhookRepository,
+	logger slog.Logger,
+	httpMapper *http.Mapper,
+) *WebhookHandler {
+	return &WebhookHandler{
+		webhookRepository,
+		logger,
+		httpMapper,
+	}
+}
+
+func (h *WebhookHandler) Initialize(r *gin.Engine, middlewares ...gin.HandlerFunc) {
+	group := r.Group("api/webhooks")
+
+	group.Use(
+		http.Consume(http.V1, http.V2),
+		http.Produce(http.V1, http.V2),
+	)
+	group.Use(middlewares...)
+
+	group.POST("", h.HandleCreateWebhook)
+	group.GET("", h.HandleListWebhooks)
+	group.DELETE("/:id", h.HandleDeleteWebhook)
+	group.GET("/:id/deliveries", h.HandleListDeliveries)
+}
+
+type CreateWebhookRequest struct {
+	URL         string   `json:"url" binding:"required,url"`
+	Secret      string   `json:"secret" binding:"required,min=16"`
+	ActionTypes []string `json:"actionTypes" binding:"dive,required"`
+}
+
+type WebhookResponse struct {
+	ID          string   `json:"id"`
+	URL         string   `json:"url"`
+	ActionTypes []string `json:"actionTypes"`
+	CreatedAt   string   `json:"createdAt"`
+}
+
+type DeliveryResponse struct {
+	ID             string         `json:"id"`
+	Action         ActionResponse `json:"action"`
+	Status         string         `json:"status"`
+	Attempts       int            `json:"attempts"`
+	LastStatusCode int            `json:"lastStatusCode,omitempty"`
+	LastError      string         `json:"lastError,omitempty"`
+	NextAttemptAt  string         `json:"nextAttemptAt,omitempty"`
+	DeliveredAt    string         `json:"deliveredAt,omitempty"`
+	CreatedAt      string         `json:"createdAt"`
+}
+
+// newWebhookResponse maps a subscription into its response, the secret is never returned
+func newWebhookResponse(subscription domain.WebhookSubscription) WebhookResponse {
+	actionTypes := subscription.ActionTypes
+	if actionTypes == nil {
+		actionTypes = []string{}
+	}
+
+	return WebhookResponse{
+		ID:          subscription.ID,
+		URL:         subscription.URL,
+		ActionTypes: actionTypes,
+		CreatedAt:   subscription.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func newDeliveryResponse(delivery domain.WebhookDelivery) DeliveryResponse {
+	response := DeliveryResponse{
+		ID:             delivery.ID,
+		Action:         newActionResponse(delivery.Action),
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		CreatedAt:      delivery.CreatedAt.Format(time.RFC3339),
+	}
+	if delivery.Status == domain.DeliveryPending {
+		response.NextAttemptAt = delivery.NextAttemptAt.Format(time.RFC3339)
+	}
+	if delivery.DeliveredAt != nil {
+		response.DeliveredAt = delivery.DeliveredAt.Format(time.RFC3339)
+	}
+
+	return response
+}
+
+// HandleCreateWebhook subscribes a receiver to the actions recorded
+func (h *WebhookHandler) HandleCreateWebhook(c *gin.Context) {
+	var request CreateWebhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = http.ErrEmptyBody
+		}
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	id, err := randomHex(webhookIDLength)
+	if err != nil {
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	subscription := domain.WebhookSubscription{
+		ID:          id,
+		URL:         request.URL,
+		Secret:      request.Secret,
+		ActionTypes: request.ActionTypes,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if err := h.webhookRepository.SaveSubscription(subscription); err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "webhook subscription could not be stored", "url", request.URL)
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	h.httpMapper.CreatedResponse(c, newWebhookResponse(subscription))
+}
+
+// HandleListWebhooks retrieves every subscription
+func (h *WebhookHandler) HandleListWebhooks(c *gin.Context) {
+	subscriptions, err := h.webhookRepository.GetSubscriptions()
+	if err != nil {
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	response := make([]WebhookResponse, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		response = append(response, newWebhookResponse(subscription))
+	}
+
+	h.httpMapper.OkResponse(c, response)
+}
+
+// HandleDeleteWebhook unsubscribes a receiver
+func (h *WebhookHandler) HandleDeleteWebhook(c *gin.Context) {
+	id := c.Param(webhookIDParameterKey)
+
+	if err := h.webhookRepository.DeleteSubscription(id); err != nil {
+		h.logger.WarnContext(c.Request.Context(), "webhook subscription not found", "id", id)
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	h.httpMapper.NoContentResponse(c)
+}
+
+// HandleListDeliveries retrieves the delivery log of a subscription, the newest first
+func (h *WebhookHandler) HandleListDeliveries(c *gin.Context) {
+	id := c.Param(webhookIDParameterKey)
+
+	if _, err := h.webhookRepository.GetSubscription(id); err != nil {
+		h.logger.WarnContext(c.Request.Context(), "webhook subscription not found", "id", id)
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	deliveries, err := h.webhookRepository.GetDeliveries(id)
+	if err != nil {
+		h.httpMapper.ErrorResponse(c, err)
+		return
+	}
+
+	response := make([]DeliveryResponse, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		response = append(response, newDeliveryResponse(delivery))
+	}
+
+	h.httpMapper.OkResponse(c, response)
+}
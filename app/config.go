@@ -1,19 +1,56 @@
 package app
 
 import (
+	"fmt"
+
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/configx"
 	"github.com/JoseBeteta/surfe/app/infrastructure/common/http"
-	"time"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/logging"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/tracing"
+	"github.com/JoseBeteta/surfe/app/infrastructure/consumer"
+	"github.com/JoseBeteta/surfe/app/infrastructure/persistence"
+	"github.com/JoseBeteta/surfe/app/infrastructure/stream"
+	"github.com/JoseBeteta/surfe/app/infrastructure/webhook"
 )
 
+const serviceName = "surfe"
+
 const (
-	serviceName               = "surfe"
-	defaultConcurrentRoutines = 20
+	// StorageFile reads users and actions from data files
+	StorageFile = "file"
+	// StorageLog stores actions in an append-only log, users are read from data files
+	StorageLog = "log"
+	// StorageBolt stores users and actions in an embedded database file
+	StorageBolt = "bolt"
 )
 
 // Config overall configuration struct
 type Config struct {
 	ServiceName string
 	Server      http.Config
+	Auth        http.AuthConfig
+	RateLimit   http.RateLimitConfig
+	Cache       http.CacheConfig
+	Versions    http.VersionConfig
+	Storage     StorageConfig
+	Webhooks    webhook.Config
+	Stream      stream.Config
+	Consumer    consumer.Config
+	Tracing     tracing.Config
+	Log         logging.Config
+	Reload      configx.ReloadConfig
+}
+
+// StorageConfig selects the backend the repositories are built on and the data files they read
+type StorageConfig struct {
+	Backend     string `env:"STORAGE_BACKEND" env-default:"file" validate:"oneof=file log bolt"`
+	UsersFile   string `env:"USERS_FILE" env-default:"users.json" validate:"required"`
+	ActionsFile string `env:"ACTIONS_FILE" env-default:"actions.json" validate:"required"`
+	// UsersCSVColumns and ActionsCSVColumns map the fields to the columns of CSV data files, e.g. "userId=user_id"
+	UsersCSVColumns   string `env:"USERS_CSV_COLUMNS"`
+	ActionsCSVColumns string `env:"ACTIONS_CSV_COLUMNS"`
+	ActionsLogDir     string `env:"ACTIONS_LOG_DIR" env-default:"data/actions"`
+	BoltPath          string `env:"BOLT_PATH" env-default:"data/surfe.db"`
 }
 
 // ConfigLoader interface for the config loader
@@ -26,11 +63,30 @@ func LoadConfig(loader ConfigLoader) (*Config, error) {
 	cfg := &Config{}
 	err := loader.Load(cfg)
 	cfg.ServiceName = serviceName
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, validate(cfg)
+}
 
-	cfg.Server.ReadTimeout = 5 * time.Second
-	cfg.Server.HandlerTimeout = 5 * time.Second
-	cfg.Server.WriteTimeout = cfg.Server.ReadTimeout + cfg.Server.HandlerTimeout
-	cfg.Server.IdleTimeout = 15 * time.Second
+// validate checks the settings parsed by the components, so invalid ones are rejected on load
+func validate(cfg *Config) error {
+	if _, err := http.ParseRateLimits(cfg.RateLimit.Limits); err != nil {
+		return fmt.Errorf("configuration RATE_LIMITS: %w", err)
+	}
+	if _, err := http.ParseRateLimits(cfg.RateLimit.IPLimits); err != nil {
+		return fmt.Errorf("configuration RATE_LIMITS_IP: %w", err)
+	}
+	if _, err := http.NewCachePolicy(cfg.Cache); err != nil {
+		return fmt.Errorf("configuration HTTP_CACHE_ROUTES: %w", err)
+	}
+	if _, err := persistence.ParseCSVMapping(cfg.Storage.UsersCSVColumns); err != nil {
+		return fmt.Errorf("configuration USERS_CSV_COLUMNS: %w", err)
+	}
+	if _, err := persistence.ParseCSVMapping(cfg.Storage.ActionsCSVColumns); err != nil {
+		return fmt.Errorf("configuration ACTIONS_CSV_COLUMNS: %w", err)
+	}
 
-	return cfg, err
+	return nil
 }
@@ -1,6 +1,24 @@
 package domain
 
-import "time"
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	ActionAddContact   = "ADD_CONTACT"
+	ActionConnectCRM   = "CONNECT_CRM"
+	ActionEditContact  = "EDIT_CONTACT"
+	ActionReferUser    = "REFER_USER"
+	ActionViewContacts = "VIEW_CONTACTS"
+	ActionWelcome      = "WELCOME"
+)
+
+// ActionTypes are the types an action may have, the ActionType enum of the OpenAPI spec lists the same ones
+var ActionTypes = []string{
+	ActionAddContact, ActionConnectCRM, ActionEditContact, ActionReferUser, ActionViewContacts, ActionWelcome,
+}
 
 type Action struct {
 	ID         int       `json:"id"`
@@ -9,3 +27,23 @@ type Action struct {
 	TargetUser int       `json:"targetUser,omitempty"`
 	CreatedAt  time.Time `json:"createdAt"`
 }
+
+// Validate checks the action holds the minimum data required to be stored
+func (a Action) Validate() error {
+	if a.ID < 0 {
+		return fmt.Errorf("%w: action id must not be negative", InvalidArgument)
+	}
+	if a.Type == "" {
+		return fmt.Errorf("%w: action type is required", InvalidArgument)
+	}
+	if !slices.Contains(ActionTypes, a.Type) {
+		return fmt.Errorf("%w: unknown action type %q", InvalidArgument, a.Type)
+	}
+	if a.UserID < 0 {
+		return fmt.Errorf("%w: action userId must not be negative", InvalidArgument)
+	}
+	if a.CreatedAt.IsZero() {
+		return fmt.Errorf("%w: action createdAt is required", InvalidArgument)
+	}
+	return nil
+}
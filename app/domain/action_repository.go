@@ -1,8 +1,26 @@
 package domain
 
+import (
+	"context"
+	"errors"
+)
+
+// UnassignedID is the ID of an action not stored yet, the repository assigns the next one on save
+const UnassignedID = -1
+
+var (
+	ActionAlreadyExists = errors.New("an action with the same id already exists")
+)
+
 // ActionReadRepository is the interface for the Repository used to fetch data from storage
 type ActionReadRepository interface {
-	CountByUserID(userID int) (int, error)
-	GetNextActionProbabilities(actionType string) (map[string]float64, error)
-	GetAll() ([]Action, error)
+	CountByUserID(ctx context.Context, userID int) (int, error)
+	GetNextActionProbabilities(ctx context.Context, actionType string) (map[string]float64, error)
+	GetAll(ctx context.Context) ([]Action, error)
+}
+
+// ActionWriteRepository is the interface for the Repository used to store data
+type ActionWriteRepository interface {
+	// Save stores the action and returns it as stored, with its ID assigned when it was UnassignedID
+	Save(ctx context.Context, action Action) (Action, error)
 }
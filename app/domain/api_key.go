@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	APIKeyNotFound = errors.New("api key not found")
+)
+
+// APIKey authenticates a service calling the API, only the hash of the secret is stored
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"hash"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// Active reports whether the key is neither revoked nor expired at now
+func (k APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+type APIKeyRepository interface {
+	// Save creates or replaces a key
+	Save(key APIKey) error
+	GetByID(id string) (APIKey, error)
+	GetAll() ([]APIKey, error)
+	// MarkUsed records the last time the key authenticated a request
+	MarkUsed(id string, at time.Time) error
+	// Revoke records the key was revoked at, keeping the time it was first revoked, and returns it
+	Revoke(id string, at time.Time) (APIKey, error)
+}
@@ -0,0 +1,26 @@
+package domain
+
+import "context"
+
+// ActionRecordedEvent is the name of the event published once an action is stored
+const ActionRecordedEvent = "action.recorded"
+
+// ActionRecorded is the payload of the ActionRecordedEvent
+type ActionRecorded struct {
+	Action Action
+}
+
+// EventPublisher is the interface used to publish domain events
+type EventPublisher interface {
+	Publish(name string, payload any) error
+}
+
+// ReferralIndexReader is implemented by read models keeping the referral index up to date
+type ReferralIndexReader interface {
+	GetReferralIndex(ctx context.Context) (map[int]int, error)
+}
+
+// NextActionCountReader is implemented by read models keeping the count of transitions between actions up to date
+type NextActionCountReader interface {
+	GetNextActionCounts(ctx context.Context, actionType string) (map[string]int, error)
+}
@@ -2,11 +2,13 @@ package domain
 
 import (
 	"errors"
+	"fmt"
 	"time"
 )
 
 var (
 	InvalidArgument = errors.New("the argument provided is invalid")
+	UserNotFound    = errors.New("user not found")
 )
 
 type User struct {
@@ -14,3 +16,17 @@ type User struct {
 	Name      string    `json:"name"`
 	CreatedAt time.Time `json:"createdAt"`
 }
+
+// Validate checks the user holds the minimum data required to be stored
+func (u User) Validate() error {
+	if u.ID < 0 {
+		return fmt.Errorf("%w: user id must not be negative", InvalidArgument)
+	}
+	if u.Name == "" {
+		return fmt.Errorf("%w: user name is required", InvalidArgument)
+	}
+	if u.CreatedAt.IsZero() {
+		return fmt.Errorf("%w: user createdAt is required", InvalidArgument)
+	}
+	return nil
+}
@@ -1,6 +1,11 @@
 package domain
 
+import "context"
+
 // UserReadRepository is the interface for the Repository used to fetch data from storage
 type UserReadRepository interface {
-	GetByID(id int) (User, error)
+	// GetByID fails with UserNotFound when there is no user with the id
+	GetByID(ctx context.Context, id int) (User, error)
+	// GetByIDs returns the users with the ids by id in a single read, the ids without a user are left out
+	GetByIDs(ctx context.Context, ids []int) (map[int]User, error)
 }
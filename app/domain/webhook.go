@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// DeliveryPending the delivery is waiting for its next attempt
+	DeliveryPending = "pending"
+	// DeliverySucceeded the receiver acknowledged the delivery
+	DeliverySucceeded = "succeeded"
+	// DeliveryDeadLettered every attempt failed, the delivery won't be retried
+	DeliveryDeadLettered = "dead_lettered"
+)
+
+var (
+	WebhookNotFound       = errors.New("webhook subscription not found")
+	DeliveryAlreadyExists = errors.New("a webhook delivery with the same id already exists")
+)
+
+// WebhookSubscription is a receiver notified of the actions recorded
+type WebhookSubscription struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret"`
+	ActionTypes []string  `json:"actionTypes"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Matches reports whether the subscription wants to be notified of actionType,
+// a subscription without action types wants every action
+func (s WebhookSubscription) Matches(actionType string) bool {
+	if len(s.ActionTypes) == 0 {
+		return true
+	}
+
+	for _, t := range s.ActionTypes {
+		if t == actionType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is the notification of an action to a subscription and the outcome of its attempts
+type WebhookDelivery struct {
+	ID             string     `json:"id"`
+	SubscriptionID string     `json:"subscriptionId"`
+	Action         Action     `json:"action"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastStatusCode int        `json:"lastStatusCode,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	NextAttemptAt  time.Time  `json:"nextAttemptAt"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// WebhookRepository is the interface for the Repository used to store subscriptions and deliveries
+type WebhookRepository interface {
+	SaveSubscription(subscription WebhookSubscription) error
+	GetSubscriptions() ([]WebhookSubscription, error)
+	GetSubscription(id string) (WebhookSubscription, error)
+	DeleteSubscription(id string) error
+	// CreateDelivery creates a delivery, failing with DeliveryAlreadyExists when there is one with its ID
+	CreateDelivery(delivery WebhookDelivery) error
+	// SaveDelivery creates or updates a delivery by its ID
+	SaveDelivery(delivery WebhookDelivery) error
+	// GetDueDeliveries returns pending deliveries whose next attempt is not after now
+	GetDueDeliveries(now time.Time, limit int) ([]WebhookDelivery, error)
+	GetDeliveries(subscriptionID string) ([]WebhookDelivery, error)
+}
+
+// ActionOutbox gives access to the actions recorded but not yet dispatched to webhook subscriptions
+// Storage backends implement it writing the outbox entry together with the action
+type ActionOutbox interface {
+	PendingActions(limit int) ([]Action, error)
+	MarkDispatched(actionIDs ...int) error
+}
@@ -4,22 +4,30 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 
-	"dario.cat/mergo"
 	"github.com/go-playground/validator"
-	"github.com/ilyakaznacheev/cleanenv"
 )
 
 // Loader represents a config loader
+// Every value is taken from the source with the highest priority setting it, from lowest to highest:
+// the env-default tag, the base file, the profile file, the .env file, the environment variables and the CLI flags
 type Loader struct {
 	defaultFileName string
 	fileFlag        string
 	fileName        string
+	profileFlag     string
+	profileVariable string
+	onlyEnvironment bool
+	flags           *flag.FlagSet
+	args            []string
+	origins         []Origin
 }
 
-// WithDefaultFileName allows you to specify a custom default file name
-// Default file name will be check in case no other file name is specified
+// WithDefaultFileName allows you to specify a custom .env file name
+// The .env file is read when it exists, its values take priority over the ones of the base and profile files
 func (l *Loader) WithDefaultFileName(flnm string) *Loader {
 	l.defaultFileName = flnm
 
@@ -27,8 +35,7 @@ func (l *Loader) WithDefaultFileName(flnm string) *Loader {
 }
 
 // WithFileFlag allows you to specify a custom file flag from where
-// file name will be read. This file name will be used in case it is spcified the flag
-// before to backup into the default one
+// the base file name will be read. The base file is config.yaml when the flag is not given
 // Take into consideration that going for flag will disable filename option
 func (l *Loader) WithFileFlag(f string) *Loader {
 	l.fileFlag = f
@@ -37,7 +44,7 @@ func (l *Loader) WithFileFlag(f string) *Loader {
 	return l
 }
 
-// WithFileName allows you to specify an specific file name
+// WithFileName allows you to specify an specific base file name
 // Take into consideration that going for filename will disable flag option
 func (l *Loader) WithFileName(flnm string) *Loader {
 	l.fileName = flnm
@@ -46,62 +53,77 @@ func (l *Loader) WithFileName(flnm string) *Loader {
 	return l
 }
 
+// WithProfile allows you to specify the flag and the environment variable selecting the profile,
+// the profile file sits next to the base file, e.g. config.production.yaml for the production profile
+func (l *Loader) WithProfile(flagName, variable string) *Loader {
+	l.profileFlag = flagName
+	l.profileVariable = variable
+
+	return l
+}
+
+// WithFlags allows you to specify the flag set and the arguments the CLI flags are parsed from
+// A flag is defined for every environment variable but the secrets, e.g. --http-port for HTTP_PORT
+func (l *Loader) WithFlags(flags *flag.FlagSet, args []string) *Loader {
+	l.flags = flags
+	l.args = args
+
+	return l
+}
+
 // OnlyEnvironment makes loader only read from environment variables
 func (l *Loader) OnlyEnvironment() *Loader {
-	l.fileFlag = ""
-	l.fileName = ""
+	l.onlyEnvironment = true
 
 	return l
 }
 
-// Load will fill the specified configuration structure from the source
-func (l Loader) Load(cfg interface{}) error {
+// Load will fill the specified configuration structure from the sources
+func (l *Loader) Load(cfg interface{}) error {
 	if reflect.ValueOf(cfg).Kind() != reflect.Ptr {
 		return fmt.Errorf("configuration has to be a pointer to a struct but got %T", cfg)
 	}
 
-	if err := cleanenv.ReadEnv(cfg); err != nil {
-		return fmt.Errorf("error reading environment variables: %v", err)
+	fields, err := collectFields(reflect.ValueOf(cfg).Elem())
+	if err != nil {
+		return err
 	}
 
-	// Copying structure to parse file configuration without affecting
-	// environment variables in case there are some defined
-	fileCfg := reflect.New(reflect.ValueOf(cfg).Elem().Type()).Interface()
-	fileName := &l.fileName
-
-	// Puts priority in case file name was specified instead of going for the flag
-	// If both are empty we do nothing...
-	if *fileName == "" && l.fileFlag != "" {
-		f := flag.Lookup(l.fileFlag)
-
-		if f == nil {
-			fileName = flag.String(l.fileFlag, "", "Specify configuration file")
-			flag.Parse()
-		} else {
-			*fileName = f.Value.String()
-		}
+	flags, err := l.parseFlags(fields)
+	if err != nil {
+		return err
+	}
 
-		flag.Parse()
+	layers, err := l.layers(fields, flags)
+	if err != nil {
+		return err
+	}
 
-		// Only will set to read default config file if loader was configured to expect file flag
-		// but the application was executed without specifying it and backup config file really exists
-		if _, err := os.Stat(l.defaultFileName); *fileName == "" && err == nil {
-			*fileName = l.defaultFileName
+	// every layer overrides the values of the previous ones
+	effective := map[string]Origin{}
+	for _, layer := range layers {
+		for name, value := range layer.values {
+			effective[name] = Origin{Name: name, Value: value, Source: layer.source(name)}
 		}
 	}
 
-	// If it ends up in no file name defined neither from config flag, specific file name
-	// nor backup file we don't try to read anything
-	if *fileName != "" {
-		if err := cleanenv.ReadConfig(*fileName, fileCfg); err != nil {
-			return fmt.Errorf("error reading configuration from file: %v", err)
+	origins := make([]Origin, 0, len(fields))
+	for _, field := range fields {
+		origin, ok := effective[field.name]
+		if ok {
+			if err := field.set(origin.Value); err != nil {
+				return fmt.Errorf("error reading %s from %s: %v", field.name, origin.Source, err)
+			}
+		} else {
+			origin = Origin{Name: field.name, Value: format(field.value)}
 		}
-	}
 
-	// This will always put priority into the configuration comming from env variables
-	if err := mergo.MergeWithOverwrite(cfg, fileCfg); err != nil {
-		return fmt.Errorf("unexpected error merging configuration (%v)", err)
+		if field.secret {
+			origin.Value = redact(origin.Value)
+		}
+		origins = append(origins, origin)
 	}
+	l.origins = origins
 
 	// Validating in case it was defined within the structure
 	if err := validator.New().Struct(cfg); err != nil {
@@ -111,11 +133,124 @@ func (l Loader) Load(cfg interface{}) error {
 	return nil
 }
 
+// Explain returns the effective value of every environment variable of the last configuration loaded and
+// the source it was taken from, secrets redacted
+func (l *Loader) Explain() []Origin {
+	return l.origins
+}
+
+// Files returns the configuration files the loader reads when they exist: the base, the profile and the .env file
+func (l *Loader) Files() []string {
+	if l.onlyEnvironment {
+		return nil
+	}
+
+	files := []string{l.baseFileName()}
+	if profile := l.profile(); profile != "" {
+		files = append(files, profileFileName(l.baseFileName(), profile))
+	}
+	if l.defaultFileName != "" {
+		files = append(files, l.defaultFileName)
+	}
+
+	return files
+}
+
+// baseFileName returns the file given with the file flag or name, config.yaml otherwise
+func (l *Loader) baseFileName() string {
+	if l.fileName != "" {
+		return l.fileName
+	}
+	if f := l.flagSet().Lookup(l.fileFlag); l.fileFlag != "" && f != nil && f.Value.String() != "" {
+		return f.Value.String()
+	}
+
+	return defaultBaseFileName
+}
+
+// profile returns the profile given with the profile flag, or else the profile variable
+func (l *Loader) profile() string {
+	if f := l.flagSet().Lookup(l.profileFlag); l.profileFlag != "" && f != nil && f.Value.String() != "" {
+		return f.Value.String()
+	}
+	if l.profileVariable != "" {
+		return os.Getenv(l.profileVariable)
+	}
+
+	return ""
+}
+
+// profileFileName inserts the profile before the extension of the base file, config.yaml becomes config.<profile>.yaml
+func profileFileName(base, profile string) string {
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "." + profile + ext
+}
+
+func (l *Loader) flagSet() *flag.FlagSet {
+	if l.flags == nil {
+		return flag.CommandLine
+	}
+	return l.flags
+}
+
+// parseFlags defines the file, profile and configuration flags missing and parses the arguments,
+// returning the values of the configuration flags given by environment variable
+func (l *Loader) parseFlags(fields []field) (map[string]string, error) {
+	flags := l.flagSet()
+
+	if l.fileFlag != "" && flags.Lookup(l.fileFlag) == nil {
+		flags.String(l.fileFlag, "", "Specify configuration file")
+	}
+	if l.profileFlag != "" && flags.Lookup(l.profileFlag) == nil {
+		flags.String(l.profileFlag, "", "Specify configuration profile")
+	}
+
+	names := map[string]string{}
+	for _, field := range fields {
+		// secrets given as arguments would show in the process list and the shell history
+		if field.secret {
+			continue
+		}
+
+		name := flagName(field.name)
+		names[name] = field.name
+		if flags.Lookup(name) == nil {
+			flags.String(name, "", "Overrides "+field.name)
+		}
+	}
+
+	if !flags.Parsed() {
+		args := l.args
+		if l.flags == nil {
+			args = os.Args[1:]
+		}
+		if err := flags.Parse(args); err != nil {
+			return nil, fmt.Errorf("error parsing flags: %v", err)
+		}
+	}
+
+	values := map[string]string{}
+	flags.Visit(func(f *flag.Flag) {
+		if variable, ok := names[f.Name]; ok {
+			values[variable] = f.Value.String()
+		}
+	})
+
+	return values, nil
+}
+
+// flagName returns the flag of the environment variable, --http-port for HTTP_PORT
+func flagName(variable string) string {
+	return strings.ToLower(strings.ReplaceAll(variable, "_", "-"))
+}
+
 // NewLoader generates a default config loader
 func NewLoader() *Loader {
 	return &Loader{
 		defaultFileName: ".env",
 		fileFlag:        "config",
 		fileName:        "",
+		profileFlag:     "profile",
+		profileVariable: "APP_PROFILE",
 	}
 }
@@ -81,7 +81,8 @@ SERVICE_K2=from-file-k2
 }
 
 func TestLoaderWithSpecificFilename(t *testing.T) {
-	os.Setenv("SERVICE_K2", "it-should-be-overwritten-from-env-k2")
+	os.Clearenv()
+	os.Setenv("SERVICE_K2", "from-env-k2")
 
 	file, err := fileWithConfig(`
 SERVICE_K1=from-file-k1
@@ -103,7 +104,8 @@ SERVICE_K2=from-file-k2
 		t.Error("unexpected error:", err)
 	}
 
-	if config.Key1 != "from-file-k1" || config.Key2 != "from-file-k2" {
+	// environment variables take priority over the file
+	if config.Key1 != "from-file-k1" || config.Key2 != "from-env-k2" {
 		t.Errorf("invalid value loading config")
 	}
 }
@@ -0,0 +1,80 @@
+package configx
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+)
+
+// Redacted replaces the value of the secrets in the dumped configuration
+const Redacted = "[REDACTED]"
+
+// Dump writes the configuration as the environment variables setting it, one NAME=value per line,
+// so the effective configuration can be reviewed and reused as an env file.
+// The values of the fields tagged with `secret:"true"` are redacted, only the password of URLs is
+func Dump(w io.Writer, cfg interface{}) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("configuration has to be a struct but got %T", cfg)
+	}
+
+	return dumpStruct(w, v, "")
+}
+
+func dumpStruct(w io.Writer, v reflect.Value, section string) error {
+	header := section != ""
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, ok := field.Tag.Lookup("env")
+		if !ok {
+			if v.Field(i).Kind() == reflect.Struct {
+				if err := dumpStruct(w, v.Field(i), field.Name); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// sections without variables are not written
+		if header {
+			if _, err := fmt.Fprintf(w, "# %s\n", section); err != nil {
+				return err
+			}
+			header = false
+		}
+
+		value := format(v.Field(i))
+		if field.Tag.Get("secret") == "true" {
+			value = redact(value)
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// redact hides the secret, keeping the rest of a URL with a password to tell where it points to
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			return u.Redacted()
+		}
+	}
+
+	return Redacted
+}
@@ -0,0 +1,159 @@
+package configx
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// format returns the value as it is set, the text of the values set as text, e.g. times in RFC 3339
+func format(v reflect.Value) string {
+	if marshaler, ok := v.Interface().(encoding.TextMarshaler); ok {
+		if text, err := marshaler.MarshalText(); err == nil {
+			return string(text)
+		}
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// field is a configuration field set by an environment variable
+type field struct {
+	name string
+	// path is the key of the field in the files of the struct layout, e.g. server.readtimeout
+	path       string
+	def        string
+	hasDefault bool
+	secret     bool
+	value      reflect.Value
+}
+
+// collectFields returns the fields tagged with env, nested structs included
+func collectFields(v reflect.Value) ([]field, error) {
+	return collectNestedFields(v, "")
+}
+
+func collectNestedFields(v reflect.Value, prefix string) ([]field, error) {
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("configuration has to be a pointer to a struct but got %s", v.Type())
+	}
+
+	var fields []field
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		if !structField.IsExported() {
+			continue
+		}
+
+		name, ok := structField.Tag.Lookup("env")
+		if !ok {
+			if v.Field(i).Kind() == reflect.Struct && !reflect.PointerTo(structField.Type).Implements(textUnmarshalerType) {
+				nested, err := collectNestedFields(v.Field(i), joinPath(prefix, structField.Name))
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, nested...)
+			}
+			continue
+		}
+
+		if !settable(structField.Type) {
+			return nil, fmt.Errorf("%s: unsupported type %s", name, structField.Type)
+		}
+
+		def, hasDefault := structField.Tag.Lookup("env-default")
+		fields = append(fields, field{
+			name:       name,
+			path:       joinPath(prefix, structField.Name),
+			def:        def,
+			hasDefault: hasDefault,
+			secret:     structField.Tag.Get("secret") == "true",
+			value:      v.Field(i),
+		})
+	}
+
+	return fields, nil
+}
+
+// joinPath appends the key to the path of a section, keys are matched ignoring case, dashes and underscores
+// the way the struct layout was read, e.g. HTTPPort, httpport and http-port
+func joinPath(prefix, key string) string {
+	key = strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(key))
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// settable reports whether set can parse values into fields of the type
+func settable(t reflect.Type) bool {
+	if t == durationType || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// set parses the value into the field
+func (f field) set(value string) error {
+	if f.value.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(d))
+		return nil
+	}
+
+	if unmarshaler, ok := f.value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(value))
+	}
+
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, f.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, f.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.value.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, f.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.value.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", f.value.Type())
+	}
+
+	return nil
+}
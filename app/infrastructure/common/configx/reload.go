@@ -0,0 +1,141 @@
+package configx
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ReloadConfig are the configurations related to reloading the configuration without restarting
+type ReloadConfig struct {
+	Enabled bool `env:"CONFIG_RELOAD_ENABLED" env-default:"false"`
+	// Interval is how often the configuration file is checked for changes
+	Interval time.Duration `env:"CONFIG_RELOAD_INTERVAL" env-default:"5s" validate:"gt=0"`
+}
+
+// Subscriber is notified with the previous and the new configuration once a reload changes it
+type Subscriber[T any] func(old, new *T)
+
+// Reloader loads the configuration again when its file changes or the process receives SIGHUP,
+// notifying the subscribers of the changes. Invalid configurations are rejected, keeping the current one
+type Reloader[T any] struct {
+	load     func() (*T, error)
+	logger   slog.Logger
+	files    []string
+	interval time.Duration
+
+	current atomic.Pointer[T]
+	// mutex serializes the reloads, so subscribers are notified of the changes in order
+	mutex       sync.Mutex
+	subscribers []Subscriber[T]
+	modTimes    []time.Time
+}
+
+// NewReloader creates a reloader of cfg, load returns the configuration loaded again and validated
+func NewReloader[T any](load func() (*T, error), cfg *T, logger slog.Logger) *Reloader[T] {
+	r := &Reloader[T]{load: load, logger: logger}
+	r.current.Store(cfg)
+
+	return r
+}
+
+// WatchFiles allows you to specify the configuration files to reload when one is created, modified or removed,
+// checked every interval
+func (r *Reloader[T]) WatchFiles(interval time.Duration, files ...string) *Reloader[T] {
+	r.files = files
+	r.interval = interval
+	r.modTimes = modTimes(files)
+
+	return r
+}
+
+// Current returns the configuration of the last successful load
+func (r *Reloader[T]) Current() *T {
+	return r.current.Load()
+}
+
+// Subscribe registers fn to be notified of the configuration changes
+func (r *Reloader[T]) Subscribe(fn Subscriber[T]) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.subscribers = append(r.subscribers, fn)
+}
+
+// Reload loads the configuration and notifies the subscribers when it changed
+// The current configuration is kept when the new one can't be loaded or is invalid
+func (r *Reloader[T]) Reload() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cfg, err := r.load()
+	if err != nil {
+		return err
+	}
+
+	old := r.current.Load()
+	if reflect.DeepEqual(old, cfg) {
+		return nil
+	}
+	r.current.Store(cfg)
+
+	for _, subscriber := range r.subscribers {
+		subscriber(old, cfg)
+	}
+
+	return nil
+}
+
+// Run reloads the configuration on SIGHUP and when the watched file changes until the context is done
+func (r *Reloader[T]) Run(ctx context.Context) error {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var tick <-chan time.Time
+	if len(r.files) > 0 && r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hangup:
+			r.reload("signal")
+		case <-tick:
+			if changed := modTimes(r.files); !slices.EqualFunc(changed, r.modTimes, time.Time.Equal) {
+				r.modTimes = changed
+				r.reload("file changed")
+			}
+		}
+	}
+}
+
+func (r *Reloader[T]) reload(reason string) {
+	if err := r.Reload(); err != nil {
+		r.logger.Error("configuration reload rejected", "reason", reason, "error", err.Error())
+		return
+	}
+	r.logger.Info("configuration reloaded", "reason", reason)
+}
+
+// modTimes returns when the files were last modified, the zero time for the ones that can't be read
+func modTimes(files []string) []time.Time {
+	times := make([]time.Time, len(files))
+	for i, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			times[i] = info.ModTime()
+		}
+	}
+	return times
+}
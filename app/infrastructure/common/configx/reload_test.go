@@ -0,0 +1,76 @@
+package configx_test
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/configx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloader(t *testing.T) {
+	os.Clearenv()
+
+	file, err := fileWithConfig("SERVICE_K1=first\n")
+	require.NoError(t, err)
+	defer os.Remove(file)
+
+	loader := configx.NewLoader().WithFileName(file)
+	load := func() (*testConfig, error) {
+		cfg := &testConfig{}
+		return cfg, loader.Load(cfg)
+	}
+
+	cfg, err := load()
+	require.NoError(t, err)
+
+	changes := make(chan [2]string, 1)
+	reloader := configx.NewReloader(load, cfg, *slog.New(slog.NewTextHandler(os.Stderr, nil))).
+		WatchFiles(10*time.Millisecond, loader.Files()...)
+	reloader.Subscribe(func(old, new *testConfig) {
+		changes <- [2]string{old.Key1, new.Key1}
+	})
+
+	// nothing changed, nobody is notified
+	require.NoError(t, reloader.Reload())
+	assert.Empty(t, changes)
+
+	// invalid configurations are rejected
+	require.NoError(t, os.WriteFile(file, []byte("SERVICE_K2=without-k1\n"), 0o644))
+	assert.Error(t, reloader.Reload())
+	assert.Equal(t, "first", reloader.Current().Key1)
+	assert.Empty(t, changes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- reloader.Run(ctx) }()
+
+	// the file is watched
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, os.WriteFile(file, []byte("SERVICE_K1=second\n"), 0o644))
+	select {
+	case change := <-changes:
+		assert.Equal(t, [2]string{"first", "second"}, change)
+	case <-time.After(time.Second):
+		t.Fatal("file change not reloaded")
+	}
+
+	// and reloaded on SIGHUP
+	require.NoError(t, os.WriteFile(file, []byte("SERVICE_K1=third\n"), 0o644))
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	select {
+	case change := <-changes:
+		assert.Equal(t, [2]string{"second", "third"}, change)
+	case <-time.After(time.Second):
+		t.Fatal("SIGHUP not reloaded")
+	}
+	assert.Equal(t, "third", reloader.Current().Key1)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
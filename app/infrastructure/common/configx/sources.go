@@ -0,0 +1,332 @@
+package configx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+	"olympos.io/encoding/edn"
+)
+
+const (
+	defaultBaseFileName = "config.yaml"
+
+	// fileSuffix marks the environment variables holding the path of the file the value is read from
+	fileSuffix = "_FILE"
+)
+
+// Origin is the effective value of an environment variable and the source it was taken from
+type Origin struct {
+	Name  string
+	Value string
+	// Source is default, file <path>, env, env <NAME>_FILE or flag --<name>, empty when no source sets it
+	Source string
+}
+
+// String formats the origin as NAME=value (source)
+func (o Origin) String() string {
+	source := o.Source
+	if source == "" {
+		source = "unset"
+	}
+	return fmt.Sprintf("%s=%s (%s)", o.Name, o.Value, source)
+}
+
+// layer is the values of a source by environment variable
+type layer struct {
+	name   string
+	values map[string]string
+	// sources are the sources of the values not taken from the layer itself
+	sources map[string]string
+}
+
+func (l layer) source(name string) string {
+	if source, ok := l.sources[name]; ok {
+		return source
+	}
+	return l.name
+}
+
+// layers returns the sources of the configuration, from the lowest priority to the highest
+func (l *Loader) layers(fields []field, flags map[string]string) ([]layer, error) {
+	known := map[string]bool{}
+	paths := map[string]field{}
+	defaults := layer{name: "default", values: map[string]string{}}
+	for _, field := range fields {
+		known[field.name] = true
+		paths[field.path] = field
+		if field.hasDefault {
+			defaults.values[field.name] = field.def
+		}
+	}
+
+	layers := []layer{defaults}
+
+	if !l.onlyEnvironment {
+		files, err := l.fileLayers(known, paths)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, files...)
+	}
+
+	env, err := envLayer(fields, known)
+	if err != nil {
+		return nil, err
+	}
+	layers = append(layers, env)
+
+	if !l.onlyEnvironment {
+		flagLayer := layer{name: "flag", values: flags, sources: map[string]string{}}
+		for name := range flags {
+			flagLayer.sources[name] = "flag --" + flagName(name)
+		}
+		layers = append(layers, flagLayer)
+	}
+
+	return layers, nil
+}
+
+// fileLayers reads the base, profile and .env files. The files given explicitly have to exist
+func (l *Loader) fileLayers(known map[string]bool, paths map[string]field) ([]layer, error) {
+	base := l.baseFileName()
+	explicit := base != defaultBaseFileName
+
+	type source struct {
+		path     string
+		required bool
+	}
+	sources := []source{{path: base, required: explicit}}
+	if profile := l.profile(); profile != "" {
+		sources = append(sources, source{path: profileFileName(base, profile), required: true})
+	}
+	if l.defaultFileName != "" && l.defaultFileName != base {
+		sources = append(sources, source{path: l.defaultFileName})
+	}
+
+	layers := make([]layer, 0, len(sources))
+	for _, s := range sources {
+		if _, err := os.Stat(s.path); errors.Is(err, os.ErrNotExist) && !s.required {
+			continue
+		}
+
+		values, err := readFile(s.path, known, paths)
+		if err != nil {
+			return nil, fmt.Errorf("error reading configuration from file: %v", err)
+		}
+		layers = append(layers, layer{name: "file " + s.path, values: filter(values, known)})
+	}
+
+	return layers, nil
+}
+
+// envLayer reads the environment variables, the value of NAME is read from the file at NAME_FILE when given,
+// so secrets can be mounted as files instead of being exposed in the environment
+func envLayer(fields []field, known map[string]bool) (layer, error) {
+	env := layer{name: "env", values: map[string]string{}, sources: map[string]string{}}
+
+	for _, field := range fields {
+		value, set := os.LookupEnv(field.name)
+		if set {
+			env.values[field.name] = value
+		}
+
+		fileVariable := field.name + fileSuffix
+		if known[fileVariable] {
+			continue
+		}
+		path, ok := os.LookupEnv(fileVariable)
+		if !ok {
+			continue
+		}
+		if set {
+			return layer{}, fmt.Errorf("error reading environment variables: both %s and %s are set", field.name, fileVariable)
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return layer{}, fmt.Errorf("error reading environment variables: %s: %v", fileVariable, err)
+		}
+		env.values[field.name] = strings.TrimRight(string(content), "\r\n")
+		env.sources[field.name] = "env " + fileVariable
+	}
+
+	return env, nil
+}
+
+// envName matches the names of environment variables, the keys of the configuration files
+var envName = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+// readFile reads the values of a YAML, JSON, TOML or EDN file, other files are read as .env files
+// Keys are environment variables, e.g. LOG_LEVEL: debug, or the sections and fields of the configuration
+// struct read before, e.g. log: {level: debug}, the fields of the struct layout are mapped to their variables
+func readFile(path string, known map[string]bool, paths map[string]field) (map[string]string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	decode, ok := decoders[ext]
+	if !ok {
+		return godotenv.Read(path)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	document, err := decode(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	values := map[string]string{}
+	if err := flatten(document, "", known, paths, values); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return values, nil
+}
+
+// decoders decode the configuration files by extension into maps keyed by string
+var decoders = map[string]func(content []byte) (map[string]any, error){
+	// JSON is valid YAML
+	".yaml": decodeYAML,
+	".yml":  decodeYAML,
+	".json": decodeYAML,
+	".toml": func(content []byte) (map[string]any, error) {
+		document := map[string]any{}
+		_, err := toml.Decode(string(content), &document)
+		return document, err
+	},
+	".edn": func(content []byte) (map[string]any, error) {
+		if len(bytes.TrimSpace(content)) == 0 {
+			return map[string]any{}, nil
+		}
+		var document any
+		if err := edn.Unmarshal(content, &document); err != nil {
+			return nil, err
+		}
+		section, ok := stringKeys(document)
+		if !ok {
+			return nil, errors.New("the document has to be a map")
+		}
+		return section, nil
+	},
+}
+
+func decodeYAML(content []byte) (map[string]any, error) {
+	document := map[string]any{}
+	err := yaml.Unmarshal(content, &document)
+	return document, err
+}
+
+// stringKeys returns the map with its keys as strings, EDN keywords without the colon
+func stringKeys(value any) (map[string]any, bool) {
+	switch v := value.(type) {
+	case map[string]any:
+		return v, true
+	case map[any]any:
+		section := make(map[string]any, len(v))
+		for key, value := range v {
+			if keyword, ok := key.(edn.Keyword); ok {
+				section[string(keyword)] = value
+				continue
+			}
+			section[fmt.Sprint(key)] = value
+		}
+		return section, true
+	default:
+		return nil, false
+	}
+}
+
+// flatten sets the values of the variables in the section, whose fields are at prefix in the struct layout
+// Fields of the struct layout missing from the configuration are ignored, like the variables of other tools
+func flatten(section map[string]any, prefix string, known map[string]bool, paths map[string]field, values map[string]string) error {
+	for key, value := range section {
+		name, isVariable := "", false
+		if prefix == "" && envName.MatchString(key) {
+			name, isVariable = key, true
+		} else if field, ok := paths[joinPath(prefix, key)]; ok {
+			name = field.name
+		}
+
+		if nested, ok := stringKeys(value); ok {
+			if name != "" && known[name] {
+				return fmt.Errorf("%s has to be a scalar value", key)
+			}
+			if err := flatten(nested, joinPath(prefix, key), known, paths, values); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if name == "" {
+			if prefix == "" {
+				return fmt.Errorf("%s is neither an environment variable nor a section of the configuration, e.g. LOG_LEVEL: debug", key)
+			}
+			continue
+		}
+		if _, set := values[name]; set {
+			return fmt.Errorf("%s is set twice", name)
+		}
+
+		text, err := scalar(value, !isVariable && paths[joinPath(prefix, key)].value.Type() == durationType)
+		if err != nil {
+			return fmt.Errorf("%s %v", key, err)
+		}
+		values[name] = text
+	}
+
+	return nil
+}
+
+// scalar formats the value as the text of an environment variable, numbers set to durations are nanoseconds
+// as the struct layout read them
+func scalar(value any, duration bool) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case int:
+		return formatInt(int64(v), duration), nil
+	case int64:
+		return formatInt(v, duration), nil
+	case float64:
+		if duration {
+			return time.Duration(v).String(), nil
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	default:
+		return "", fmt.Errorf("has to be a scalar value but is %T", value)
+	}
+}
+
+func formatInt(n int64, duration bool) string {
+	if duration {
+		return time.Duration(n).String()
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// filter drops the values of variables not in the configuration, files can be shared with other tools
+func filter(values map[string]string, known map[string]bool) map[string]string {
+	for name := range values {
+		if !known[name] {
+			delete(values, name)
+		}
+	}
+	return values
+}
@@ -0,0 +1,164 @@
+package configx_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/configx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type layeredConfig struct {
+	Server struct {
+		Port    string        `env:"LAYER_PORT" env-default:":8080"`
+		Timeout time.Duration `env:"LAYER_TIMEOUT" env-default:"5s"`
+		Workers int           `env:"LAYER_WORKERS" env-default:"1"`
+		Debug   bool          `env:"LAYER_DEBUG"`
+	}
+	Ratio  float64 `env:"LAYER_RATIO" env-default:"1"`
+	Region string  `env:"LAYER_REGION"`
+	Secret string  `env:"LAYER_SECRET" secret:"true"`
+	Unset  string  `env:"LAYER_UNSET"`
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoaderLayers(t *testing.T) {
+	os.Clearenv()
+	dir := t.TempDir()
+
+	base := writeFile(t, dir, "config.yaml", "LAYER_TIMEOUT: 10s\nLAYER_WORKERS: 2\nLAYER_RATIO: 0.5\nLAYER_REGION: eu\nOTHER_TOOL: ignored\n")
+	writeFile(t, dir, "config.production.yaml", "LAYER_WORKERS: 4\nLAYER_DEBUG: true\n")
+	dotenv := writeFile(t, dir, ".env", "LAYER_REGION=us\n")
+	secret := writeFile(t, dir, "secret", "s3cr3t\n")
+
+	os.Setenv("APP_PROFILE", "production")
+	os.Setenv("LAYER_PORT", ":9090")
+	os.Setenv("LAYER_SECRET_FILE", secret)
+
+	loader := configx.NewLoader().
+		WithDefaultFileName(dotenv).
+		WithFlags(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--config", base, "--layer-ratio", "0.25"})
+
+	config := &layeredConfig{}
+	require.NoError(t, loader.Load(config))
+
+	assert.Equal(t, ":9090", config.Server.Port)
+	assert.Equal(t, 10*time.Second, config.Server.Timeout)
+	assert.Equal(t, 4, config.Server.Workers)
+	assert.True(t, config.Server.Debug)
+	assert.Equal(t, 0.25, config.Ratio)
+	assert.Equal(t, "us", config.Region)
+	assert.Equal(t, "s3cr3t", config.Secret)
+
+	assert.Equal(t, []configx.Origin{
+		{Name: "LAYER_PORT", Value: ":9090", Source: "env"},
+		{Name: "LAYER_TIMEOUT", Value: "10s", Source: "file " + base},
+		{Name: "LAYER_WORKERS", Value: "4", Source: "file " + filepath.Join(dir, "config.production.yaml")},
+		{Name: "LAYER_DEBUG", Value: "true", Source: "file " + filepath.Join(dir, "config.production.yaml")},
+		{Name: "LAYER_RATIO", Value: "0.25", Source: "flag --layer-ratio"},
+		{Name: "LAYER_REGION", Value: "us", Source: "file " + dotenv},
+		{Name: "LAYER_SECRET", Value: configx.Redacted, Source: "env LAYER_SECRET_FILE"},
+		{Name: "LAYER_UNSET", Value: "", Source: ""},
+	}, loader.Explain())
+	assert.Equal(t, "LAYER_UNSET= (unset)", loader.Explain()[7].String())
+
+	assert.Equal(t, []string{base, filepath.Join(dir, "config.production.yaml"), dotenv}, loader.Files())
+}
+
+func TestLoaderStructLayout(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": "server:\n  port: \":9090\"\n  timeout: 10s\n  workers: 2\n  debug: true\n  other: ignored\nratio: 0.5\nregion: eu\n",
+		"config.json": `{"Server": {"Port": ":9090", "Timeout": 10000000000, "Workers": 2, "Debug": true}, "Ratio": 0.5, "Region": "eu"}`,
+		"config.toml": "ratio = 0.5\nregion = \"eu\"\n\n[server]\nport = \":9090\"\ntimeout = \"10s\"\nworkers = 2\ndebug = true\n",
+		"config.edn":  `{:server {:port ":9090" :timeout "10s" :workers 2 :debug true} :ratio 0.5 :region "eu"}`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			os.Clearenv()
+			base := writeFile(t, t.TempDir(), name, content)
+
+			config := &layeredConfig{}
+			require.NoError(t, configx.NewLoader().
+				WithFlags(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--config", base}).
+				Load(config))
+
+			assert.Equal(t, ":9090", config.Server.Port)
+			assert.Equal(t, 10*time.Second, config.Server.Timeout)
+			assert.Equal(t, 2, config.Server.Workers)
+			assert.True(t, config.Server.Debug)
+			assert.Equal(t, 0.5, config.Ratio)
+			assert.Equal(t, "eu", config.Region)
+		})
+	}
+}
+
+func TestLoaderSourceErrors(t *testing.T) {
+	dir := t.TempDir()
+	load := func(args ...string) error {
+		return configx.NewLoader().
+			WithDefaultFileName(filepath.Join(dir, ".env")).
+			WithFlags(flag.NewFlagSet("test", flag.ContinueOnError), args).
+			Load(&layeredConfig{})
+	}
+
+	t.Run("missing profile file", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("APP_PROFILE", "staging")
+		assert.Error(t, load())
+	})
+
+	t.Run("value and file given", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("LAYER_SECRET", "s3cr3t")
+		os.Setenv("LAYER_SECRET_FILE", writeFile(t, dir, "secret", "s3cr3t"))
+		assert.ErrorContains(t, load(), "both LAYER_SECRET and LAYER_SECRET_FILE are set")
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		os.Clearenv()
+		assert.ErrorContains(t, load("--layer-workers", "many"), "LAYER_WORKERS from flag --layer-workers")
+	})
+
+	t.Run("nested file value", func(t *testing.T) {
+		os.Clearenv()
+		base := writeFile(t, dir, "nested.yaml", "LAYER_REGION:\n  name: eu\n")
+		assert.ErrorContains(t, load("--config", base), "LAYER_REGION has to be a scalar value")
+	})
+
+	t.Run("file keyed by unknown field", func(t *testing.T) {
+		os.Clearenv()
+		base := writeFile(t, dir, "fields.yaml", "port: \":9090\"\n")
+		assert.ErrorContains(t, load("--config", base), "port is neither an environment variable nor a section of the configuration")
+	})
+
+	t.Run("variable set in both layouts", func(t *testing.T) {
+		os.Clearenv()
+		base := writeFile(t, dir, "both.yaml", "LAYER_PORT: \":9090\"\nserver:\n  port: \":9091\"\n")
+		assert.ErrorContains(t, load("--config", base), "LAYER_PORT is set twice")
+	})
+
+	t.Run("secret flag", func(t *testing.T) {
+		os.Clearenv()
+		assert.ErrorContains(t, load("--layer-secret", "s3cr3t"), "flag provided but not defined")
+	})
+
+	t.Run("unsupported field type", func(t *testing.T) {
+		os.Clearenv()
+		err := configx.NewLoader().
+			WithFlags(flag.NewFlagSet("test", flag.ContinueOnError), nil).
+			Load(&struct {
+				Hosts []string `env:"LAYER_HOSTS"`
+			}{})
+		assert.ErrorContains(t, err, "LAYER_HOSTS: unsupported type []string")
+	})
+}
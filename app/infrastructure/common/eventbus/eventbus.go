@@ -0,0 +1,32 @@
+package eventbus
+
+import (
+	"fmt"
+
+	event "github.com/AlexanderGrom/go-event"
+)
+
+// Bus is an in-process synchronous event bus
+type Bus struct {
+	dispatcher event.Dispatcher
+}
+
+// New creates an event bus without subscribers
+func New() *Bus {
+	return &Bus{dispatcher: event.New()}
+}
+
+// Publish calls every subscriber of name with the payload, stopping at the first error
+func (b *Bus) Publish(name string, payload any) error {
+	if err := b.dispatcher.Go(name, payload); err != nil {
+		return fmt.Errorf("publishing %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// Subscribe registers fn to be called with the payload of every name event
+// fn must be a function receiving the payload type and returning an error
+func (b *Bus) Subscribe(name string, fn any) error {
+	return b.dispatcher.On(name, fn)
+}
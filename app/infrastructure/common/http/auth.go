@@ -0,0 +1,283 @@
+package http
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// SubjectKey is the gin context key holding the subject of the authenticated token
+	SubjectKey = "auth.subject"
+	// ScopesKey is the gin context key holding the scopes of the authenticated token
+	ScopesKey = "auth.scopes"
+	// APIKeyHeader is the header service callers send their API key in
+	APIKeyHeader = "X-API-Key"
+
+	bearerPrefix = "Bearer "
+)
+
+// AuthConfig are the configurations related to authenticating requests with JWT bearer tokens
+// Tokens are verified with the HS256 secret, the RS256 public key or the keys of the JWKS file configured
+type AuthConfig struct {
+	Enabled            bool          `env:"AUTH_ENABLED" env-default:"false"`
+	HS256Secret        string        `env:"AUTH_HS256_SECRET" secret:"true"`
+	RS256PublicKeyFile string        `env:"AUTH_RS256_PUBLIC_KEY_FILE"`
+	JWKSFile           string        `env:"AUTH_JWKS_FILE"`
+	Issuer             string        `env:"AUTH_ISSUER"`
+	Audience           string        `env:"AUTH_AUDIENCE"`
+	Leeway             time.Duration `env:"AUTH_LEEWAY" env-default:"30s"`
+	APIKeysFile        string        `env:"API_KEYS_FILE" env-default:"data/api_keys.json"`
+}
+
+// ErrNoVerificationKeys error when authentication is enabled without keys to verify tokens
+var ErrNoVerificationKeys = errors.New("authentication enabled without HS256 secret, RS256 public key or JWKS file")
+
+// Authenticator verifies JWT bearer tokens
+type Authenticator struct {
+	secret    []byte
+	publicKey *rsa.PublicKey
+	jwks      map[string]*rsa.PublicKey
+	parser    *jwt.Parser
+}
+
+// NewAuthenticator creates an authenticator loading the verification keys configured
+func NewAuthenticator(cfg AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{jwks: make(map[string]*rsa.PublicKey)}
+
+	if cfg.HS256Secret != "" {
+		a.secret = []byte(cfg.HS256Secret)
+	}
+
+	if cfg.RS256PublicKeyFile != "" {
+		data, err := os.ReadFile(cfg.RS256PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading RS256 public key: %w", err)
+		}
+		if a.publicKey, err = jwt.ParseRSAPublicKeyFromPEM(data); err != nil {
+			return nil, fmt.Errorf("parsing RS256 public key: %w", err)
+		}
+	}
+
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.jwks = keys
+	}
+
+	if a.secret == nil && a.publicKey == nil && len(a.jwks) == 0 {
+		return nil, ErrNoVerificationKeys
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(cfg.Leeway),
+	}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+	a.parser = jwt.NewParser(options...)
+
+	return a, nil
+}
+
+// Authenticate returns the subject and scopes of a valid token
+func (a *Authenticator) Authenticate(token string) (string, []string, error) {
+	claims := &tokenClaims{}
+	if _, err := a.parser.ParseWithClaims(token, claims, a.key); err != nil {
+		return "", nil, err
+	}
+
+	if claims.Subject == "" {
+		return "", nil, errors.New("token has no subject")
+	}
+
+	return claims.Subject, claims.scopes(), nil
+}
+
+// key selects the key verifying the token by its algorithm and key ID
+func (a *Authenticator) key(token *jwt.Token) (any, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		if a.secret == nil {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		return a.secret, nil
+	default:
+		if kid, ok := token.Header["kid"].(string); ok {
+			if key, found := a.jwks[kid]; found {
+				return key, nil
+			}
+		}
+		if a.publicKey != nil {
+			return a.publicKey, nil
+		}
+		// tokens without key ID are accepted when the JWKS file holds a single key
+		if _, hasKid := token.Header["kid"]; !hasKid && len(a.jwks) == 1 {
+			for _, key := range a.jwks {
+				return key, nil
+			}
+		}
+		return nil, errors.New("no key found to verify the token")
+	}
+}
+
+// APIKeyVerifier verifies the API keys of service callers
+type APIKeyVerifier interface {
+	Verify(key string) (subject string, scopes []string, err error)
+}
+
+// AuthenticateAPIKey authenticates the requests with an X-API-Key header, rejecting invalid keys
+// Requests without the header are left to Authenticate
+func AuthenticateAPIKey(verifier APIKeyVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(APIKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		subject, scopes, err := verifier.Verify(key)
+		if err != nil {
+			errorResponseJson(c, http.StatusUnauthorized, "invalid api key")
+			return
+		}
+
+		c.Set(SubjectKey, subject)
+		c.Set(ScopesKey, scopes)
+		c.Next()
+	}
+}
+
+// Authenticate rejects requests without a valid bearer token, storing the subject
+// and scopes of the token in the context for the handlers
+// Requests already authenticated by an API key are let through, and without an
+// authenticator, when only API keys are accepted, every bearer token is rejected
+func Authenticate(authenticator *Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if Subject(c) != "" {
+			c.Next()
+			return
+		}
+
+		header := c.GetHeader("Authorization")
+		if !strings.HasPrefix(header, bearerPrefix) {
+			c.Header("WWW-Authenticate", `Bearer`)
+			errorResponseJson(c, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+
+		if authenticator == nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			errorResponseJson(c, http.StatusUnauthorized, "bearer tokens are not accepted")
+			return
+		}
+
+		subject, scopes, err := authenticator.Authenticate(strings.TrimPrefix(header, bearerPrefix))
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			errorResponseJson(c, http.StatusUnauthorized, "invalid bearer token")
+			return
+		}
+
+		c.Set(SubjectKey, subject)
+		c.Set(ScopesKey, scopes)
+		c.Next()
+	}
+}
+
+// Subject returns the subject of the authenticated token, empty when the request is not authenticated
+func Subject(c *gin.Context) string {
+	return c.GetString(SubjectKey)
+}
+
+// Scopes returns the scopes of the authenticated token
+func Scopes(c *gin.Context) []string {
+	return c.GetStringSlice(ScopesKey)
+}
+
+// tokenClaims are the registered claims plus the scopes, either as the space separated
+// "scope" claim or the "scp" list
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Scope string          `json:"scope"`
+	Scp   json.RawMessage `json:"scp"`
+}
+
+func (c *tokenClaims) scopes() []string {
+	scopes := strings.Fields(c.Scope)
+
+	var list []string
+	if err := json.Unmarshal(c.Scp, &list); err == nil {
+		return append(scopes, list...)
+	}
+
+	var value string
+	if err := json.Unmarshal(c.Scp, &value); err == nil {
+		return append(scopes, strings.Fields(value)...)
+	}
+
+	return scopes
+}
+
+// jwk is an RSA key of a JWKS file, other key types are ignored
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWKS file: %w", err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing JWKS file: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("decoding modulus of JWKS key %q: %w", key.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("decoding exponent of JWKS key %q: %w", key.Kid, err)
+		}
+
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
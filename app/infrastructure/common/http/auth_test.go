@@ -0,0 +1,182 @@
+package http_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	appHTTP "github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const hs256Secret = "a-secret-long-enough-for-hs256!!"
+
+func newAuthRouter(t *testing.T, cfg appHTTP.AuthConfig) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	authenticator, err := appHTTP.NewAuthenticator(cfg)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(appHTTP.Authenticate(authenticator))
+	router.GET("/me", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"subject": appHTTP.Subject(c), "scopes": appHTTP.Scopes(c)})
+	})
+
+	return router
+}
+
+func get(router *gin.Engine, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key any, claims jwt.MapClaims, kid string) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "user-1",
+		"scope": "actions:read actions:write",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestAuthenticateHS256(t *testing.T) {
+	router := newAuthRouter(t, appHTTP.AuthConfig{HS256Secret: hs256Secret, Issuer: "surfe"})
+
+	claims := validClaims()
+	claims["iss"] = "surfe"
+	w := get(router, sign(t, jwt.SigningMethodHS256, []byte(hs256Secret), claims, ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"subject":"user-1","scopes":["actions:read","actions:write"]}`, w.Body.String())
+
+	missing := get(router, "")
+	assert.Equal(t, http.StatusUnauthorized, missing.Code)
+	assert.Equal(t, "Bearer", missing.Header().Get("WWW-Authenticate"))
+
+	for name, token := range map[string]string{
+		"wrong issuer": sign(t, jwt.SigningMethodHS256, []byte(hs256Secret), validClaims(), ""),
+		"wrong secret": sign(t, jwt.SigningMethodHS256, []byte("another-secret-long-enough!!!!!!"), claims, ""),
+		"expired": sign(t, jwt.SigningMethodHS256, []byte(hs256Secret), jwt.MapClaims{
+			"sub": "user-1", "iss": "surfe", "exp": time.Now().Add(-time.Hour).Unix(),
+		}, ""),
+		"no expiration":  sign(t, jwt.SigningMethodHS256, []byte(hs256Secret), jwt.MapClaims{"sub": "user-1", "iss": "surfe"}, ""),
+		"none algorithm": sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims, ""),
+		"malformed":      "not-a-token",
+	} {
+		assert.Equal(t, http.StatusUnauthorized, get(router, token).Code, name)
+	}
+}
+
+func TestAuthenticateRS256(t *testing.T) {
+	dir := t.TempDir()
+
+	pemKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&pemKey.PublicKey)
+	require.NoError(t, err)
+	pemFile := filepath.Join(dir, "public.pem")
+	require.NoError(t, os.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	jwksKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "key-1",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(jwksKey.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(jwksKey.E)).Bytes()),
+	}}})
+	require.NoError(t, err)
+	jwksFile := filepath.Join(dir, "jwks.json")
+	require.NoError(t, os.WriteFile(jwksFile, jwks, 0o600))
+
+	router := newAuthRouter(t, appHTTP.AuthConfig{RS256PublicKeyFile: pemFile, JWKSFile: jwksFile})
+
+	claims := validClaims()
+	delete(claims, "scope")
+	claims["scp"] = []string{"referrals:read"}
+
+	w := get(router, sign(t, jwt.SigningMethodRS256, pemKey, claims, ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"subject":"user-1","scopes":["referrals:read"]}`, w.Body.String())
+
+	assert.Equal(t, http.StatusOK, get(router, sign(t, jwt.SigningMethodRS256, jwksKey, claims, "key-1")).Code)
+	// HS256 tokens are not accepted without a secret configured
+	assert.Equal(t, http.StatusUnauthorized, get(router, sign(t, jwt.SigningMethodHS256, []byte(hs256Secret), claims, "")).Code)
+}
+
+func TestNewAuthenticatorRequiresKeys(t *testing.T) {
+	_, err := appHTTP.NewAuthenticator(appHTTP.AuthConfig{Enabled: true})
+	assert.ErrorIs(t, err, appHTTP.ErrNoVerificationKeys)
+}
+
+// apiKeyVerifier accepts a single key
+type apiKeyVerifier struct{}
+
+func (apiKeyVerifier) Verify(key string) (string, []string, error) {
+	if key != "sk_valid" {
+		return "", nil, errors.New("invalid api key")
+	}
+	return "apikey:1", []string{"actions:write"}, nil
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	// only API keys are accepted
+	router.Use(appHTTP.AuthenticateAPIKey(apiKeyVerifier{}), appHTTP.Authenticate(nil))
+	router.GET("/me", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"subject": appHTTP.Subject(c), "scopes": appHTTP.Scopes(c)})
+	})
+
+	request := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set(header, value)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(appHTTP.APIKeyHeader, "sk_valid")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"subject":"apikey:1","scopes":["actions:write"]}`, w.Body.String())
+
+	assert.Equal(t, http.StatusUnauthorized, request(appHTTP.APIKeyHeader, "sk_invalid").Code)
+	assert.Equal(t, http.StatusUnauthorized, request("Authorization", "Bearer token").Code)
+	assert.Equal(t, http.StatusUnauthorized, request("Accept", "*/*").Code)
+}
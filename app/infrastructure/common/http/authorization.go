@@ -0,0 +1,63 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ScopeUsersRead     = "users:read"
+	ScopeActionsRead   = "actions:read"
+	ScopeActionsWrite  = "actions:write"
+	ScopeAnalyticsRead = "analytics:read"
+	// ScopeAdmin grants every scope
+	ScopeAdmin = "admin"
+)
+
+// AllScopes are the scopes tokens and API keys may be granted
+var AllScopes = []string{ScopeUsersRead, ScopeActionsRead, ScopeActionsWrite, ScopeAnalyticsRead, ScopeAdmin}
+
+// ScopePolicy maps routes, as returned by Route, to the scope required to call them
+type ScopePolicy map[string]string
+
+// Route identifies a route by method and full path, e.g. "GET /api/users/:id"
+func Route(method, fullPath string) string {
+	return method + " " + fullPath
+}
+
+// Authorize rejects authenticated requests without the scope the policy requires for the route
+// Routes missing from the policy are denied, so new routes are private until they are declared
+// Every denial is written to the audit log
+func Authorize(policy ScopePolicy, logger slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := Route(c.Request.Method, c.FullPath())
+		scope, declared := policy[route]
+		scopes := Scopes(c)
+
+		if declared && (slices.Contains(scopes, scope) || slices.Contains(scopes, ScopeAdmin)) {
+			c.Next()
+			return
+		}
+
+		detail := fmt.Sprintf("the %q scope is required", scope)
+		if !declared {
+			detail = "the route has no scope policy"
+		}
+
+		logger.Warn("authorization denied",
+			slog.Bool("audit", true),
+			slog.String("subject", Subject(c)),
+			slog.String("route", route),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("required_scope", scope),
+			slog.Any("granted_scopes", scopes),
+			slog.String("client_ip", c.ClientIP()),
+		)
+
+		problemResponseJson(c, http.StatusForbidden, detail)
+	}
+}
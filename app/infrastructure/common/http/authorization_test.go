@@ -0,0 +1,81 @@
+package http_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	appHTTP "github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAuthorizedRouter(scopes []string, logs *bytes.Buffer) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	policy := appHTTP.ScopePolicy{
+		appHTTP.Route(http.MethodGet, "/api/actions/referral"): appHTTP.ScopeAnalyticsRead,
+		appHTTP.Route(http.MethodPost, "/api/actions"):         appHTTP.ScopeActionsWrite,
+	}
+	logger := slog.New(slog.NewJSONHandler(logs, nil))
+
+	router := gin.New()
+	group := router.Group("api/actions")
+	group.Use(func(c *gin.Context) {
+		c.Set(appHTTP.SubjectKey, "analyst")
+		c.Set(appHTTP.ScopesKey, scopes)
+	}, appHTTP.Authorize(policy, *logger))
+
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	group.GET("referral", ok)
+	group.POST("", ok)
+	group.GET("users/:id", ok)
+
+	return router
+}
+
+func serve(router *gin.Engine, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestAuthorize(t *testing.T) {
+	logs := &bytes.Buffer{}
+	router := newAuthorizedRouter([]string{appHTTP.ScopeAnalyticsRead}, logs)
+
+	assert.Equal(t, http.StatusOK, serve(router, http.MethodGet, "/api/actions/referral").Code)
+	assert.Empty(t, logs.String())
+
+	w := serve(router, http.MethodPost, "/api/actions")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "about:blank",
+		"title": "Forbidden",
+		"status": 403,
+		"detail": "the \"actions:write\" scope is required",
+		"instance": "/api/actions"
+	}`, w.Body.String())
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, true, entry["audit"])
+	assert.Equal(t, "analyst", entry["subject"])
+	assert.Equal(t, "POST /api/actions", entry["route"])
+	assert.Equal(t, "actions:write", entry["required_scope"])
+
+	// routes without policy are denied
+	assert.Equal(t, http.StatusForbidden, serve(router, http.MethodGet, "/api/actions/users/1").Code)
+}
+
+func TestAuthorizeAdmin(t *testing.T) {
+	router := newAuthorizedRouter([]string{appHTTP.ScopeAdmin}, &bytes.Buffer{})
+
+	assert.Equal(t, http.StatusOK, serve(router, http.MethodGet, "/api/actions/referral").Code)
+	assert.Equal(t, http.StatusOK, serve(router, http.MethodPost, "/api/actions").Code)
+}
@@ -0,0 +1,38 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LimitBody rejects with 413 the requests whose body is larger than limit bytes, up front when the
+// Content-Length says so and otherwise once reading it goes past the limit
+func LimitBody(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			problemResponseJson(c, http.StatusRequestEntityTooLarge, bodyTooLarge(limit))
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// bodyReadError rejects the request whose body could not be read, with 413 when it is larger than the limit
+func bodyReadError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		problemResponseJson(c, http.StatusRequestEntityTooLarge, bodyTooLarge(tooLarge.Limit))
+		return
+	}
+
+	problemResponseJson(c, http.StatusBadRequest, "request body could not be read")
+}
+
+func bodyTooLarge(limit int64) string {
+	return fmt.Sprintf("request body must be at most %d bytes", limit)
+}
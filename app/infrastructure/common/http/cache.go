@@ -0,0 +1,146 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CacheConfig are the configurations related to conditional requests
+// Routes are a semicolon separated list of route=Cache-Control, routes are the templates of GET routes,
+// e.g. "/api/users/:id=private, max-age=60;/api/actions/referral=private, max-age=10"
+type CacheConfig struct {
+	Enabled bool   `env:"HTTP_CACHE_ENABLED" env-default:"true"`
+	Routes  string `env:"HTTP_CACHE_ROUTES" env-default:"/api/users/:id=private, no-cache;/api/actions/users/:id=private, no-cache;/api/actions/probability/users/:action=private, no-cache;/api/actions/referral=private, max-age=10"`
+}
+
+// CachePolicy maps routes, as returned by Route, to the Cache-Control of their responses
+type CachePolicy map[string]string
+
+// ParseCachePolicy parses a semicolon separated list of route=Cache-Control of GET routes
+func ParseCachePolicy(s string) (CachePolicy, error) {
+	policy := CachePolicy{}
+	if strings.TrimSpace(s) == "" {
+		return policy, nil
+	}
+
+	for _, pair := range strings.Split(s, ";") {
+		route, cacheControl, found := strings.Cut(strings.TrimSpace(pair), "=")
+		route, cacheControl = strings.TrimSpace(route), strings.TrimSpace(cacheControl)
+		if !found || !strings.HasPrefix(route, "/") || cacheControl == "" {
+			return nil, fmt.Errorf("invalid cache policy %q, expected route=Cache-Control", pair)
+		}
+
+		policy[Route(http.MethodGet, route)] = cacheControl
+	}
+
+	return policy, nil
+}
+
+// DataVersion returns the version of the data the cached responses are computed from, it changes with the data
+type DataVersion func(ctx context.Context) (string, error)
+
+// Conditional tags the responses of the read routes in the policy with a strong ETag and their Cache-Control,
+// answering with 304 the requests whose If-None-Match matches it instead of running the handler
+// The ETag is derived from the version of the data the responses are computed from, the path and query
+// of the request and the media type negotiated, so it changes whenever the response could
+// It has to run after Produce
+func Conditional(policy CachePolicy, version DataVersion) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cacheControl, found := policy[Route(c.Request.Method, c.FullPath())]
+		if !found || c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		// the version is read before the handler computes the response, a response computed from newer data
+		// is only tagged as older and downloaded again
+		// without a version the response is served as it is, the handler reports the failure of the storage
+		dataVersion, err := version(c.Request.Context())
+		if err != nil {
+			c.Next()
+			return
+		}
+		etag := strongETag(dataVersion, c.Request.URL.RequestURI(), NegotiatedVersion(c))
+
+		if matches(c.GetHeader("If-None-Match"), etag) {
+			setCacheHeaders(c.Writer.Header(), etag, cacheControl)
+			c.Status(http.StatusNotModified)
+			c.Writer.WriteHeaderNow()
+			c.Abort()
+			return
+		}
+
+		c.Writer = &cacheWriter{ResponseWriter: c.Writer, etag: etag, cacheControl: cacheControl}
+		c.Next()
+	}
+}
+
+// strongETag hashes what identifies the response into a quoted entity tag
+func strongETag(version, requestURI, mediaType string) string {
+	hash := sha256.New()
+	hash.Write([]byte(version))
+	hash.Write([]byte{0})
+	hash.Write([]byte(requestURI))
+	hash.Write([]byte{0})
+	hash.Write([]byte(mediaType))
+
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// matches reports whether the If-None-Match header lists the entity tag, compared weakly as RFC 9110 requires
+func matches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func setCacheHeaders(header http.Header, etag, cacheControl string) {
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", cacheControl)
+	// the entity tag depends on the media type negotiated
+	header.Add("Vary", "Accept")
+}
+
+// cacheWriter sets the cache headers on successful responses only, errors are not cached
+type cacheWriter struct {
+	gin.ResponseWriter
+	etag         string
+	cacheControl string
+}
+
+// tag sets the headers before the status of a successful response is written
+func (w *cacheWriter) tag() {
+	if !w.Written() && w.Status() == http.StatusOK {
+		setCacheHeaders(w.Header(), w.etag, w.cacheControl)
+	}
+}
+
+func (w *cacheWriter) WriteHeaderNow() {
+	w.tag()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	w.tag()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheWriter) WriteString(s string) (int, error) {
+	w.tag()
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,149 @@
+package http_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	appHTTP "github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cachedRouter counts the requests reaching the handlers, the one of the route /fail fails
+// The referrals served change with the version of the data
+type cachedRouter struct {
+	*gin.Engine
+	version    int
+	versionErr error
+	handled    int
+}
+
+func newCachedRouter() *cachedRouter {
+	gin.SetMode(gin.TestMode)
+
+	policy := appHTTP.CachePolicy{
+		appHTTP.Route(http.MethodGet, "/referral"): "private, no-cache",
+		appHTTP.Route(http.MethodGet, "/fail"):     "private, no-cache",
+	}
+
+	router := &cachedRouter{Engine: gin.New()}
+	router.Use(
+		appHTTP.Produce(appHTTP.V1, appHTTP.V2),
+		appHTTP.Conditional(policy, func(context.Context) (string, error) {
+			return strconv.Itoa(router.version), router.versionErr
+		}),
+	)
+	ok := func(c *gin.Context) {
+		router.handled++
+		if appHTTP.NegotiatedVersion(c) == appHTTP.V2 {
+			c.JSON(http.StatusOK, []gin.H{{"userId": 1, "referrals": router.version}})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"1": router.version})
+	}
+	router.GET("/referral", ok)
+	router.GET("/uncached", ok)
+	router.GET("/fail", func(c *gin.Context) {
+		router.handled++
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "storage unavailable"})
+	})
+
+	return router
+}
+
+func (r *cachedRouter) get(path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestParseCachePolicy(t *testing.T) {
+	policy, err := appHTTP.ParseCachePolicy("/api/users/:id=private, max-age=60; /api/actions/referral=private, no-cache")
+	require.NoError(t, err)
+	assert.Equal(t, appHTTP.CachePolicy{
+		"GET /api/users/:id":        "private, max-age=60",
+		"GET /api/actions/referral": "private, no-cache",
+	}, policy)
+
+	for _, invalid := range []string{"/api/users/:id", "/api/users/:id=", "api/users=no-cache", "=no-cache"} {
+		_, err := appHTTP.ParseCachePolicy(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	// only the read routes can be cached
+	_, err = appHTTP.NewCachePolicy(appHTTP.CacheConfig{Enabled: true, Routes: "/api/actions/stream=no-cache"})
+	assert.Error(t, err)
+	policy, err = appHTTP.NewCachePolicy(appHTTP.CacheConfig{Enabled: false, Routes: "/api/actions/stream=no-cache"})
+	require.NoError(t, err)
+	assert.Empty(t, policy)
+}
+
+func TestConditional(t *testing.T) {
+	router := newCachedRouter()
+
+	w := router.get("/referral", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	assert.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	assert.JSONEq(t, `{"1": 0}`, w.Body.String())
+	assert.Equal(t, 1, router.handled)
+
+	for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		w = router.get("/referral", map[string]string{"If-None-Match": ifNoneMatch})
+		assert.Equal(t, http.StatusNotModified, w.Code, ifNoneMatch)
+		assert.Empty(t, w.Body.String())
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+		assert.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"))
+	}
+	assert.Equal(t, 1, router.handled, "the handler doesn't run for copies still current")
+
+	w = router.get("/referral", map[string]string{"If-None-Match": `"other"`})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	// another replica, or a restart, at the same version agrees on the tag
+	assert.Equal(t, etag, newCachedRouter().get("/referral", nil).Header().Get("ETag"))
+
+	// the tag changes with the query, the media type and the data
+	assert.NotEqual(t, etag, router.get("/referral?page=2", nil).Header().Get("ETag"))
+	assert.NotEqual(t, etag, router.get("/referral", map[string]string{"Accept": appHTTP.V2}).Header().Get("ETag"))
+
+	router.version++
+	w = router.get("/referral", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+	assert.JSONEq(t, `{"1": 1}`, w.Body.String())
+}
+
+func TestConditionalNotCached(t *testing.T) {
+	router := newCachedRouter()
+
+	// routes out of the policy
+	w := router.get("/uncached", map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("ETag"))
+	assert.Empty(t, w.Header().Get("Cache-Control"))
+
+	// errors
+	w = router.get("/fail", nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Header().Get("ETag"))
+	assert.Empty(t, w.Header().Get("Cache-Control"))
+
+	// the version of the data can't be read
+	router.versionErr = errors.New("users file unavailable")
+	w = router.get("/referral", map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("ETag"))
+}
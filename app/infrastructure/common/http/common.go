@@ -10,9 +10,15 @@ import (
 // Version HTTP API version
 type Version = string
 
-// V1 HTTP API version 1
+// V1 HTTP API version 1, deprecated in favour of V2
 const V1 Version = "application/vnd.surfe.v1+json"
 
+// V2 HTTP API version 2
+const V2 Version = "application/vnd.surfe.v2+json"
+
+// EventStream server-sent events stream
+const EventStream Version = "text/event-stream"
+
 // ErrEmptyBody error when body is empty
 var ErrEmptyBody = errors.New("missing request body")
 
@@ -20,10 +26,30 @@ func okResponseJson(c *gin.Context, obj any) {
 	c.JSON(http.StatusOK, obj)
 }
 
+func createdResponseJson(c *gin.Context, obj any) {
+	c.JSON(http.StatusCreated, obj)
+}
+
+func noContentResponse(c *gin.Context) {
+	c.Status(http.StatusNoContent)
+}
+
 func errorResponseJson(c *gin.Context, statusCode int, err string) {
 	c.AbortWithStatusJSON(statusCode, gin.H{"error": err})
 }
 
+// problemResponseJson writes an RFC 9457 problem details response
+func problemResponseJson(c *gin.Context, statusCode int, detail string) {
+	c.Header("Content-Type", "application/problem+json")
+	c.AbortWithStatusJSON(statusCode, gin.H{
+		"type":     "about:blank",
+		"title":    http.StatusText(statusCode),
+		"status":   statusCode,
+		"detail":   detail,
+		"instance": c.Request.URL.Path,
+	})
+}
+
 func handleRecovery(c *gin.Context, err any) {
 	if msg, ok := err.(string); ok {
 		errorResponseJson(c, http.StatusInternalServerError, msg)
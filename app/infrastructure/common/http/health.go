@@ -0,0 +1,159 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	livenessPath  = "/healthz"
+	readinessPath = "/readyz"
+
+	// StatusUp is the status of a passing check
+	StatusUp = "up"
+	// StatusDown is the status of a failing check
+	StatusDown = "down"
+)
+
+// Check verifies a dependency is usable, the context is done once the check timeout expires
+type Check func(ctx context.Context) error
+
+// CheckResult is the outcome of a check
+type CheckResult struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HealthReport is the readiness of the service with the result of every check
+type HealthReport struct {
+	Status string        `json:"status"`
+	Phase  string        `json:"phase"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// Phases of the service, only running services are ready
+const (
+	PhaseStarting = "starting"
+	PhaseRunning  = "running"
+	PhaseStopping = "stopping"
+)
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// HealthChecker runs the checks of the dependencies registered to report whether the service is ready
+// The service is not ready until it is marked as running, nor once it starts stopping
+type HealthChecker struct {
+	mutex   sync.RWMutex
+	checks  []namedCheck
+	phase   string
+	timeout time.Duration
+}
+
+// NewHealthChecker creates a health checker in the starting phase, each check is given timeout to complete
+func NewHealthChecker(timeout time.Duration) *HealthChecker {
+	return &HealthChecker{
+		phase:   PhaseStarting,
+		timeout: timeout,
+	}
+}
+
+// Register adds a check run on every readiness probe
+func (h *HealthChecker) Register(name string, check Check) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.checks = append(h.checks, namedCheck{name: name, check: check})
+}
+
+// SetPhase changes the phase of the service
+func (h *HealthChecker) SetPhase(phase string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.phase = phase
+}
+
+// Report runs every check concurrently, the service is up when it is running and every check passes
+func (h *HealthChecker) Report(ctx context.Context) HealthReport {
+	h.mutex.RLock()
+	checks := h.checks
+	phase := h.phase
+	h.mutex.RUnlock()
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check namedCheck) {
+			defer wg.Done()
+			results[i] = h.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	status := StatusUp
+	if phase != PhaseRunning {
+		status = StatusDown
+	}
+	for _, result := range results {
+		if result.Status != StatusUp {
+			status = StatusDown
+		}
+	}
+
+	return HealthReport{Status: status, Phase: phase, Checks: results}
+}
+
+func (h *HealthChecker) run(ctx context.Context, check namedCheck) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.check(ctx)
+	result := CheckResult{
+		Name:    check.name,
+		Status:  StatusUp,
+		Latency: time.Since(start).String(),
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// RegisterHealthHandlers registers the liveness and readiness probes, both are public
+func RegisterHealthHandlers(router *gin.Engine, checker *HealthChecker) {
+	router.GET(livenessPath, newLivenessHandler())
+	router.GET(readinessPath, newReadinessHandler(checker))
+}
+
+// newLivenessHandler answers as long as the process is able to serve requests
+func newLivenessHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": StatusUp})
+	}
+}
+
+func newReadinessHandler(checker *HealthChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := checker.Report(c.Request.Context())
+
+		statusCode := http.StatusOK
+		if report.Status != StatusUp {
+			statusCode = http.StatusServiceUnavailable
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(statusCode, report)
+	}
+}
@@ -0,0 +1,91 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	appHTTP "github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func probe(t *testing.T, router *gin.Engine, path string) (int, appHTTP.HealthReport) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var report appHTTP.HealthReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	return w.Code, report
+}
+
+func TestHealthHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	failing := errors.New("connection refused")
+	var brokerErr error
+
+	checker := appHTTP.NewHealthChecker(50 * time.Millisecond)
+	checker.Register("users file", func(context.Context) error { return nil })
+	checker.Register("broker", func(context.Context) error { return brokerErr })
+	checker.Register("database", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	router := gin.New()
+	appHTTP.RegisterHealthHandlers(router, checker)
+
+	// alive while starting
+	code, report := probe(t, router, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, appHTTP.StatusUp, report.Status)
+
+	code, report = probe(t, router, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, appHTTP.PhaseStarting, report.Phase)
+
+	checker.SetPhase(appHTTP.PhaseRunning)
+	brokerErr = failing
+
+	code, report = probe(t, router, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, appHTTP.StatusDown, report.Status)
+	require.Len(t, report.Checks, 3)
+	assert.Equal(t, "users file", report.Checks[0].Name)
+	assert.Equal(t, appHTTP.StatusUp, report.Checks[0].Status)
+	assert.NotEmpty(t, report.Checks[0].Latency)
+	assert.Equal(t, appHTTP.StatusDown, report.Checks[1].Status)
+	assert.Equal(t, "connection refused", report.Checks[1].Error)
+	// checks are bounded by the timeout
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks[2].Error)
+}
+
+func TestReadyWhenRunningAndChecksPass(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	checker := appHTTP.NewHealthChecker(time.Second)
+	checker.Register("users file", func(context.Context) error { return nil })
+
+	router := gin.New()
+	appHTTP.RegisterHealthHandlers(router, checker)
+
+	checker.SetPhase(appHTTP.PhaseRunning)
+	code, report := probe(t, router, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, appHTTP.StatusUp, report.Status)
+
+	// draining
+	checker.SetPhase(appHTTP.PhaseStopping)
+	code, report = probe(t, router, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, appHTTP.PhaseStopping, report.Phase)
+	assert.Equal(t, appHTTP.StatusUp, report.Checks[0].Status)
+}
@@ -1,16 +1,93 @@
 package http
 
 import (
+	"fmt"
 	"github.com/JoseBeteta/surfe/app/domain"
+	"github.com/gin-gonic/gin"
 	"log/slog"
 	"net/http"
 )
 
 var errorMap = map[error]int{
-	domain.InvalidArgument: http.StatusBadRequest,
+	domain.InvalidArgument:     http.StatusBadRequest,
+	domain.ActionAlreadyExists: http.StatusConflict,
+	domain.WebhookNotFound:     http.StatusNotFound,
+	domain.APIKeyNotFound:      http.StatusNotFound,
+	ErrRateLimited:             http.StatusTooManyRequests,
 }
 
 // NewHttpMapper creates a http mapper for inventory handlers
 func NewHttpMapper(logger slog.Logger) *Mapper {
 	return NewMapper(errorMap, logger)
 }
+
+// scopePolicy is the scope required by every API route
+// Analysts read aggregates, individual users and their timelines need users:read
+var scopePolicy = ScopePolicy{
+	Route(http.MethodGet, "/api/users/:id"):                         ScopeUsersRead,
+	Route(http.MethodGet, "/api/actions/users/:id"):                 ScopeUsersRead,
+	Route(http.MethodGet, "/api/actions/probability/users/:action"): ScopeAnalyticsRead,
+	Route(http.MethodGet, "/api/actions/referral"):                  ScopeAnalyticsRead,
+	Route(http.MethodPost, "/api/actions"):                          ScopeActionsWrite,
+	Route(http.MethodGet, "/api/actions/stream"):                    ScopeActionsRead,
+	Route(http.MethodPost, "/api/projections/rebuild"):              ScopeAdmin,
+	Route(http.MethodPost, "/api/webhooks"):                         ScopeAdmin,
+	Route(http.MethodGet, "/api/webhooks"):                          ScopeAdmin,
+	Route(http.MethodDelete, "/api/webhooks/:id"):                   ScopeAdmin,
+	Route(http.MethodGet, "/api/webhooks/:id/deliveries"):           ScopeAdmin,
+	Route(http.MethodGet, "/api/consumer/stats"):                    ScopeAdmin,
+	Route(http.MethodPost, "/api/admin/api-keys"):                   ScopeAdmin,
+	Route(http.MethodGet, "/api/admin/api-keys"):                    ScopeAdmin,
+	Route(http.MethodDelete, "/api/admin/api-keys/:id"):             ScopeAdmin,
+}
+
+// streamRoutes are the long lived routes, they are not subject to the handler timeout
+// Their paths have no parameters, so requests are matched by path
+var streamRoutes = map[string]struct{}{
+	Route(http.MethodGet, "/api/actions/stream"): {},
+}
+
+// NewScopeAuthorizer creates the middleware enforcing the scopes of the API routes
+func NewScopeAuthorizer(logger slog.Logger) gin.HandlerFunc {
+	return Authorize(scopePolicy, logger)
+}
+
+// RouteScope returns the scope required by the route, false when the route has no policy
+func RouteScope(method, fullPath string) (string, bool) {
+	scope, found := scopePolicy[Route(method, fullPath)]
+	return scope, found
+}
+
+// cacheableRoutes are the read routes whose responses may be cached, the ones computed from the projections and the users
+// Dashboards polling them revalidate their copy, which is downloaded again only once the data changes
+var cacheableRoutes = map[string]struct{}{
+	Route(http.MethodGet, "/api/users/:id"):                         {},
+	Route(http.MethodGet, "/api/actions/users/:id"):                 {},
+	Route(http.MethodGet, "/api/actions/probability/users/:action"): {},
+	Route(http.MethodGet, "/api/actions/referral"):                  {},
+}
+
+// NewCachePolicy parses the Cache-Control of the routes configured, failing for routes that can't be cached
+func NewCachePolicy(cfg CacheConfig) (CachePolicy, error) {
+	if !cfg.Enabled {
+		return CachePolicy{}, nil
+	}
+
+	policy, err := ParseCachePolicy(cfg.Routes)
+	if err != nil {
+		return nil, err
+	}
+	for route := range policy {
+		if !RouteCacheable(route) {
+			return nil, fmt.Errorf("%s can't be cached", route)
+		}
+	}
+
+	return policy, nil
+}
+
+// RouteCacheable reports whether the responses of the route, as returned by Route, may be cached
+func RouteCacheable(route string) bool {
+	_, found := cacheableRoutes[route]
+	return found
+}
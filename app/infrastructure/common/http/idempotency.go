@@ -0,0 +1,234 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// IdempotencyKeyHeader is the header clients set to retry a write request safely
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses replayed from a previous request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	idempotencySweepEvery   = time.Minute
+)
+
+// IdempotencyRecord is the request fingerprint and the response stored for an idempotency key
+type IdempotencyRecord struct {
+	Fingerprint string
+	// Completed is false while the first request with the key is being handled
+	Completed   bool
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// IdempotencyStore keeps the records of idempotency keys until they expire
+type IdempotencyStore interface {
+	// Reserve stores the record unless the key has one not expired yet, which is returned instead
+	Reserve(key string, record IdempotencyRecord) (IdempotencyRecord, bool, error)
+	// Complete replaces the record of a reserved key
+	Complete(key string, record IdempotencyRecord) error
+	// Release removes the record of the key so the request can be retried
+	Release(key string) error
+}
+
+// MemoryIdempotencyStore is an in memory idempotency store, expired records are swept periodically
+type MemoryIdempotencyStore struct {
+	mutex     sync.Mutex
+	records   map[string]IdempotencyRecord
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryIdempotencyStore creates an empty store
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records: make(map[string]IdempotencyRecord),
+		now:     time.Now,
+	}
+}
+
+// WithClock allows you to specify the clock used to expire records
+func (s *MemoryIdempotencyStore) WithClock(now func() time.Time) *MemoryIdempotencyStore {
+	s.now = now
+
+	return s
+}
+
+func (s *MemoryIdempotencyStore) Reserve(key string, record IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	if stored, found := s.records[key]; found && now.Before(stored.ExpiresAt) {
+		return stored, false, nil
+	}
+	s.records[key] = record
+
+	return record, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(key string, record IdempotencyRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.records[key] = record
+
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.records, key)
+
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < idempotencySweepEvery {
+		return
+	}
+	s.lastSweep = now
+
+	for key, record := range s.records {
+		if !now.Before(record.ExpiresAt) {
+			delete(s.records, key)
+		}
+	}
+}
+
+// Idempotent replays the stored response of write requests retried with the same Idempotency-Key
+// Retries with a different method, path or body are rejected with 422, and retries while the first
+// request is still being handled with 409. Server errors are not stored so the request can be retried
+// Requests without the header and read requests are handled as usual
+func Idempotent(store IdempotencyStore, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" || !isWriteMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			problemResponseJson(c, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		// the body is bounded by LimitBody, which has to run before
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			bodyReadError(c, err)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// keys of authenticated clients don't collide with the keys of other clients
+		if subject := Subject(c); subject != "" {
+			key = subject + "\x00" + key
+		}
+
+		fingerprint := requestFingerprint(c.Request, body)
+		stored, reserved, err := store.Reserve(key, IdempotencyRecord{
+			Fingerprint: fingerprint,
+			ExpiresAt:   time.Now().Add(ttl),
+		})
+		if err != nil {
+			errorResponseJson(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if !reserved {
+			replay(c, stored, fingerprint)
+			return
+		}
+
+		// released unless completed, also when the handler panics
+		completed := false
+		defer func() {
+			if !completed {
+				_ = store.Release(key)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			return
+		}
+
+		completed = true
+		_ = store.Complete(key, IdempotencyRecord{
+			Fingerprint: fingerprint,
+			Completed:   true,
+			StatusCode:  c.Writer.Status(),
+			ContentType: c.Writer.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+			ExpiresAt:   time.Now().Add(ttl),
+		})
+	}
+}
+
+func replay(c *gin.Context, stored IdempotencyRecord, fingerprint string) {
+	switch {
+	case stored.Fingerprint != fingerprint:
+		problemResponseJson(c, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+	case !stored.Completed:
+		problemResponseJson(c, http.StatusConflict, "a request with the same Idempotency-Key is being handled")
+	default:
+		c.Header(IdempotentReplayedHeader, "true")
+		c.Data(stored.StatusCode, stored.ContentType, stored.Body)
+		c.Abort()
+	}
+}
+
+// requestFingerprint identifies the request a key was used with
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method))
+	hash.Write([]byte{0})
+	hash.Write([]byte(r.URL.Path))
+	hash.Write([]byte{0})
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func isWriteMethod(method string) bool {
+	return method == http.MethodPost ||
+		method == http.MethodPut ||
+		method == http.MethodPatch ||
+		method == http.MethodDelete
+}
+
+// responseRecorder keeps a copy of the response body written
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,171 @@
+package http_test
+
+import (
+	appHTTP "github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newIdempotentRouter(store appHTTP.IdempotencyStore, calls *int, status int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(appHTTP.Idempotent(store, time.Hour))
+	router.POST("/actions", func(c *gin.Context) {
+		*calls++
+		c.JSON(status, gin.H{"call": *calls})
+	})
+	router.GET("/actions", func(c *gin.Context) {
+		*calls++
+		c.JSON(http.StatusOK, gin.H{"call": *calls})
+	})
+
+	return router
+}
+
+func send(router *gin.Engine, method, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/actions", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(appHTTP.IdempotencyKeyHeader, key)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestIdempotentReplaysResponse(t *testing.T) {
+	calls := 0
+	router := newIdempotentRouter(appHTTP.NewMemoryIdempotencyStore(), &calls, http.StatusCreated)
+
+	first := send(router, http.MethodPost, "key-1", `{"type":"WELCOME"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.JSONEq(t, `{"call":1}`, first.Body.String())
+
+	retry := send(router, http.MethodPost, "key-1", `{"type":"WELCOME"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.JSONEq(t, `{"call":1}`, retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get(appHTTP.IdempotentReplayedHeader))
+	assert.Equal(t, first.Header().Get("Content-Type"), retry.Header().Get("Content-Type"))
+
+	// a different body with the same key
+	mismatch := send(router, http.MethodPost, "key-1", `{"type":"ADD_CONTACT"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, mismatch.Code)
+	assert.Equal(t, "application/problem+json", mismatch.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "about:blank",
+		"title": "Unprocessable Entity",
+		"status": 422,
+		"detail": "Idempotency-Key was already used with a different request",
+		"instance": "/actions"
+	}`, mismatch.Body.String())
+
+	// another key, and requests without a key, are handled
+	assert.JSONEq(t, `{"call":2}`, send(router, http.MethodPost, "key-2", `{"type":"WELCOME"}`).Body.String())
+	assert.JSONEq(t, `{"call":3}`, send(router, http.MethodPost, "", `{"type":"WELCOME"}`).Body.String())
+	// read requests ignore the key
+	assert.JSONEq(t, `{"call":4}`, send(router, http.MethodGet, "key-1", "").Body.String())
+	assert.Equal(t, 4, calls)
+}
+
+func TestIdempotentRejectsLongKeys(t *testing.T) {
+	calls := 0
+	router := newIdempotentRouter(appHTTP.NewMemoryIdempotencyStore(), &calls, http.StatusCreated)
+
+	w := send(router, http.MethodPost, strings.Repeat("k", 256), `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.Zero(t, calls)
+}
+
+func TestIdempotentDoesNotStoreServerErrors(t *testing.T) {
+	calls := 0
+	router := newIdempotentRouter(appHTTP.NewMemoryIdempotencyStore(), &calls, http.StatusInternalServerError)
+
+	send(router, http.MethodPost, "key-1", `{}`)
+	send(router, http.MethodPost, "key-1", `{}`)
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotentRejectsConcurrentRetries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	router := gin.New()
+	router.Use(appHTTP.Idempotent(appHTTP.NewMemoryIdempotencyStore(), time.Hour))
+	router.POST("/actions", func(c *gin.Context) {
+		close(started)
+		<-release
+		c.JSON(http.StatusCreated, gin.H{})
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- send(router, http.MethodPost, "key-1", `{}`) }()
+	<-started
+
+	// the first request is still being handled
+	conflict := send(router, http.MethodPost, "key-1", `{}`)
+	assert.Equal(t, http.StatusConflict, conflict.Code)
+	assert.Equal(t, "application/problem+json", conflict.Header().Get("Content-Type"))
+
+	close(release)
+	assert.Equal(t, http.StatusCreated, (<-done).Code)
+	assert.Equal(t, http.StatusCreated, send(router, http.MethodPost, "key-1", `{}`).Code)
+}
+
+func TestMemoryIdempotencyStoreExpiresRecords(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	store := appHTTP.NewMemoryIdempotencyStore().WithClock(func() time.Time { return now })
+
+	_, reserved, err := store.Reserve("key", appHTTP.IdempotencyRecord{Fingerprint: "a", ExpiresAt: now.Add(time.Hour)})
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+
+	stored, reserved, err := store.Reserve("key", appHTTP.IdempotencyRecord{Fingerprint: "b", ExpiresAt: now.Add(time.Hour)})
+	assert.NoError(t, err)
+	assert.False(t, reserved)
+	assert.Equal(t, "a", stored.Fingerprint)
+
+	now = now.Add(time.Hour)
+	_, reserved, err = store.Reserve("key", appHTTP.IdempotencyRecord{Fingerprint: "b", ExpiresAt: now.Add(time.Hour)})
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+}
+
+func TestIdempotentRejectsBodiesOverTheLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	calls := 0
+	router := gin.New()
+	router.Use(appHTTP.LimitBody(16), appHTTP.Idempotent(appHTTP.NewMemoryIdempotencyStore(), time.Hour))
+	router.POST("/actions", func(c *gin.Context) {
+		calls++
+		c.Status(http.StatusCreated)
+	})
+
+	assert.Equal(t, http.StatusCreated, send(router, http.MethodPost, "key-1", `{"type":"A"}`).Code)
+
+	// announced by the Content-Length
+	tooLarge := send(router, http.MethodPost, "key-2", `{"type":"WELCOME"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, tooLarge.Code)
+	assert.Equal(t, "application/problem+json", tooLarge.Header().Get("Content-Type"))
+
+	// only found out while reading it
+	req := httptest.NewRequest(http.MethodPost, "/actions", io.MultiReader(strings.NewReader(`{"type":`), strings.NewReader(`"WELCOME"}`)))
+	req.ContentLength = -1
+	req.Header.Set(appHTTP.IdempotencyKeyHeader, "key-3")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	assert.Equal(t, 1, calls)
+}
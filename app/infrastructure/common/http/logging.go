@@ -0,0 +1,97 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/logging"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// RequestIDHeader carries the ID of the request, set by the caller or generated
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDKey is the context key of the request ID
+	RequestIDKey = "request.id"
+
+	maxRequestIDLength = 128
+)
+
+// excludeAccessLog are the routes polled by the infrastructure, not worth a log line per request
+var excludeAccessLog = map[string]struct{}{
+	livenessPath:  {},
+	readinessPath: {},
+	metricsPath:   {},
+}
+
+// RequestID identifies every request with the X-Request-ID header of the caller, or a new ID when it has none or it
+// is not valid. The ID is returned in the response and carried by the request context, tagging the records logged with it
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		c.Set(RequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+
+		c.Next()
+	}
+}
+
+// GetRequestID returns the ID of the request
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
+
+// validRequestID accepts visible ASCII IDs, so they can't forge log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AccessLog logs every request once it is answered
+func AccessLog(logger slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		if _, ok := excludeAccessLog[c.FullPath()]; ok {
+			return
+		}
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+
+		logger.LogAttrs(c.Request.Context(), level, "http request",
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+		)
+	}
+}
@@ -0,0 +1,84 @@
+package http_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	appHTTP "github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestIDAndAccessLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var buf bytes.Buffer
+	logger := logging.New(logging.Config{Format: logging.FormatJSON}, &buf)
+
+	router := gin.New()
+	router.Use(appHTTP.RequestID(), appHTTP.AccessLog(*logger))
+	appHTTP.RegisterHealthHandlers(router, appHTTP.NewHealthChecker(0))
+	router.GET("/api/users/:id", func(c *gin.Context) {
+		logger.WarnContext(c.Request.Context(), "user not found")
+		c.String(http.StatusNotFound, "missing")
+	})
+
+	tests := []struct {
+		name     string
+		incoming string
+		reused   bool
+	}{
+		{"incoming ID", "abc-123", true},
+		{"generated when missing", "", false},
+		{"generated when not printable", "abc\n{\"forged\":true}", false},
+		{"generated when too long", strings.Repeat("a", 129), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+
+			req := httptest.NewRequest(http.MethodGet, "/api/users/1", nil)
+			if tt.incoming != "" {
+				req.Header.Set(appHTTP.RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			id := w.Header().Get(appHTTP.RequestIDHeader)
+			if tt.reused {
+				assert.Equal(t, tt.incoming, id)
+			} else {
+				assert.Len(t, id, 32)
+			}
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			require.Len(t, lines, 2)
+
+			var handler, access map[string]any
+			require.NoError(t, json.Unmarshal([]byte(lines[0]), &handler))
+			require.NoError(t, json.Unmarshal([]byte(lines[1]), &access))
+
+			assert.Equal(t, id, handler["request_id"])
+			assert.Equal(t, id, access["request_id"])
+			assert.Equal(t, "http request", access["msg"])
+			assert.Equal(t, "GET", access["method"])
+			assert.Equal(t, "/api/users/:id", access["route"])
+			assert.Equal(t, "/api/users/1", access["path"])
+			assert.Equal(t, float64(http.StatusNotFound), access["status"])
+			assert.Equal(t, float64(len("missing")), access["bytes"])
+			assert.Contains(t, access, "latency_ms")
+		})
+	}
+
+	// probes are not logged
+	buf.Reset()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Empty(t, buf.String())
+}
@@ -39,16 +39,28 @@ func (e *Mapper) OkResponse(c *gin.Context, obj any) {
 	okResponseJson(c, obj)
 }
 
+// CreatedResponse writes http 201 created and json response
+func (e *Mapper) CreatedResponse(c *gin.Context, obj any) {
+	createdResponseJson(c, obj)
+}
+
+// NoContentResponse writes http 204 no content
+func (e *Mapper) NoContentResponse(c *gin.Context) {
+	noContentResponse(c)
+}
+
 func (e *Mapper) ErrorResponse(c *gin.Context, err error) {
 	statusCode := e.getStatusCode(err)
 
+	message := getErrorMessage(err)
+	attrs := []any{"status", statusCode, "route", c.FullPath(), "error", message}
 	if isClientError(statusCode) {
-		e.Logger.Warn("error in http request")
+		e.Logger.WarnContext(c.Request.Context(), "error in http request", attrs...)
 	} else {
-		e.Logger.Error("error in the server")
+		e.Logger.ErrorContext(c.Request.Context(), "error in the server", attrs...)
 	}
 
-	errorResponseJson(c, statusCode, getErrorMessage(err))
+	errorResponseJson(c, statusCode, message)
 }
 
 func isClientError(statusCode int) bool {
@@ -66,6 +78,11 @@ func (e *Mapper) getStatusCode(incomingErr error) int {
 		}
 	}
 
+	var tooLarge *http.MaxBytesError
+	if errors.As(incomingErr, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+
 	if isBadRequest(incomingErr) {
 		return http.StatusBadRequest
 	}
@@ -0,0 +1,14 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const metricsPath = "/metrics"
+
+// RegisterMetricsHandler registers the endpoint scraped for metrics, it is public as the probes
+func RegisterMetricsHandler(router *gin.Engine, handler http.Handler) {
+	router.GET(metricsPath, gin.WrapH(handler))
+}
@@ -0,0 +1,40 @@
+package http_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	appHTTP "github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	registry := metrics.NewRegistry()
+	router := gin.New()
+	router.Use(appHTTP.MetricsMiddleware(registry))
+	appHTTP.RegisterMetricsHandler(router, registry)
+	appHTTP.RegisterHomeHandler(router)
+	router.GET("/api/users/:id", func(c *gin.Context) {
+		c.Status(http.StatusNotFound)
+	})
+
+	for _, path := range []string{"/api/users/1", "/api/users/2", "/"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, metrics.ContentType, w.Header().Get("Content-Type"))
+	// labelled by route template, the home page and the metrics endpoint are not recorded
+	assert.Contains(t, w.Body.String(),
+		`http_request_duration_seconds_count{method="GET",route="/api/users/:id",status="404"} 2`)
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "_count{"))
+}
@@ -3,13 +3,19 @@ package http
 import (
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 var excludeMetrics = map[string]struct{}{
-	homePath: {},
+	homePath:      {},
+	livenessPath:  {},
+	readinessPath: {},
+	metricsPath:   {},
 }
 
 // MetricsAgent is the metrics agent interface
@@ -17,62 +23,71 @@ type MetricsAgent interface {
 	Timing(name string, duration time.Duration, tags []string)
 }
 
-// Consume ensures client is sending data in specific content type / version
-func Consume(version Version) gin.HandlerFunc {
+// Consume ensures client is sending data in one of the content types / versions
+func Consume(versions ...Version) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if c.Request.ContentLength == 0 {
 			c.Next()
 			return
 		}
 
-		if c.ContentType() == version {
+		if slices.Contains(versions, c.ContentType()) {
 			c.Next()
 			return
 		}
 
 		errorMessage := fmt.Sprintf(
-			"Unsupported content type %q; expected client to send %q",
+			"Unsupported content type %q; expected client to send %s",
 			c.ContentType(),
-			version,
+			quoteVersions(versions),
 		)
 
 		errorResponseJson(c, http.StatusUnsupportedMediaType, errorMessage)
 	}
 }
 
-// Produce ensures client is able to accept a response in specific content type / version
-func Produce(version Version) gin.HandlerFunc {
+// Produce ensures client is able to accept a response in one of the content types / versions
+// The first version the client accepts is negotiated, the first one given when it accepts any,
+// and stored in the context for the handlers to respond in
+func Produce(versions ...Version) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if c.NegotiateFormat(version) == version {
+		if version := c.NegotiateFormat(versions...); slices.Contains(versions, version) {
+			c.Set(VersionKey, version)
 			c.Header("Content-Type", fmt.Sprintf("%s; charset=utf-8", version))
 			c.Next()
 			return
 		}
 
 		errorMessage := fmt.Sprintf(
-			"Unable to produce response of type %q; expected client to accept %q",
+			"Unable to produce response of type %q; expected client to accept %s",
 			c.Accepted,
-			version,
+			quoteVersions(versions),
 		)
 
 		errorResponseJson(c, http.StatusNotAcceptable, errorMessage)
 	}
 }
 
+// quoteVersions lists the versions quoted, "a" or "b"
+func quoteVersions(versions []Version) string {
+	quoted := make([]string, len(versions))
+	for i, version := range versions {
+		quoted[i] = strconv.Quote(version)
+	}
+	return strings.Join(quoted, " or ")
+}
+
 // MetricsMiddleware sends api metrics
+// Requests are tagged by route template rather than URL path so the number of series stays bounded
 func MetricsMiddleware(m MetricsAgent) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if _, ok := excludeMetrics[c.FullPath()]; !ok {
 			t := time.Now()
 			defer func() {
 				m.Timing("http_request", time.Since(t), []string{
-					fmt.Sprintf("full_path:%v", c.FullPath()),
-					fmt.Sprintf("path_params:%v", c.Params),
+					fmt.Sprintf("route:%v", c.FullPath()),
 					fmt.Sprintf("method:%v", c.Request.Method),
-					fmt.Sprintf("url_path:%v", c.Request.URL.Path),
-					fmt.Sprintf("request_content_length:%v", c.Request.ContentLength),
-					fmt.Sprintf("response_status_code:%v", c.Writer.Status()),
-					fmt.Sprintf("response_size:%v", c.Writer.Size()),
+					fmt.Sprintf("status:%v", c.Writer.Status()),
 				})
 			}()
 		}
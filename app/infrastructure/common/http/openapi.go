@@ -0,0 +1,28 @@
+package http
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	openAPIPath = "/openapi.json"
+	docsPath    = "/docs"
+)
+
+// docsPage renders the OpenAPI document served by the service, without loading anything else
+//
+//go:embed docs.html
+var docsPage []byte
+
+// RegisterOpenAPIHandlers serves the OpenAPI document and a page documenting the API from it, both are public
+func RegisterOpenAPIHandlers(router *gin.Engine, spec []byte) {
+	router.GET(openAPIPath, func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", spec)
+	})
+	router.GET(docsPath, func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", docsPage)
+	})
+}
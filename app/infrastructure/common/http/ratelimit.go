@@ -0,0 +1,269 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DefaultRateLimitGroup is the group of the limit applied to routes not matching any other group
+	DefaultRateLimitGroup = "default"
+
+	rateLimitSweepEvery = time.Minute
+)
+
+// ErrRateLimited error when a client exceeded the rate limit of a route group
+var ErrRateLimited = errors.New("rate limit exceeded, retry later")
+
+// RateLimitConfig are the configurations related to rate limiting clients
+// Limits are a comma separated list of group=requests/period, groups are route prefixes,
+// e.g. "default=300/1m,/api/actions/referral=30/1m"
+// IPLimits are applied by IP before authentication, so requests failing it are limited too
+type RateLimitConfig struct {
+	Enabled  bool   `env:"RATE_LIMIT_ENABLED" env-default:"true"`
+	Limits   string `env:"RATE_LIMITS" env-default:"default=300/1m,/api/actions/referral=30/1m"`
+	IPLimits string `env:"RATE_LIMITS_IP" env-default:"default=600/1m"`
+}
+
+// RateLimit allows Requests every Per, in bursts of up to Requests
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// perSecond is the rate tokens are refilled at
+func (l RateLimit) perSecond() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// RateLimits are the limits of every route group
+type RateLimits map[string]RateLimit
+
+// ParseRateLimits parses a comma separated list of group=requests/period
+func ParseRateLimits(s string) (RateLimits, error) {
+	limits := RateLimits{}
+	if strings.TrimSpace(s) == "" {
+		return limits, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		group, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || group == "" {
+			return nil, fmt.Errorf("invalid rate limit %q, expected group=requests/period", pair)
+		}
+
+		requests, period, found := strings.Cut(value, "/")
+		if !found {
+			return nil, fmt.Errorf("invalid rate limit %q, expected group=requests/period", pair)
+		}
+
+		n, err := strconv.Atoi(requests)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid requests of rate limit %q", pair)
+		}
+		per, err := time.ParseDuration(period)
+		if err != nil || per <= 0 {
+			return nil, fmt.Errorf("invalid period of rate limit %q", pair)
+		}
+
+		limits[group] = RateLimit{Requests: n, Per: per}
+	}
+
+	return limits, nil
+}
+
+// bucket is the token bucket of a client in a route group
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   RateLimit
+}
+
+// refill adds the tokens earned since the last update, up to the bucket capacity
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated).Seconds()
+	b.tokens = math.Min(float64(b.limit.Requests), b.tokens+elapsed*b.limit.perSecond())
+	b.updated = now
+}
+
+// RateLimiter limits the requests of every client with a token bucket per route group
+// Clients are identified by their API key or token subject when authenticated, by IP otherwise
+type RateLimiter struct {
+	limits     RateLimits
+	groups     []string
+	httpMapper *Mapper
+	byIP       bool
+
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewRateLimiter creates a rate limiter, routes not matching any group and without a default limit are not limited
+func NewRateLimiter(limits RateLimits, httpMapper *Mapper) *RateLimiter {
+	return &RateLimiter{
+		limits:     limits,
+		groups:     groups(limits),
+		httpMapper: httpMapper,
+		buckets:    make(map[string]*bucket),
+		now:        time.Now,
+	}
+}
+
+// groups returns the route groups of the limits, the longest prefix matching a route is its group
+func groups(limits RateLimits) []string {
+	groups := make([]string, 0, len(limits))
+	for group := range limits {
+		if group != DefaultRateLimitGroup {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return len(groups[i]) > len(groups[j])
+	})
+
+	return groups
+}
+
+// SetLimits replaces the limits, the buckets of the clients keep their tokens up to the new capacity
+func (l *RateLimiter) SetLimits(limits RateLimits) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.limits = limits
+	l.groups = groups(limits)
+}
+
+// WithClock allows you to specify the clock buckets are refilled with
+func (l *RateLimiter) WithClock(now func() time.Time) *RateLimiter {
+	l.now = now
+
+	return l
+}
+
+// ByIP makes the limiter identify clients by IP only, so it can limit requests before they are authenticated
+func (l *RateLimiter) ByIP() *RateLimiter {
+	l.byIP = true
+
+	return l
+}
+
+// Limit rejects the requests of clients out of tokens with 429, every response
+// carries the RateLimit-* headers of the client bucket
+func (l *RateLimiter) Limit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.FullPath()
+		if path == "" {
+			path = c.Request.URL.Path
+		}
+
+		group, limit, found := l.group(path)
+		if !found {
+			c.Next()
+			return
+		}
+
+		allowed, remaining, reset, retryAfter := l.take(l.clientKey(c)+"|"+group, limit)
+
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, int(limit.Per.Seconds())))
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+			l.httpMapper.ErrorResponse(c, ErrRateLimited)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func (l *RateLimiter) group(path string) (string, RateLimit, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, group := range l.groups {
+		if strings.HasPrefix(path, group) {
+			return group, l.limits[group], true
+		}
+	}
+
+	limit, found := l.limits[DefaultRateLimitGroup]
+	return DefaultRateLimitGroup, limit, found
+}
+
+// take spends a token of the bucket, returning whether there was one, the tokens left,
+// the time until the bucket is full and the time until the next token
+func (l *RateLimiter) take(key string, limit RateLimit) (bool, int, time.Duration, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(limit.Requests), updated: now, limit: limit}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+	if b.limit != limit {
+		// the limits changed since the bucket was created
+		b.limit = limit
+		b.tokens = math.Min(b.tokens, float64(limit.Requests))
+	}
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	rate := limit.perSecond()
+	reset := seconds((float64(limit.Requests) - b.tokens) / rate)
+	retryAfter := seconds(math.Max(0, 1-b.tokens) / rate)
+
+	return allowed, int(b.tokens), reset, retryAfter
+}
+
+// sweep drops the buckets refilled to capacity, they are recreated full when needed
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepEvery {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Requests) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// clientKey identifies the client, authenticated clients keep their limit across IPs unless limited by IP
+func (l *RateLimiter) clientKey(c *gin.Context) string {
+	if subject := Subject(c); subject != "" && !l.byIP {
+		return "subject:" + subject
+	}
+
+	return "ip:" + c.ClientIP()
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,197 @@
+package http_test
+
+import (
+	appHTTP "github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mocks "github.com/JoseBeteta/surfe/test/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := appHTTP.ParseRateLimits("default=300/1m, /api/actions/referral=2/10s")
+	require.NoError(t, err)
+	assert.Equal(t, appHTTP.RateLimits{
+		"default":               {Requests: 300, Per: time.Minute},
+		"/api/actions/referral": {Requests: 2, Per: 10 * time.Second},
+	}, limits)
+
+	for _, invalid := range []string{"default", "default=300", "default=x/1m", "default=0/1m", "default=3/x", "=3/1m"} {
+		_, err := appHTTP.ParseRateLimits(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	limiter := appHTTP.NewRateLimiter(appHTTP.RateLimits{
+		"default":               {Requests: 100, Per: time.Minute},
+		"/api/actions/referral": {Requests: 2, Per: 10 * time.Second},
+	}, appHTTP.NewHttpMapper(mocks.NewNullLogger())).WithClock(func() time.Time { return now })
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if subject := c.GetHeader("X-Subject"); subject != "" {
+			c.Set(appHTTP.SubjectKey, subject)
+		}
+	}, limiter.Limit())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/api/actions/referral", ok)
+	router.GET("/api/actions/users/:id", ok)
+
+	request := func(path, subject string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if subject != "" {
+			req.Header.Set("X-Subject", subject)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := request("/api/actions/referral", "")
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "2", first.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", first.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "5", first.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "2;w=10", first.Header().Get("RateLimit-Policy"))
+
+	assert.Equal(t, http.StatusOK, request("/api/actions/referral", "").Code)
+
+	limited := request("/api/actions/referral", "")
+	assert.Equal(t, http.StatusTooManyRequests, limited.Code)
+	assert.Equal(t, "0", limited.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "5", limited.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error":"rate limit exceeded, retry later"}`, limited.Body.String())
+
+	// other groups and other clients have their own buckets
+	assert.Equal(t, http.StatusOK, request("/api/actions/users/1", "").Code)
+	assert.Equal(t, "100", request("/api/actions/users/1", "").Header().Get("RateLimit-Limit"))
+	assert.Equal(t, http.StatusOK, request("/api/actions/referral", "apikey:1").Code)
+
+	// a token is refilled every 5 seconds
+	now = now.Add(5 * time.Second)
+	assert.Equal(t, http.StatusOK, request("/api/actions/referral", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, request("/api/actions/referral", "").Code)
+}
+
+func TestRateLimiterSetLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	limiter := appHTTP.NewRateLimiter(appHTTP.RateLimits{
+		"default": {Requests: 5, Per: time.Minute},
+	}, appHTTP.NewHttpMapper(mocks.NewNullLogger())).WithClock(func() time.Time { return now })
+
+	router := gin.New()
+	router.Use(limiter.Limit())
+	router.GET("/api/users/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	request := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/users/1", nil))
+		return w
+	}
+
+	assert.Equal(t, "4", request().Header().Get("RateLimit-Remaining"))
+
+	// the bucket keeps its tokens up to the new capacity
+	limiter.SetLimits(appHTTP.RateLimits{"default": {Requests: 2, Per: time.Minute}})
+	second := request()
+	assert.Equal(t, "2", second.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", second.Header().Get("RateLimit-Remaining"))
+
+	// without limits requests are not limited
+	limiter.SetLimits(appHTTP.RateLimits{})
+	unlimited := request()
+	assert.Equal(t, http.StatusOK, unlimited.Code)
+	assert.Empty(t, unlimited.Header().Get("RateLimit-Limit"))
+}
+
+// clients are identified by the address connecting unless it is a trusted proxy, so they can't spoof X-Forwarded-For
+func TestRateLimiterClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		trustedProxies string
+		limited        bool
+	}{
+		{name: "spoofed header", trustedProxies: "", limited: true},
+		{name: "trusted proxy", trustedProxies: "10.0.0.0/8, 192.168.0.1", limited: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := appHTTP.NewRateLimiter(appHTTP.RateLimits{
+				"default": {Requests: 1, Per: time.Minute},
+			}, appHTTP.NewHttpMapper(mocks.NewNullLogger()))
+
+			router := gin.New()
+			require.NoError(t, router.SetTrustedProxies(appHTTP.Config{TrustedProxies: tt.trustedProxies}.TrustedProxyList()))
+			router.Use(limiter.Limit())
+			router.GET("/api/users/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			request := func(forwardedFor string) int {
+				req := httptest.NewRequest(http.MethodGet, "/api/users/1", nil)
+				req.RemoteAddr = "10.0.0.1:1234"
+				req.Header.Set("X-Forwarded-For", forwardedFor)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				return w.Code
+			}
+
+			assert.Equal(t, http.StatusOK, request("203.0.113.1"))
+			if tt.limited {
+				assert.Equal(t, http.StatusTooManyRequests, request("203.0.113.2"))
+			} else {
+				assert.Equal(t, http.StatusOK, request("203.0.113.2"))
+			}
+		})
+	}
+}
+
+// requests are limited by IP before they are authenticated, so failed attempts are limited too
+func TestRateLimiterByIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	limiter := appHTTP.NewRateLimiter(appHTTP.RateLimits{
+		"default": {Requests: 2, Per: time.Minute},
+	}, appHTTP.NewHttpMapper(mocks.NewNullLogger())).ByIP()
+
+	router := gin.New()
+	router.Use(limiter.Limit(), func(c *gin.Context) {
+		subject := c.GetHeader("X-Subject")
+		if subject == "" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Set(appHTTP.SubjectKey, subject)
+	})
+	router.GET("/api/users/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	request := func(subject string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/users/1", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if subject != "" {
+			req.Header.Set("X-Subject", subject)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request(""))
+	assert.Equal(t, http.StatusOK, request("apikey:1"))
+	// the IP is out of tokens, whoever the caller claims to be
+	assert.Equal(t, http.StatusTooManyRequests, request(""))
+	assert.Equal(t, http.StatusTooManyRequests, request("apikey:2"))
+}
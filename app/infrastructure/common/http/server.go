@@ -4,6 +4,7 @@ import (
 	stdLogger "log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -11,20 +12,65 @@ import (
 
 // Config are the configurations related to the HTTP Server
 type Config struct {
-	HTTPPort       string `env:"HTTP_PORT"`
-	ReadTimeout    time.Duration
-	WriteTimeout   time.Duration
-	IdleTimeout    time.Duration
-	HandlerTimeout time.Duration
+	HTTPPort string `env:"HTTP_PORT" env-default:":8080" validate:"required"`
+	// ReadTimeout bounds reading a whole request, body included
+	ReadTimeout time.Duration `env:"HTTP_READ_TIMEOUT" env-default:"5s" validate:"gt=0"`
+	// HandlerTimeout bounds the time handlers take to respond, event streams excluded
+	HandlerTimeout time.Duration `env:"HTTP_HANDLER_TIMEOUT" env-default:"5s" validate:"gt=0"`
+	// WriteTimeout bounds writing the response, it has to leave time to write the timeout response of a slow handler
+	WriteTimeout time.Duration `env:"HTTP_WRITE_TIMEOUT" env-default:"10s" validate:"gtfield=HandlerTimeout"`
+	// IdleTimeout is how long keep-alive connections wait for the next request
+	IdleTimeout time.Duration `env:"HTTP_IDLE_TIMEOUT" env-default:"15s" validate:"gt=0"`
+	// MaxBodySize bounds the size in bytes of request bodies, larger ones are rejected with 413
+	MaxBodySize int64 `env:"HTTP_MAX_BODY_SIZE" env-default:"1048576" validate:"gt=0"`
+	// IdempotencyKeyTTL is how long the response of a request with an Idempotency-Key is replayed
+	IdempotencyKeyTTL time.Duration `env:"IDEMPOTENCY_KEY_TTL" env-default:"24h"`
+	// ShutdownTimeout is how long in-flight requests and background workers have to finish on shutdown
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_DRAIN_TIMEOUT" env-default:"30s"`
+	// ShutdownDelay is how long readiness fails before the server stops accepting connections,
+	// so load balancers stop routing requests to it first
+	ShutdownDelay time.Duration `env:"SHUTDOWN_DELAY" env-default:"0s"`
+	// HealthCheckTimeout bounds each dependency check of the readiness probe
+	HealthCheckTimeout time.Duration `env:"HEALTH_CHECK_TIMEOUT" env-default:"2s"`
+	// TrustedProxies are the comma separated IPs or CIDRs of the proxies whose X-Forwarded-For header is trusted,
+	// none by default so clients are identified by the address connecting to the server and can't spoof it
+	TrustedProxies string `env:"HTTP_TRUSTED_PROXIES" env-default:""`
+}
+
+// TrustedProxyList returns the trusted proxies, nil when there are none
+func (cfg Config) TrustedProxyList() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(cfg.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return proxies
 }
 
 func NewServer(cfg Config, router *gin.Engine) *http.Server {
 	return &http.Server{
 		Addr:         cfg.HTTPPort,
-		Handler:      http.TimeoutHandler(router, cfg.HandlerTimeout, ""),
+		Handler:      withTimeout(router, cfg.HandlerTimeout, streamRoutes),
 		ErrorLog:     stdLogger.New(os.Stderr, "http: ", stdLogger.LstdFlags),
 		ReadTimeout:  cfg.ReadTimeout,
 		WriteTimeout: cfg.WriteTimeout,
 		IdleTimeout:  cfg.IdleTimeout,
 	}
 }
+
+// withTimeout limits the time handlers take to respond, except for the stream routes
+// which are long lived and need every write flushed instead of buffered
+// Streams are recognised by route and not by what the client accepts, so any other route stays bounded
+func withTimeout(router *gin.Engine, timeout time.Duration, streams map[string]struct{}) http.Handler {
+	timeoutHandler := http.TimeoutHandler(router, timeout, "")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, found := streams[Route(r.Method, r.URL.Path)]; found {
+			router.ServeHTTP(w, r)
+			return
+		}
+		timeoutHandler.ServeHTTP(w, r)
+	})
+}
@@ -2,6 +2,7 @@ package http_test
 
 import (
 	"github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	stdHTTP "net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
@@ -30,3 +31,22 @@ func TestNewServer(t *testing.T) {
 	assert.Equal(t, 3*time.Second, server.WriteTimeout)
 	assert.Equal(t, 15*time.Second, server.IdleTimeout)
 }
+
+// asking for an event stream doesn't lift the timeout of the other routes
+func TestNewServerTimesOutHandlersAcceptingEventStreams(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	engine := gin.New()
+	engine.GET("/api/actions/referral", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+
+	server := http.NewServer(http.Config{HandlerTimeout: 20 * time.Millisecond}, engine)
+
+	r := httptest.NewRequest(stdHTTP.MethodGet, "/api/actions/referral", nil)
+	r.Header.Set("Accept", http.EventStream)
+	w := httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, r)
+
+	assert.Equal(t, stdHTTP.StatusServiceUnavailable, w.Code)
+}
@@ -0,0 +1,501 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const schemaRefPrefix = "#/components/schemas/"
+
+var openAPIParameter = regexp.MustCompile(`\{([^}]+)\}`)
+
+// RequestValidator validates the parameters and the body of the requests against the operations of an OpenAPI 3.1 document
+// The schema keywords supported are the ones the document of the service uses: $ref, allOf, type, enum, format
+// (date-time and uri), properties, required, additionalProperties, propertyNames, items, minItems, minLength,
+// maxLength, pattern, minimum and maximum. Documents using any other keyword are rejected, so a schema is never
+// enforced only in part
+type RequestValidator struct {
+	operations map[string]operation
+	schemas    map[string]*schema
+}
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components struct {
+		Schemas    map[string]*schema   `json:"schemas"`
+		Parameters map[string]parameter `json:"parameters"`
+	} `json:"components"`
+}
+
+type operation struct {
+	Parameters  []parameter  `json:"parameters"`
+	RequestBody *requestBody `json:"requestBody"`
+}
+
+type parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool `json:"required"`
+	Content  map[string]struct {
+		Schema *schema `json:"schema"`
+	} `json:"content"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	AllOf                []*schema          `json:"allOf"`
+	Type                 string             `json:"type"`
+	Enum                 []any              `json:"enum"`
+	Format               string             `json:"format"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *schema            `json:"additionalProperties"`
+	PropertyNames        *schema            `json:"propertyNames"`
+	Items                *schema            `json:"items"`
+	MinItems             *int               `json:"minItems"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+
+	pattern *regexp.Regexp
+	// unsupported are the keywords of the schema the validator can't enforce
+	unsupported []string
+}
+
+// schemaKeywords are the keywords validated
+var schemaKeywords = map[string]struct{}{
+	"$ref": {}, "allOf": {}, "type": {}, "enum": {}, "format": {}, "properties": {}, "required": {},
+	"additionalProperties": {}, "propertyNames": {}, "items": {}, "minItems": {}, "minLength": {}, "maxLength": {},
+	"pattern": {}, "minimum": {}, "maximum": {},
+}
+
+// annotationKeywords describe the values without constraining them
+var annotationKeywords = map[string]struct{}{
+	"title": {}, "description": {}, "examples": {}, "example": {}, "default": {}, "deprecated": {},
+	"readOnly": {}, "writeOnly": {}, "$comment": {},
+}
+
+// UnmarshalJSON decodes the keywords supported, recording the others so compile rejects the schema
+// Type arrays and boolean additionalProperties are recorded as unsupported too, as the fields hold one type and a schema
+func (s *schema) UnmarshalJSON(data []byte) error {
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keywords); err != nil {
+		return fmt.Errorf("schemas must be objects: %v", err)
+	}
+
+	supported := make(map[string]json.RawMessage, len(keywords))
+	var unsupported []string
+	for keyword, value := range keywords {
+		_, validated := schemaKeywords[keyword]
+		_, annotation := annotationKeywords[keyword]
+		trimmed := bytes.TrimSpace(value)
+
+		switch {
+		case keyword == "type" && bytes.HasPrefix(trimmed, []byte("[")):
+			unsupported = append(unsupported, "type arrays")
+		case keyword == "additionalProperties" && (bytes.Equal(trimmed, []byte("true")) || bytes.Equal(trimmed, []byte("false"))):
+			unsupported = append(unsupported, "boolean additionalProperties")
+		case validated:
+			supported[keyword] = value
+		case !annotation && !strings.HasPrefix(keyword, "x-"):
+			unsupported = append(unsupported, keyword)
+		}
+	}
+
+	// the keywords supported are decoded into the fields, without calling this method again
+	type fields schema
+	data, err := json.Marshal(supported)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, (*fields)(s)); err != nil {
+		return err
+	}
+
+	slices.Sort(unsupported)
+	s.unsupported = unsupported
+	return nil
+}
+
+// NewRequestValidator compiles the operations of the document, failing when a reference or a pattern is invalid
+func NewRequestValidator(spec []byte) (*RequestValidator, error) {
+	var document openAPIDocument
+	if err := json.Unmarshal(spec, &document); err != nil {
+		return nil, fmt.Errorf("error reading OpenAPI document: %v", err)
+	}
+
+	v := &RequestValidator{operations: map[string]operation{}, schemas: document.Components.Schemas}
+	for name, s := range document.Components.Schemas {
+		if err := v.compile(s); err != nil {
+			return nil, fmt.Errorf("schema %s: %v", name, err)
+		}
+	}
+
+	for path, operations := range document.Paths {
+		for method, op := range operations {
+			for i, p := range op.Parameters {
+				if p.Ref != "" {
+					resolved, found := document.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+					if !found {
+						return nil, fmt.Errorf("%s %s: %s does not exist", method, path, p.Ref)
+					}
+					op.Parameters[i] = resolved
+				}
+				if err := v.compile(op.Parameters[i].Schema); err != nil {
+					return nil, fmt.Errorf("%s %s: parameter %s: %v", method, path, op.Parameters[i].Name, err)
+				}
+			}
+			if op.RequestBody != nil {
+				for mediaType, content := range op.RequestBody.Content {
+					if err := v.compile(content.Schema); err != nil {
+						return nil, fmt.Errorf("%s %s: body %s: %v", method, path, mediaType, err)
+					}
+				}
+			}
+
+			// gin declares the parameters of the path as :id instead of {id}
+			route := Route(strings.ToUpper(method), openAPIParameter.ReplaceAllString(path, ":$1"))
+			v.operations[route] = op
+		}
+	}
+
+	return v, nil
+}
+
+// compile checks the schema only uses the keywords, types and formats supported and its references exist,
+// and compiles its patterns
+func (v *RequestValidator) compile(s *schema) error {
+	if s == nil {
+		return nil
+	}
+	if len(s.unsupported) > 0 {
+		return fmt.Errorf("unsupported keywords %s", strings.Join(s.unsupported, ", "))
+	}
+	if s.Type != "" && !slices.Contains(schemaTypes, s.Type) {
+		return fmt.Errorf("unsupported type %s", s.Type)
+	}
+	if s.Format != "" && !slices.Contains(schemaFormats, s.Format) {
+		return fmt.Errorf("unsupported format %s", s.Format)
+	}
+	if s.Ref != "" {
+		if _, found := v.schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]; !found {
+			return fmt.Errorf("%s does not exist", s.Ref)
+		}
+	}
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+		s.pattern = pattern
+	}
+
+	children := append([]*schema{s.AdditionalProperties, s.PropertyNames, s.Items}, s.AllOf...)
+	for _, property := range s.Properties {
+		children = append(children, property)
+	}
+	for _, child := range children {
+		if err := v.compile(child); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Validate rejects with 400 the requests whose parameters or body don't match the operation of the route,
+// before the handler runs. Routes missing from the document are not validated
+func (v *RequestValidator) Validate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op, found := v.operations[Route(c.Request.Method, c.FullPath())]
+		if !found {
+			c.Next()
+			return
+		}
+
+		violations := v.validateParameters(c, op.Parameters)
+
+		if op.RequestBody != nil {
+			bodyViolations, err := v.validateBody(c, op.RequestBody)
+			if err != nil {
+				bodyReadError(c, err)
+				return
+			}
+			violations = append(violations, bodyViolations...)
+		}
+
+		if len(violations) > 0 {
+			problemResponseJson(c, http.StatusBadRequest, strings.Join(violations, "; "))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func (v *RequestValidator) validateParameters(c *gin.Context, parameters []parameter) []string {
+	var violations []string
+	for _, p := range parameters {
+		var value string
+		var present bool
+		switch p.In {
+		case "path":
+			value = c.Param(p.Name)
+			present = value != ""
+		case "query":
+			value, present = c.GetQuery(p.Name)
+		case "header":
+			value = c.GetHeader(p.Name)
+			present = value != ""
+		default:
+			continue
+		}
+
+		location := fmt.Sprintf("%s parameter %q", p.In, p.Name)
+		if !present {
+			if p.Required {
+				violations = append(violations, location+" is required")
+			}
+			continue
+		}
+
+		parsed, ok := v.parseParameter(p.Schema, value)
+		if !ok {
+			violations = append(violations, fmt.Sprintf("%s must be of type %s", location, v.resolve(p.Schema).Type))
+			continue
+		}
+		violations = append(violations, v.validate(p.Schema, parsed, location)...)
+	}
+
+	return violations
+}
+
+// parseParameter converts the value of a parameter into the type of its schema, false when it can't
+func (v *RequestValidator) parseParameter(s *schema, value string) (any, bool) {
+	switch v.resolve(s).Type {
+	case "integer", "number":
+		// the JSON grammar rejects the NaN and Inf ParseFloat accepts
+		var n float64
+		if err := json.Unmarshal([]byte(value), &n); err != nil {
+			return nil, false
+		}
+		return json.Number(value), true
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		return b, err == nil
+	default:
+		return value, true
+	}
+}
+
+// validateBody validates the JSON body of the request, which is restored for the handler
+func (v *RequestValidator) validateBody(c *gin.Context, body *requestBody) ([]string, error) {
+	content, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(content))
+
+	if len(bytes.TrimSpace(content)) == 0 {
+		if body.Required {
+			return []string{ErrEmptyBody.Error()}, nil
+		}
+		return nil, nil
+	}
+
+	media, found := body.Content[c.ContentType()]
+	if !found {
+		// the content type is negotiated before
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return []string{"request body is not valid JSON"}, nil
+	}
+
+	return v.validate(media.Schema, document, "body"), nil
+}
+
+// resolve follows the reference of the schema
+func (v *RequestValidator) resolve(s *schema) *schema {
+	for s != nil && s.Ref != "" {
+		s = v.schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]
+	}
+	if s == nil {
+		return &schema{}
+	}
+	return s
+}
+
+// validate returns the violations of the schema by the value, decoded from JSON with numbers as json.Number
+func (v *RequestValidator) validate(s *schema, value any, location string) []string {
+	s = v.resolve(s)
+
+	var violations []string
+	for _, part := range s.AllOf {
+		violations = append(violations, v.validate(part, value, location)...)
+	}
+
+	if s.Type != "" && !hasType(value, s.Type) {
+		return append(violations, fmt.Sprintf("%s must be of type %s", location, s.Type))
+	}
+
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(allowed any) bool { return fmt.Sprint(allowed) == fmt.Sprint(value) }) {
+		allowed := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			allowed[i] = fmt.Sprint(e)
+		}
+		violations = append(violations, fmt.Sprintf("%s must be one of %s", location, strings.Join(allowed, ", ")))
+	}
+
+	switch value := value.(type) {
+	case string:
+		violations = append(violations, validateString(s, value, location)...)
+	case json.Number:
+		violations = append(violations, validateNumber(s, value, location)...)
+	case []any:
+		if s.MinItems != nil && len(value) < *s.MinItems {
+			violations = append(violations, fmt.Sprintf("%s must have at least %d items", location, *s.MinItems))
+		}
+		if s.Items != nil {
+			for i, item := range value {
+				violations = append(violations, v.validate(s.Items, item, fmt.Sprintf("%s[%d]", location, i))...)
+			}
+		}
+	case map[string]any:
+		violations = append(violations, v.validateObject(s, value, location)...)
+	}
+
+	return violations
+}
+
+func (v *RequestValidator) validateObject(s *schema, value map[string]any, location string) []string {
+	var violations []string
+	for _, name := range s.Required {
+		if _, found := value[name]; !found {
+			violations = append(violations, fmt.Sprintf("%s.%s is required", location, name))
+		}
+	}
+
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if s.PropertyNames != nil {
+			violations = append(violations, v.validate(s.PropertyNames, name, fmt.Sprintf("%s property name %q", location, name))...)
+		}
+		if property, found := s.Properties[name]; found {
+			violations = append(violations, v.validate(property, value[name], location+"."+name)...)
+		} else if s.AdditionalProperties != nil {
+			violations = append(violations, v.validate(s.AdditionalProperties, value[name], location+"."+name)...)
+		}
+	}
+
+	return violations
+}
+
+func validateString(s *schema, value string, location string) []string {
+	var violations []string
+	length := len([]rune(value))
+	if s.MinLength != nil && length < *s.MinLength {
+		violations = append(violations, fmt.Sprintf("%s must be at least %d characters", location, *s.MinLength))
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		violations = append(violations, fmt.Sprintf("%s must be at most %d characters", location, *s.MaxLength))
+	}
+	if s.pattern != nil && !s.pattern.MatchString(value) {
+		violations = append(violations, fmt.Sprintf("%s must match %s", location, s.Pattern))
+	}
+
+	switch s.Format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			violations = append(violations, fmt.Sprintf("%s must be an RFC 3339 date-time", location))
+		}
+	case "uri":
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" {
+			violations = append(violations, fmt.Sprintf("%s must be an absolute URI", location))
+		}
+	}
+
+	return violations
+}
+
+func validateNumber(s *schema, value json.Number, location string) []string {
+	n, err := value.Float64()
+	if err != nil {
+		return []string{fmt.Sprintf("%s must be a number", location)}
+	}
+
+	var violations []string
+	if s.Minimum != nil && n < *s.Minimum {
+		violations = append(violations, fmt.Sprintf("%s must be at least %v", location, *s.Minimum))
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		violations = append(violations, fmt.Sprintf("%s must be at most %v", location, *s.Maximum))
+	}
+
+	return violations
+}
+
+var (
+	schemaTypes   = []string{"object", "array", "string", "boolean", "null", "number", "integer"}
+	schemaFormats = []string{"date-time", "uri"}
+)
+
+// hasType reports whether the value decoded from JSON is of the JSON schema type
+func hasType(value any, schemaType string) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := strconv.ParseInt(string(n), 10, 64)
+		return err == nil
+	default:
+		return true
+	}
+}
@@ -0,0 +1,210 @@
+package http_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	appHTTP "github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const validationSpec = `{
+  "openapi": "3.1.0",
+  "paths": {
+    "/api/items/{id}": {
+      "get": {
+        "parameters": [
+          {"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "minimum": 0}},
+          {"name": "kind", "in": "query", "schema": {"$ref": "#/components/schemas/Kind"}},
+          {"$ref": "#/components/parameters/Trace"}
+        ]
+      }
+    },
+    "/api/items": {
+      "post": {
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/CreateItem"}}}
+        }
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "Trace": {"name": "X-Trace", "in": "header", "schema": {"type": "string", "maxLength": 4}}
+    },
+    "schemas": {
+      "Kind": {"type": "string", "enum": ["BOOK", "FILM"]},
+      "CreateItem": {
+        "type": "object",
+        "required": ["kind", "count"],
+        "properties": {
+          "kind": {"$ref": "#/components/schemas/Kind"},
+          "count": {"type": "integer", "minimum": 1},
+          "url": {"type": "string", "format": "uri"},
+          "createdAt": {"type": "string", "format": "date-time"},
+          "tags": {"type": "array", "minItems": 1, "items": {"type": "string", "pattern": "^[a-z]+$"}}
+        }
+      }
+    }
+  }
+}`
+
+func newValidatedRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	validator, err := appHTTP.NewRequestValidator([]byte(validationSpec))
+	require.NoError(t, err)
+
+	router := gin.New()
+	group := router.Group("api/items")
+	group.Use(validator.Validate())
+
+	group.GET(":id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	group.POST("", func(c *gin.Context) {
+		// the body is still readable by the handler
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Data(http.StatusCreated, "application/json", body)
+	})
+	group.DELETE(":id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	return router
+}
+
+func TestRequestValidatorParameters(t *testing.T) {
+	router := newValidatedRouter(t)
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		detail string
+	}{
+		{name: "valid", path: "/api/items/1?kind=BOOK", header: "abc"},
+		{name: "not an integer", path: "/api/items/one", detail: `path parameter "id" must be of type integer`},
+		{name: "not a whole number", path: "/api/items/1.5", detail: `path parameter "id" must be of type integer`},
+		{name: "not a number", path: "/api/items/NaN", detail: `path parameter "id" must be of type integer`},
+		{name: "below minimum", path: "/api/items/-1", detail: `path parameter "id" must be at least 0`},
+		{name: "not in enum", path: "/api/items/1?kind=SONG", detail: `query parameter "kind" must be one of BOOK, FILM`},
+		{name: "too long", path: "/api/items/1", header: "abcde", detail: `header parameter "X-Trace" must be at most 4 characters`},
+		{
+			name:   "every violation",
+			path:   "/api/items/-1?kind=SONG",
+			detail: `path parameter "id" must be at least 0; query parameter "kind" must be one of BOOK, FILM`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				r.Header.Set("X-Trace", tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if tt.detail == "" {
+				assert.Equal(t, http.StatusOK, w.Code)
+				return
+			}
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+
+			var problem map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+			assert.Equal(t, tt.detail, problem["detail"])
+			assert.Equal(t, "Bad Request", problem["title"])
+		})
+	}
+}
+
+func TestRequestValidatorBody(t *testing.T) {
+	router := newValidatedRouter(t)
+
+	tests := []struct {
+		name   string
+		body   string
+		detail string
+	}{
+		{name: "valid", body: `{"kind": "FILM", "count": 2, "url": "https://example.com", "createdAt": "2024-01-02T03:04:05Z", "tags": ["new"]}`},
+		{name: "empty", body: ``, detail: "missing request body"},
+		{name: "not JSON", body: `{"kind":`, detail: "request body is not valid JSON"},
+		{name: "not an object", body: `[]`, detail: "body must be of type object"},
+		{name: "missing properties", body: `{}`, detail: "body.kind is required; body.count is required"},
+		{name: "wrong type", body: `{"kind": "FILM", "count": "2"}`, detail: "body.count must be of type integer"},
+		{name: "not in enum", body: `{"kind": "SONG", "count": 2}`, detail: "body.kind must be one of BOOK, FILM"},
+		{name: "below minimum", body: `{"kind": "FILM", "count": 0}`, detail: "body.count must be at least 1"},
+		{name: "relative uri", body: `{"kind": "FILM", "count": 1, "url": "/hook"}`, detail: "body.url must be an absolute URI"},
+		{name: "date-time", body: `{"kind": "FILM", "count": 1, "createdAt": "yesterday"}`, detail: "body.createdAt must be an RFC 3339 date-time"},
+		{name: "no items", body: `{"kind": "FILM", "count": 1, "tags": []}`, detail: "body.tags must have at least 1 items"},
+		{name: "item pattern", body: `{"kind": "FILM", "count": 1, "tags": ["ok", "NO"]}`, detail: "body.tags[1] must match ^[a-z]+$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/items", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if tt.detail == "" {
+				assert.Equal(t, http.StatusCreated, w.Code)
+				assert.JSONEq(t, tt.body, w.Body.String())
+				return
+			}
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+
+			var problem map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+			assert.Equal(t, tt.detail, problem["detail"])
+		})
+	}
+}
+
+// Routes the document doesn't declare are not validated
+func TestRequestValidatorUndeclaredRoute(t *testing.T) {
+	router := newValidatedRouter(t)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/items/one", nil))
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestNewRequestValidatorInvalidDocument(t *testing.T) {
+	_, err := appHTTP.NewRequestValidator([]byte(`{"components": {"schemas": {"A": {"$ref": "#/components/schemas/B"}}}}`))
+	assert.ErrorContains(t, err, "#/components/schemas/B does not exist")
+
+	_, err = appHTTP.NewRequestValidator([]byte(`{"components": {"schemas": {"A": {"pattern": "("}}}}`))
+	assert.Error(t, err)
+
+	_, err = appHTTP.NewRequestValidator([]byte(`{"paths": {"/a": {"get": {"parameters": [{"$ref": "#/components/parameters/Missing"}]}}}}`))
+	assert.ErrorContains(t, err, "#/components/parameters/Missing does not exist")
+
+	// keywords, types and formats not validated fail instead of being ignored
+	for document, message := range map[string]string{
+		`{"components": {"schemas": {"A": {"oneOf": [{"type": "string"}], "anyOf": []}}}}`:                   "schema A: unsupported keywords anyOf, oneOf",
+		`{"components": {"schemas": {"A": {"properties": {"b": {"const": 1}}}}}}`:                            "schema A: unsupported keywords const",
+		`{"components": {"schemas": {"A": {"items": {"not": {"type": "string"}}}}}}`:                         "schema A: unsupported keywords not",
+		`{"components": {"schemas": {"A": {"type": ["string", "null"]}}}}`:                                   "schema A: unsupported keywords type arrays",
+		`{"components": {"schemas": {"A": {"additionalProperties": false}}}}`:                                "schema A: unsupported keywords boolean additionalProperties",
+		`{"components": {"schemas": {"A": {"type": "int"}}}}`:                                                "schema A: unsupported type int",
+		`{"components": {"schemas": {"A": {"type": "string", "format": "email"}}}}`:                          "schema A: unsupported format email",
+		`{"paths": {"/a": {"get": {"parameters": [{"name": "q", "in": "query", "schema": {"const": 1}}]}}}}`: "get /a: parameter q: unsupported keywords const",
+	} {
+		_, err = appHTTP.NewRequestValidator([]byte(document))
+		assert.EqualError(t, err, message, document)
+	}
+
+	// annotations and extensions don't constrain the values
+	_, err = appHTTP.NewRequestValidator([]byte(`{"components": {"schemas": {"A": {"title": "A", "description": "a", "examples": [1], "x-internal": true}}}}`))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VersionKey is the context key of the version negotiated by Produce
+const VersionKey = "api_version"
+
+// VersionConfig are the configurations related to the lifecycle of the API versions
+type VersionConfig struct {
+	// V1DeprecatedAt is when the v1 media type was deprecated in favour of v2
+	V1DeprecatedAt time.Time `env:"API_V1_DEPRECATED_AT" env-default:"2026-11-01T00:00:00Z"`
+	// V1SunsetAt is when the v1 media type stops being served
+	V1SunsetAt time.Time `env:"API_V1_SUNSET_AT" env-default:"2027-05-01T00:00:00Z" validate:"gtfield=V1DeprecatedAt"`
+}
+
+// NegotiatedVersion returns the version negotiated for the response, V1 when the route doesn't negotiate it
+func NegotiatedVersion(c *gin.Context) Version {
+	if version := c.GetString(VersionKey); version != "" {
+		return version
+	}
+	return V1
+}
+
+// ResponseMappers maps the result of a handler into the response of each version
+// Mappers get the context of the request to read what else the response of their version needs
+type ResponseMappers[T any] map[Version]func(c *gin.Context, result T) (any, error)
+
+// Map returns the response of the negotiated version, the result itself for versions without a mapper
+func (m ResponseMappers[T]) Map(c *gin.Context, result T) (any, error) {
+	if mapper, found := m[NegotiatedVersion(c)]; found {
+		return mapper(c, result)
+	}
+	return result, nil
+}
+
+// Deprecate announces on the responses negotiated in the version that it is deprecated since deprecatedAt,
+// with the Deprecation header (RFC 9745), and that it is removed at sunsetAt, with the Sunset header (RFC 8594)
+// It has to run after Produce
+func Deprecate(version Version, deprecatedAt, sunsetAt time.Time) gin.HandlerFunc {
+	deprecation := "@" + strconv.FormatInt(deprecatedAt.Unix(), 10)
+	sunset := sunsetAt.UTC().Format(http.TimeFormat)
+
+	return func(c *gin.Context) {
+		if c.GetString(VersionKey) == version {
+			c.Header("Deprecation", deprecation)
+			c.Header("Sunset", sunset)
+		}
+		c.Next()
+	}
+}
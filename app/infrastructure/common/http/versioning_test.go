@@ -0,0 +1,113 @@
+package http_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	appHTTP "github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newVersionedRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	deprecatedAt := time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)
+	sunsetAt := time.Date(2027, time.May, 1, 0, 0, 0, 0, time.UTC)
+	responses := appHTTP.ResponseMappers[int]{
+		appHTTP.V2: func(_ *gin.Context, result int) (any, error) {
+			return gin.H{"count": result}, nil
+		},
+	}
+
+	router := gin.New()
+	router.Use(
+		appHTTP.Consume(appHTTP.V1, appHTTP.V2),
+		appHTTP.Produce(appHTTP.V1, appHTTP.V2),
+		appHTTP.Deprecate(appHTTP.V1, deprecatedAt, sunsetAt),
+	)
+	handler := func(c *gin.Context) {
+		response, _ := responses.Map(c, 3)
+		c.JSON(http.StatusOK, response)
+	}
+	router.GET("/count", handler)
+	router.POST("/count", handler)
+
+	return router
+}
+
+func TestVersionNegotiation(t *testing.T) {
+	router := newVersionedRouter()
+
+	tests := []struct {
+		name        string
+		accept      string
+		status      int
+		contentType string
+		body        string
+		deprecated  bool
+	}{
+		{name: "any", accept: "*/*", status: http.StatusOK, contentType: appHTTP.V1, body: `3`, deprecated: true},
+		{name: "none", accept: "", status: http.StatusOK, contentType: appHTTP.V1, body: `3`, deprecated: true},
+		{name: "v1", accept: appHTTP.V1, status: http.StatusOK, contentType: appHTTP.V1, body: `3`, deprecated: true},
+		{name: "v2", accept: appHTTP.V2, status: http.StatusOK, contentType: appHTTP.V2, body: `{"count": 3}`},
+		{name: "v2 preferred", accept: appHTTP.V2 + ", " + appHTTP.V1, status: http.StatusOK, contentType: appHTTP.V2, body: `{"count": 3}`},
+		{
+			name:   "unknown",
+			accept: "application/vnd.surfe.v3+json",
+			status: http.StatusNotAcceptable,
+			body: `{"error": "Unable to produce response of type [\"application/vnd.surfe.v3+json\"]; ` +
+				`expected client to accept \"application/vnd.surfe.v1+json\" or \"application/vnd.surfe.v2+json\""}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/count", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.JSONEq(t, tt.body, w.Body.String())
+			if tt.contentType != "" {
+				assert.Equal(t, tt.contentType+"; charset=utf-8", w.Header().Get("Content-Type"))
+			}
+
+			if tt.deprecated {
+				assert.Equal(t, "@1793491200", w.Header().Get("Deprecation"))
+				assert.Equal(t, "Sat, 01 May 2027 00:00:00 GMT", w.Header().Get("Sunset"))
+			} else {
+				assert.Empty(t, w.Header().Get("Deprecation"))
+				assert.Empty(t, w.Header().Get("Sunset"))
+			}
+		})
+	}
+}
+
+func TestConsumeVersions(t *testing.T) {
+	router := newVersionedRouter()
+
+	for _, version := range []string{appHTTP.V1, appHTTP.V2} {
+		r := httptest.NewRequest(http.MethodPost, "/count", strings.NewReader(`{}`))
+		r.Header.Set("Content-Type", version)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code, version)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/count", strings.NewReader(`{}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.JSONEq(t, `{"error": "Unsupported content type \"application/json\"; `+
+		`expected client to send \"application/vnd.surfe.v1+json\" or \"application/vnd.surfe.v2+json\""}`, w.Body.String())
+}
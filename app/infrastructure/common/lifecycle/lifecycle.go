@@ -0,0 +1,148 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Hook is a component started and stopped with the service, both functions are optional
+type Hook struct {
+	Name    string
+	OnStart func(ctx context.Context) error
+	OnStop  func(ctx context.Context) error
+}
+
+// Lifecycle starts the hooks in the order they were appended and stops them in reverse order,
+// so a component is stopped before the ones it depends on
+type Lifecycle struct {
+	mutex   sync.Mutex
+	hooks   []Hook
+	started int
+	logger  slog.Logger
+
+	failed   chan struct{}
+	failOnce sync.Once
+}
+
+// errUnexpectedReturn is the failure of a worker returning without error before it is stopped
+var errUnexpectedReturn = errors.New("returned before it was stopped")
+
+// New creates a lifecycle without hooks
+func New(logger slog.Logger) *Lifecycle {
+	return &Lifecycle{logger: logger, failed: make(chan struct{})}
+}
+
+// Append registers a hook, hooks appended after Start are not started
+func (l *Lifecycle) Append(hook Hook) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.hooks = append(l.hooks, hook)
+}
+
+// Start starts every hook in order, when one fails the hooks already started are stopped
+func (l *Lifecycle) Start(ctx context.Context) error {
+	l.mutex.Lock()
+	hooks := l.hooks[l.started:]
+	l.mutex.Unlock()
+
+	for _, hook := range hooks {
+		if hook.OnStart != nil {
+			if err := hook.OnStart(ctx); err != nil {
+				err = fmt.Errorf("starting %s: %w", hook.Name, err)
+				return errors.Join(err, l.Stop(ctx))
+			}
+		}
+
+		l.mutex.Lock()
+		l.started++
+		l.mutex.Unlock()
+		l.logger.Debug("started", "component", hook.Name)
+	}
+
+	return nil
+}
+
+// Stop stops the started hooks in reverse order, every hook is stopped even when one fails
+// The context bounds the whole shutdown
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mutex.Lock()
+	hooks := l.hooks[:l.started]
+	l.started = 0
+	l.mutex.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hook := hooks[i]
+		if hook.OnStop == nil {
+			continue
+		}
+
+		if err := hook.OnStop(ctx); err != nil {
+			l.logger.Error("error stopping", "component", hook.Name, "error", err.Error())
+			errs = append(errs, fmt.Errorf("stopping %s: %w", hook.Name, err))
+			continue
+		}
+		l.logger.Debug("stopped", "component", hook.Name)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Worker returns a hook running fn in a goroutine until it is stopped
+// Stopping cancels the context of fn and waits for it to return, or for the stop context to be done
+// fn returning before it is stopped is a failure: it is logged right away and Failed is closed,
+// so the service shuts down instead of running without the worker
+func (l *Lifecycle) Worker(name string, fn func(ctx context.Context) error) Hook {
+	var (
+		cancel context.CancelFunc
+		done   chan error
+	)
+
+	return Hook{
+		Name: name,
+		OnStart: func(context.Context) error {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			done = make(chan error, 1)
+
+			go func() {
+				err := fn(ctx)
+				if ctx.Err() == nil {
+					if err == nil {
+						err = errUnexpectedReturn
+					}
+					l.logger.Error("worker stopped unexpectedly", "component", name, "error", err.Error())
+					l.fail()
+				}
+				done <- err
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			cancel()
+
+			select {
+			case err := <-done:
+				if errors.Is(err, context.Canceled) {
+					return nil
+				}
+				return err
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+// Failed is closed once a worker returns before it is stopped
+func (l *Lifecycle) Failed() <-chan struct{} {
+	return l.failed
+}
+
+func (l *Lifecycle) fail() {
+	l.failOnce.Do(func() { close(l.failed) })
+}
@@ -0,0 +1,114 @@
+package lifecycle_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/lifecycle"
+	"github.com/JoseBeteta/surfe/test/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func recorder(events *[]string, name string, startErr error) lifecycle.Hook {
+	return lifecycle.Hook{
+		Name: name,
+		OnStart: func(context.Context) error {
+			*events = append(*events, "start "+name)
+			return startErr
+		},
+		OnStop: func(context.Context) error {
+			*events = append(*events, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestLifecycleOrder(t *testing.T) {
+	var events []string
+	lc := lifecycle.New(mocks.NewNullLogger())
+	lc.Append(recorder(&events, "storage", nil))
+	lc.Append(recorder(&events, "worker", nil))
+	lc.Append(recorder(&events, "server", nil))
+
+	require.NoError(t, lc.Start(context.Background()))
+	require.NoError(t, lc.Stop(context.Background()))
+
+	assert.Equal(t, []string{
+		"start storage", "start worker", "start server",
+		"stop server", "stop worker", "stop storage",
+	}, events)
+
+	// stopping again is a no-op
+	require.NoError(t, lc.Stop(context.Background()))
+	assert.Len(t, events, 6)
+}
+
+func TestLifecycleStartFailure(t *testing.T) {
+	var events []string
+	lc := lifecycle.New(mocks.NewNullLogger())
+	lc.Append(recorder(&events, "storage", nil))
+	lc.Append(recorder(&events, "server", errors.New("address in use")))
+	lc.Append(recorder(&events, "never", nil))
+
+	err := lc.Start(context.Background())
+	assert.ErrorContains(t, err, "starting server: address in use")
+
+	// only the hooks started are stopped
+	assert.Equal(t, []string{"start storage", "start server", "stop storage"}, events)
+}
+
+func TestWorker(t *testing.T) {
+	stopped := make(chan struct{})
+	lc := lifecycle.New(mocks.NewNullLogger())
+	lc.Append(lc.Worker("worker", func(ctx context.Context) error {
+		<-ctx.Done()
+		close(stopped)
+		return ctx.Err()
+	}))
+
+	require.NoError(t, lc.Start(context.Background()))
+	require.NoError(t, lc.Stop(context.Background()))
+	<-stopped
+}
+
+func TestWorkerStopTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	lc := lifecycle.New(mocks.NewNullLogger())
+	lc.Append(lc.Worker("stuck", func(context.Context) error {
+		<-release
+		return nil
+	}))
+	require.NoError(t, lc.Start(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, lc.Stop(ctx), context.DeadlineExceeded)
+}
+
+func TestWorkerFailure(t *testing.T) {
+	logs := &bytes.Buffer{}
+	lc := lifecycle.New(*slog.New(slog.NewTextHandler(logs, nil)))
+	lc.Append(lc.Worker("consumer", func(context.Context) error {
+		return errors.New("broker connection closed")
+	}))
+	require.NoError(t, lc.Start(context.Background()))
+
+	// the failure is reported before the lifecycle is stopped
+	select {
+	case <-lc.Failed():
+	case <-time.After(time.Second):
+		t.Fatal("the worker failure was not reported")
+	}
+	assert.Contains(t, logs.String(), "worker stopped unexpectedly")
+	assert.Contains(t, logs.String(), "component=consumer")
+	assert.Contains(t, logs.String(), `error="broker connection closed"`)
+
+	assert.ErrorContains(t, lc.Stop(context.Background()), "stopping consumer: broker connection closed")
+}
@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// FormatJSON writes one JSON object per log line
+	FormatJSON = "json"
+	// FormatText writes key=value pairs, easier to read locally
+	FormatText = "text"
+)
+
+// Config are the configurations related to logging
+type Config struct {
+	Format      string     `env:"LOG_FORMAT" env-default:"json" validate:"oneof=json text"`
+	Level       slog.Level `env:"LOG_LEVEL" env-default:"info"`
+	Environment string     `env:"APP_ENV" env-default:"development"`
+}
+
+// New creates a logger writing to w in the configured format, the records of a request are tagged with its ID
+func New(cfg Config, w io.Writer) *slog.Logger {
+	return NewWithLevel(cfg, cfg.Level, w)
+}
+
+// NewWithLevel creates a logger writing the records of level and above, a *slog.LevelVar allows you
+// to change it while running
+func NewWithLevel(cfg Config, level slog.Leveler, w io.Writer) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if cfg.Format == FormatText {
+		handler = slog.NewTextHandler(w, options)
+	} else {
+		handler = slog.NewJSONHandler(w, options)
+	}
+
+	return slog.New(NewContextHandler(handler))
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the ID of the request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request of the context, empty when there is none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ContextHandler adds the request ID and the trace of the context to the records logged with one
+type ContextHandler struct {
+	slog.Handler
+}
+
+// NewContextHandler wraps handler
+func NewContextHandler(handler slog.Handler) *ContextHandler {
+	return &ContextHandler{Handler: handler}
+}
+
+// Handle adds the request_id, trace_id and span_id attributes when the context has them
+func (h *ContextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", span.TraceID().String()),
+			slog.String("span_id", span.SpanID().String()),
+		)
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs returns a handler adding attrs to every record, keeping the context attributes
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup returns a handler nesting the attributes of every record in the group
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,62 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestLoggerTagsRecordsWithContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(logging.Config{Format: logging.FormatJSON, Level: slog.LevelInfo}, &buf).
+		With("service", "surfe")
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(
+		logging.WithRequestID(context.Background(), "abc-123"),
+		trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}),
+	)
+
+	logger.InfoContext(ctx, "user not found", "id", 1)
+	// below the level
+	logger.DebugContext(ctx, "ignored")
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "user not found", record["msg"])
+	assert.Equal(t, "surfe", record["service"])
+	assert.Equal(t, "abc-123", record["request_id"])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", record["trace_id"])
+	assert.Equal(t, "00f067aa0ba902b7", record["span_id"])
+}
+
+func TestLoggerWithoutContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(logging.Config{Format: logging.FormatText}, &buf)
+
+	logger.Info("server started")
+
+	assert.Contains(t, buf.String(), `msg="server started"`)
+	assert.NotContains(t, buf.String(), "request_id")
+}
+
+func TestLoggerLevelChanges(t *testing.T) {
+	var buf bytes.Buffer
+	level := new(slog.LevelVar)
+	logger := logging.NewWithLevel(logging.Config{Format: logging.FormatText}, level, &buf)
+
+	logger.Debug("hidden")
+	level.Set(slog.LevelDebug)
+	logger.Debug("shown")
+
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "shown")
+}
@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType is the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds in seconds of the duration histograms
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type kind string
+
+const (
+	counterKind   kind = "counter"
+	gaugeKind     kind = "gauge"
+	histogramKind kind = "histogram"
+)
+
+// family is a metric with every series of label values recorded
+type family struct {
+	kind   kind
+	series map[string]*series
+}
+
+type series struct {
+	labels string
+	value  float64
+	// histograms only
+	buckets []uint64
+	count   uint64
+}
+
+// Registry keeps the metrics in memory and serves them in the Prometheus text format
+// Tags are "key:value" pairs turned into labels, they must have a bounded set of values
+type Registry struct {
+	mutex    sync.Mutex
+	families map[string]*family
+	buckets  []float64
+}
+
+// NewRegistry creates a registry without metrics using the default histogram buckets
+func NewRegistry() *Registry {
+	return &Registry{
+		families: map[string]*family{},
+		buckets:  DefaultBuckets,
+	}
+}
+
+// Timing observes a duration in the <name>_duration_seconds histogram
+func (r *Registry) Timing(name string, duration time.Duration, tags []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s := r.series(name+"_duration_seconds", histogramKind, tags)
+	if s == nil {
+		return
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range r.buckets {
+		if seconds <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.value += seconds
+	s.count++
+}
+
+// Count adds delta to the <name>_total counter
+func (r *Registry) Count(name string, delta float64, tags []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if s := r.series(name+"_total", counterKind, tags); s != nil {
+		s.value += delta
+	}
+}
+
+// Gauge sets the value of the name gauge
+func (r *Registry) Gauge(name string, value float64, tags []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if s := r.series(name, gaugeKind, tags); s != nil {
+		s.value = value
+	}
+}
+
+// series returns the series of the labels, nil when the name is already used by a metric of another kind
+func (r *Registry) series(name string, k kind, tags []string) *series {
+	name = sanitize(name)
+
+	f, found := r.families[name]
+	if !found {
+		f = &family{kind: k, series: map[string]*series{}}
+		r.families[name] = f
+	}
+	if f.kind != k {
+		return nil
+	}
+
+	labels := formatLabels(tags)
+	s, found := f.series[labels]
+	if !found {
+		s = &series{labels: labels}
+		if k == histogramKind {
+			s.buckets = make([]uint64, len(r.buckets))
+		}
+		f.series[labels] = s
+	}
+
+	return s
+}
+
+// WriteTo writes every metric in the Prometheus text format, sorted by name and labels
+// The metrics are rendered under the lock and written once it is released, so a slow scraper doesn't hold
+// back the requests being measured
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	return r.render().WriteTo(w)
+}
+
+// render formats every metric into a buffer
+func (r *Registry) render() *bytes.Buffer {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buffer := &bytes.Buffer{}
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(buffer, "# TYPE %s %s\n", name, f.kind)
+
+		keys := make([]string, 0, len(f.series))
+		for labels := range f.series {
+			keys = append(keys, labels)
+		}
+		sort.Strings(keys)
+
+		for _, labels := range keys {
+			r.writeSeries(buffer, name, f.kind, f.series[labels])
+		}
+	}
+
+	return buffer
+}
+
+func (r *Registry) writeSeries(w io.Writer, name string, k kind, s *series) {
+	if k != histogramKind {
+		fmt.Fprintf(w, "%s%s %s\n", name, braces(s.labels), formatFloat(s.value))
+		return
+	}
+
+	for i, bound := range r.buckets {
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, braces(withLabel(s.labels, "le", formatFloat(bound))), s.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, braces(withLabel(s.labels, "le", "+Inf")), s.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, braces(s.labels), formatFloat(s.value))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, braces(s.labels), s.count)
+}
+
+// ServeHTTP serves the metrics to scrapers
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = r.WriteTo(w)
+}
+
+// formatLabels turns "key:value" tags into labels sorted by key, tags without a value are ignored
+func formatLabels(tags []string) string {
+	labels := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		key, value, found := strings.Cut(tag, ":")
+		if !found || key == "" {
+			continue
+		}
+		labels = append(labels, formatLabel(sanitize(key), value))
+	}
+	sort.Strings(labels)
+
+	return strings.Join(labels, ",")
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabel(key, value string) string {
+	return key + `="` + labelValueEscaper.Replace(value) + `"`
+}
+
+func withLabel(labels, key, value string) string {
+	label := formatLabel(key, value)
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+// sanitize replaces the characters not allowed in metric and label names
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,111 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	tags := []string{"status:200", "route:/api/users/:id", "method:GET"}
+	registry.Timing("http_request", 20*time.Millisecond, tags)
+	registry.Timing("http_request", 3*time.Second, tags)
+	registry.Count("repository_cache_hits", 1, []string{"repository:users"})
+	registry.Count("repository_cache_hits", 2, []string{"repository:users"})
+	registry.Gauge("repository_records_loaded", 5, []string{"repository:users"})
+	registry.Gauge("repository_records_loaded", 7, []string{"repository:users"})
+	// escaped label values, tags without value ignored
+	registry.Gauge("labels", 1, []string{`path:a"b\c`, "untagged"})
+
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, metrics.ContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, strings.Join([]string{
+		`# TYPE http_request_duration_seconds histogram`,
+		`http_request_duration_seconds_bucket{method="GET",route="/api/users/:id",status="200",le="0.005"} 0`,
+		`http_request_duration_seconds_bucket{method="GET",route="/api/users/:id",status="200",le="0.01"} 0`,
+		`http_request_duration_seconds_bucket{method="GET",route="/api/users/:id",status="200",le="0.025"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/api/users/:id",status="200",le="0.05"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/api/users/:id",status="200",le="0.1"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/api/users/:id",status="200",le="0.25"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/api/users/:id",status="200",le="0.5"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/api/users/:id",status="200",le="1"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/api/users/:id",status="200",le="2.5"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/api/users/:id",status="200",le="5"} 2`,
+		`http_request_duration_seconds_bucket{method="GET",route="/api/users/:id",status="200",le="10"} 2`,
+		`http_request_duration_seconds_bucket{method="GET",route="/api/users/:id",status="200",le="+Inf"} 2`,
+		`http_request_duration_seconds_sum{method="GET",route="/api/users/:id",status="200"} 3.02`,
+		`http_request_duration_seconds_count{method="GET",route="/api/users/:id",status="200"} 2`,
+		`# TYPE labels gauge`,
+		`labels{path="a\"b\\c"} 1`,
+		`# TYPE repository_cache_hits_total counter`,
+		`repository_cache_hits_total{repository="users"} 3`,
+		`# TYPE repository_records_loaded gauge`,
+		`repository_records_loaded{repository="users"} 7`,
+		``,
+	}, "\n"), w.Body.String())
+}
+
+func TestRegistryIgnoresKindConflicts(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	registry.Gauge("loads_total", 1, nil)
+	registry.Count("loads", 1, nil)
+
+	var body strings.Builder
+	_, err := registry.WriteTo(&body)
+	assert.NoError(t, err)
+	assert.Equal(t, "# TYPE loads_total gauge\nloads_total 1\n", body.String())
+}
+
+// blockedWriter signals the writes started and blocks them until released
+type blockedWriter struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w blockedWriter) Write(p []byte) (int, error) {
+	select {
+	case w.started <- struct{}{}:
+	default:
+	}
+	<-w.release
+	return len(p), nil
+}
+
+func TestRegistrySlowScrapeDoesNotBlockRecording(t *testing.T) {
+	registry := metrics.NewRegistry()
+	registry.Count("requests", 1, nil)
+
+	writer := blockedWriter{started: make(chan struct{}, 1), release: make(chan struct{})}
+	scraped := make(chan struct{})
+	go func() {
+		defer close(scraped)
+		_, _ = registry.WriteTo(writer)
+	}()
+	defer func() {
+		close(writer.release)
+		<-scraped
+	}()
+	<-writer.started
+
+	recorded := make(chan struct{})
+	go func() {
+		defer close(recorded)
+		registry.Timing("http_request", time.Millisecond, nil)
+	}()
+
+	select {
+	case <-recorded:
+	case <-time.After(time.Second):
+		t.Fatal("recording waited for the scrape")
+	}
+}
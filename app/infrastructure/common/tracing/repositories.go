@@ -0,0 +1,187 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordsKey is the attribute holding the number of records returned by a repository call
+const recordsKey = attribute.Key("repository.records")
+
+// end records the error of the call, if any, and ends the span
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ActionReadRepository starts a span for every call to the repository it wraps
+type ActionReadRepository struct {
+	repository domain.ActionReadRepository
+	tracer     trace.Tracer
+}
+
+// NewActionReadRepository wraps the repository, the repositories keeping the referral index or the transition counts
+// are still readers of them
+func NewActionReadRepository(repository domain.ActionReadRepository, tracer trace.Tracer) domain.ActionReadRepository {
+	traced := &ActionReadRepository{repository: repository, tracer: tracer}
+	referrals, keepsReferrals := repository.(domain.ReferralIndexReader)
+	counts, keepsCounts := repository.(domain.NextActionCountReader)
+
+	switch {
+	case keepsReferrals && keepsCounts:
+		return &readModelRepository{
+			referralIndexReadRepository: &referralIndexReadRepository{ActionReadRepository: traced, reader: referrals},
+			counts:                      &nextActionCountReadRepository{ActionReadRepository: traced, reader: counts},
+		}
+	case keepsReferrals:
+		return &referralIndexReadRepository{ActionReadRepository: traced, reader: referrals}
+	case keepsCounts:
+		return &nextActionCountReadRepository{ActionReadRepository: traced, reader: counts}
+	}
+
+	return traced
+}
+
+// CountByUserID counts the actions of the user
+func (r *ActionReadRepository) CountByUserID(ctx context.Context, userID int) (int, error) {
+	ctx, span := r.tracer.Start(ctx, "ActionReadRepository.CountByUserID",
+		trace.WithAttributes(attribute.Int("user.id", userID)))
+
+	count, err := r.repository.CountByUserID(ctx, userID)
+	span.SetAttributes(recordsKey.Int(count))
+	end(span, err)
+
+	return count, err
+}
+
+// GetNextActionProbabilities returns the probabilities of the actions following actionType
+func (r *ActionReadRepository) GetNextActionProbabilities(ctx context.Context, actionType string) (map[string]float64, error) {
+	ctx, span := r.tracer.Start(ctx, "ActionReadRepository.GetNextActionProbabilities",
+		trace.WithAttributes(attribute.String("action.type", actionType)))
+
+	probabilities, err := r.repository.GetNextActionProbabilities(ctx, actionType)
+	span.SetAttributes(recordsKey.Int(len(probabilities)))
+	end(span, err)
+
+	return probabilities, err
+}
+
+// GetAll returns every action
+func (r *ActionReadRepository) GetAll(ctx context.Context) ([]domain.Action, error) {
+	ctx, span := r.tracer.Start(ctx, "ActionReadRepository.GetAll")
+
+	actions, err := r.repository.GetAll(ctx)
+	span.SetAttributes(recordsKey.Int(len(actions)))
+	end(span, err)
+
+	return actions, err
+}
+
+type referralIndexReadRepository struct {
+	*ActionReadRepository
+	reader domain.ReferralIndexReader
+}
+
+// GetReferralIndex returns the referral index of every user
+func (r *referralIndexReadRepository) GetReferralIndex(ctx context.Context) (map[int]int, error) {
+	ctx, span := r.tracer.Start(ctx, "ActionReadRepository.GetReferralIndex")
+
+	index, err := r.reader.GetReferralIndex(ctx)
+	span.SetAttributes(recordsKey.Int(len(index)))
+	end(span, err)
+
+	return index, err
+}
+
+type nextActionCountReadRepository struct {
+	*ActionReadRepository
+	reader domain.NextActionCountReader
+}
+
+// GetNextActionCounts returns how many times each action followed actionType
+func (r *nextActionCountReadRepository) GetNextActionCounts(ctx context.Context, actionType string) (map[string]int, error) {
+	ctx, span := r.tracer.Start(ctx, "ActionReadRepository.GetNextActionCounts",
+		trace.WithAttributes(attribute.String("action.type", actionType)))
+
+	counts, err := r.reader.GetNextActionCounts(ctx, actionType)
+	span.SetAttributes(recordsKey.Int(len(counts)))
+	end(span, err)
+
+	return counts, err
+}
+
+// readModelRepository is the repository of the read models keeping both the referral index and the transition counts
+type readModelRepository struct {
+	*referralIndexReadRepository
+	counts *nextActionCountReadRepository
+}
+
+// GetNextActionCounts returns how many times each action followed actionType
+func (r *readModelRepository) GetNextActionCounts(ctx context.Context, actionType string) (map[string]int, error) {
+	return r.counts.GetNextActionCounts(ctx, actionType)
+}
+
+// ActionWriteRepository starts a span for every action saved in the repository it wraps
+type ActionWriteRepository struct {
+	repository domain.ActionWriteRepository
+	tracer     trace.Tracer
+}
+
+// NewActionWriteRepository wraps the repository
+func NewActionWriteRepository(repository domain.ActionWriteRepository, tracer trace.Tracer) *ActionWriteRepository {
+	return &ActionWriteRepository{repository: repository, tracer: tracer}
+}
+
+// Save stores the action
+func (r *ActionWriteRepository) Save(ctx context.Context, action domain.Action) (domain.Action, error) {
+	ctx, span := r.tracer.Start(ctx, "ActionWriteRepository.Save",
+		trace.WithAttributes(attribute.String("action.type", action.Type)))
+
+	stored, err := r.repository.Save(ctx, action)
+	if err == nil {
+		span.SetAttributes(attribute.Int("action.id", stored.ID))
+	}
+	end(span, err)
+
+	return stored, err
+}
+
+// UserReadRepository starts a span for every call to the repository it wraps
+type UserReadRepository struct {
+	repository domain.UserReadRepository
+	tracer     trace.Tracer
+}
+
+// NewUserReadRepository wraps the repository
+func NewUserReadRepository(repository domain.UserReadRepository, tracer trace.Tracer) *UserReadRepository {
+	return &UserReadRepository{repository: repository, tracer: tracer}
+}
+
+// GetByID returns the user
+func (r *UserReadRepository) GetByID(ctx context.Context, id int) (domain.User, error) {
+	ctx, span := r.tracer.Start(ctx, "UserReadRepository.GetByID",
+		trace.WithAttributes(attribute.Int("user.id", id)))
+
+	user, err := r.repository.GetByID(ctx, id)
+	end(span, err)
+
+	return user, err
+}
+
+// GetByIDs returns the users with the ids
+func (r *UserReadRepository) GetByIDs(ctx context.Context, ids []int) (map[int]domain.User, error) {
+	ctx, span := r.tracer.Start(ctx, "UserReadRepository.GetByIDs")
+
+	users, err := r.repository.GetByIDs(ctx, ids)
+	span.SetAttributes(recordsKey.Int(len(users)))
+	end(span, err)
+
+	return users, err
+}
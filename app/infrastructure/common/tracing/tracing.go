@@ -0,0 +1,109 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	// ExporterNone disables tracing
+	ExporterNone = ""
+	// ExporterOTLP sends the spans to an OTLP/HTTP collector
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes the spans to the standard output
+	ExporterStdout = "stdout"
+	// ExporterFile appends the spans to a file, one JSON span per line
+	ExporterFile = "file"
+)
+
+// InstrumentationName is the name of the tracer of the service
+const InstrumentationName = "github.com/JoseBeteta/surfe"
+
+// Config are the configurations related to tracing
+type Config struct {
+	Exporter     string  `env:"TRACING_EXPORTER" env-default:"" validate:"omitempty,oneof=otlp stdout file"`
+	OTLPEndpoint string  `env:"TRACING_OTLP_ENDPOINT" env-default:"localhost:4318"`
+	OTLPInsecure bool    `env:"TRACING_OTLP_INSECURE" env-default:"true"`
+	File         string  `env:"TRACING_FILE" env-default:"data/traces.jsonl"`
+	SampleRatio  float64 `env:"TRACING_SAMPLE_RATIO" env-default:"1" validate:"gte=0,lte=1"`
+}
+
+// Propagator reads and writes the W3C traceparent and tracestate headers
+var Propagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// NewTracerProvider creates the provider sending the spans to the configured exporter, and the function flushing
+// the spans left on shutdown. Spans are not recorded when no exporter is configured, traces are still propagated
+func NewTracerProvider(cfg Config, serviceName, version string) (trace.TracerProvider, func(context.Context) error, error) {
+	if cfg.Exporter == ExporterNone {
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	}
+
+	exporter, closeOutput, err := newExporter(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+			attribute.String("service.version", version),
+		)),
+	)
+
+	shutdown := func(ctx context.Context) error {
+		if err := provider.Shutdown(ctx); err != nil {
+			return err
+		}
+		return closeOutput()
+	}
+
+	return provider, shutdown, nil
+}
+
+// newExporter returns the exporter and the function closing its output
+func newExporter(cfg Config) (sdktrace.SpanExporter, func() error, error) {
+	noOutput := func() error { return nil }
+
+	switch cfg.Exporter {
+	case ExporterOTLP:
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.OTLPEndpoint)}
+		if cfg.OTLPInsecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		// the connection is established lazily, a collector down does not keep the service from starting
+		exporter, err := otlptracehttp.New(context.Background(), options...)
+		return exporter, noOutput, err
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		return exporter, noOutput, err
+	case ExporterFile:
+		if err := os.MkdirAll(filepath.Dir(cfg.File), 0o755); err != nil {
+			return nil, nil, err
+		}
+		file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return exporter, file.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+}
@@ -0,0 +1,128 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/tracing"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// actionRepository returns the actions given, failing to count them
+type actionRepository struct {
+	actions []domain.Action
+}
+
+func (r *actionRepository) CountByUserID(context.Context, int) (int, error) {
+	return 0, errors.New("storage unavailable")
+}
+
+func (r *actionRepository) GetNextActionProbabilities(context.Context, string) (map[string]float64, error) {
+	return map[string]float64{}, nil
+}
+
+func (r *actionRepository) GetAll(context.Context) ([]domain.Action, error) {
+	return r.actions, nil
+}
+
+func (r *actionRepository) GetReferralIndex(context.Context) (map[int]int, error) {
+	return map[int]int{}, nil
+}
+
+func (r *actionRepository) GetNextActionCounts(context.Context, string) (map[string]int, error) {
+	return map[string]int{"REFER_USER": 2}, nil
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	values := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		values[kv.Key] = kv.Value
+	}
+	return values
+}
+
+func TestRequestAndRepositorySpans(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracing.InstrumentationName)
+
+	repository := tracing.NewActionReadRepository(&actionRepository{actions: make([]domain.Action, 3)}, tracer)
+	_, keepsReferralIndex := repository.(domain.ReferralIndexReader)
+	assert.True(t, keepsReferralIndex)
+	_, keepsCounts := repository.(domain.NextActionCountReader)
+	assert.True(t, keepsCounts)
+
+	router := gin.New()
+	router.Use(tracing.Middleware(tracer))
+	router.GET("/api/actions/users/:id", func(c *gin.Context) {
+		if _, err := repository.GetAll(c.Request.Context()); err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		if _, err := repository.CountByUserID(c.Request.Context(), 1); err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/actions/users/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	getAll, count, server := spans[0], spans[1], spans[2]
+
+	// the trace of the caller is continued
+	assert.Equal(t, "GET /api/actions/users/:id", server.Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.Equal(t, int64(500), attributes(server)["http.response.status_code"].AsInt64())
+	assert.Equal(t, "/api/actions/users/:id", attributes(server)["http.route"].AsString())
+	assert.Equal(t, codes.Error, server.Status().Code)
+
+	// repository calls are children of the request
+	assert.Equal(t, "ActionReadRepository.GetAll", getAll.Name())
+	assert.Equal(t, server.SpanContext().SpanID(), getAll.Parent().SpanID())
+	assert.Equal(t, int64(3), attributes(getAll)["repository.records"].AsInt64())
+
+	assert.Equal(t, "ActionReadRepository.CountByUserID", count.Name())
+	assert.Equal(t, server.SpanContext().SpanID(), count.Parent().SpanID())
+	assert.Equal(t, codes.Error, count.Status().Code)
+	assert.Equal(t, "storage unavailable", count.Status().Description)
+}
+
+func TestNoExporterPropagatesTraces(t *testing.T) {
+	provider, shutdown, err := tracing.NewTracerProvider(tracing.Config{}, "surfe", "test")
+	require.NoError(t, err)
+	defer shutdown(context.Background())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(tracing.Middleware(provider.Tracer(tracing.InstrumentationName)))
+
+	var traceparent string
+	router.GET("/", func(c *gin.Context) {
+		carrier := http.Header{}
+		tracing.Propagator.Inject(c.Request.Context(), propagation.HeaderCarrier(carrier))
+		traceparent = carrier.Get("traceparent")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", traceparent)
+}
@@ -0,0 +1,230 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// AMQPSource consumes action messages from a durable AMQP 0-9-1 queue with manual acknowledgements
+// Rejected messages are routed to the dead letter exchange of the queue when it has one
+// The source connects to the broker on the first Receive. When the broker is unreachable, or the connection
+// is lost, it dials again with exponential backoff and resumes consuming, the messages not acknowledged
+// before are delivered again by the broker
+type AMQPSource struct {
+	url        string
+	queue      string
+	prefetch   int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	logger     slog.Logger
+
+	// the connection, the channel and the deliveries are nil while disconnected
+	mutex      sync.RWMutex
+	connection *amqp.Connection
+	channel    *amqp.Channel
+	deliveries <-chan amqp.Delivery
+	closed     chan struct{}
+}
+
+// NewAMQPSource creates a source of the queue, disconnected until the first Receive, declaring the queue when needed
+// At most prefetch messages are delivered without being acknowledged
+// Connection attempts failing wait minBackoff, doubled after every failure up to maxBackoff
+func NewAMQPSource(url, queue string, prefetch int, minBackoff, maxBackoff time.Duration, logger slog.Logger) *AMQPSource {
+	return &AMQPSource{
+		url:        url,
+		queue:      queue,
+		prefetch:   prefetch,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		logger:     logger,
+		closed:     make(chan struct{}),
+	}
+}
+
+// connect dials the broker and subscribes to the queue, replacing the previous connection
+func (s *AMQPSource) connect() error {
+	connection, err := amqp.Dial(s.url)
+	if err != nil {
+		return fmt.Errorf("connecting to broker: %w", err)
+	}
+
+	channel, deliveries, err := consume(connection, s.queue, s.prefetch)
+	if err != nil {
+		connection.Close()
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.isClosed() {
+		connection.Close()
+		return ErrSourceClosed
+	}
+	s.connection, s.channel, s.deliveries = connection, channel, deliveries
+
+	return nil
+}
+
+func consume(connection *amqp.Connection, queue string, prefetch int) (*amqp.Channel, <-chan amqp.Delivery, error) {
+	channel, err := connection.Channel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening broker channel: %w", err)
+	}
+
+	if err := channel.Qos(prefetch, 0, false); err != nil {
+		return nil, nil, fmt.Errorf("setting broker prefetch: %w", err)
+	}
+
+	if _, err := channel.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+		return nil, nil, fmt.Errorf("declaring queue %s: %w", queue, err)
+	}
+
+	deliveries, err := channel.Consume(queue, "", false, false, false, false, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("consuming queue %s: %w", queue, err)
+	}
+
+	return channel, deliveries, nil
+}
+
+// dial connects to the broker until it succeeds, the context is done or the source is closed
+func (s *AMQPSource) dial(ctx context.Context) error {
+	backoff := s.minBackoff
+	for attempt := 1; ; attempt++ {
+		err := s.connect()
+		if err == nil {
+			s.logger.Info("connected to broker", "attempts", attempt)
+			return nil
+		}
+		if errors.Is(err, ErrSourceClosed) {
+			return err
+		}
+
+		s.logger.Warn("error connecting to broker", "attempt", attempt, "error", err.Error())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.closed:
+			return ErrSourceClosed
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, s.maxBackoff)
+	}
+}
+
+// disconnect closes the connection lost, so the next Receive dials the broker again
+func (s *AMQPSource) disconnect() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.connection != nil {
+		s.connection.Close()
+	}
+	s.connection, s.channel, s.deliveries = nil, nil, nil
+}
+
+func (s *AMQPSource) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Receive returns the next delivery of the queue, connecting first when disconnected from the broker
+func (s *AMQPSource) Receive(ctx context.Context) (Message, error) {
+	for {
+		s.mutex.RLock()
+		deliveries := s.deliveries
+		s.mutex.RUnlock()
+
+		if deliveries != nil {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case delivery, ok := <-deliveries:
+				if ok {
+					return amqpMessage{delivery}, nil
+				}
+			}
+
+			if s.isClosed() {
+				return nil, ErrSourceClosed
+			}
+			s.logger.Warn("broker connection lost, reconnecting")
+			s.disconnect()
+		}
+
+		if err := s.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Backlog returns the number of messages ready in the queue, the ones delivered and not acknowledged excluded
+// It fails with ErrBrokerDisconnected while disconnected
+func (s *AMQPSource) Backlog() (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.channel == nil {
+		return 0, ErrBrokerDisconnected
+	}
+	queue, err := s.channel.QueueDeclarePassive(s.queue, true, false, false, false, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return queue.Messages, nil
+}
+
+// ErrBrokerDisconnected is returned when the connection or the channel to the broker is closed
+var ErrBrokerDisconnected = errors.New("broker connection closed")
+
+// Ping fails when the connection or the channel to the broker is closed, before connecting and while reconnecting too
+func (s *AMQPSource) Ping(context.Context) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.connection == nil || s.connection.IsClosed() || s.channel.IsClosed() {
+		return ErrBrokerDisconnected
+	}
+	return nil
+}
+
+// Close closes the channel and the connection, unacknowledged messages are requeued by the broker
+// Reconnection attempts in progress are stopped
+func (s *AMQPSource) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.isClosed() {
+		return nil
+	}
+	close(s.closed)
+
+	if s.connection == nil {
+		return nil
+	}
+	return s.connection.Close()
+}
+
+type amqpMessage struct {
+	delivery amqp.Delivery
+}
+
+func (m amqpMessage) Body() []byte { return m.delivery.Body }
+
+func (m amqpMessage) PublishedAt() time.Time { return m.delivery.Timestamp }
+
+func (m amqpMessage) Ack() error { return m.delivery.Ack(false) }
+
+func (m amqpMessage) Nack(requeue bool) error { return m.delivery.Nack(false, requeue) }
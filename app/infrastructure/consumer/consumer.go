@@ -0,0 +1,235 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+)
+
+// Stats are the counters and lag of a consumer
+type Stats struct {
+	Received   int `json:"received"`
+	Processed  int `json:"processed"`
+	Duplicates int `json:"duplicates"`
+	Rejected   int `json:"rejected"`
+	Failed     int `json:"failed"`
+	// Backlog is the number of messages waiting in the source
+	Backlog int `json:"backlog"`
+	// Lag is the time between the last handled message was published and it was stored
+	Lag             time.Duration `json:"lag"`
+	LastProcessedAt *time.Time    `json:"lastProcessedAt,omitempty"`
+}
+
+// MetricsAgent receives the metrics of the messages handled and the backlog of the source
+type MetricsAgent interface {
+	Count(name string, delta float64, tags []string)
+	Gauge(name string, value float64, tags []string)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Count(string, float64, []string) {}
+func (nopMetrics) Gauge(string, float64, []string) {}
+
+// actionMessage is the body of an action message, the ID is required so redeliveries are detected
+type actionMessage struct {
+	ID         *int      `json:"id"`
+	Type       string    `json:"type"`
+	UserID     int       `json:"userId"`
+	TargetUser int       `json:"targetUser"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Consumer stores the actions delivered by a source through the action write repository
+// Messages are acknowledged once stored, so a message is stored at least once; as the
+// repository rejects actions with an ID already stored, redelivered messages are acknowledged
+// as duplicates instead of stored twice
+type Consumer struct {
+	source     ActionSource
+	repository domain.ActionWriteRepository
+	retryDelay time.Duration
+	logger     slog.Logger
+	metrics    MetricsAgent
+	now        func() time.Time
+
+	mutex sync.Mutex
+	stats Stats
+}
+
+// NewConsumer creates a consumer of the source
+func NewConsumer(
+	source ActionSource,
+	repository domain.ActionWriteRepository,
+	retryDelay time.Duration,
+	logger slog.Logger,
+) *Consumer {
+	return &Consumer{
+		source:     source,
+		repository: repository,
+		retryDelay: retryDelay,
+		logger:     logger,
+		metrics:    nopMetrics{},
+		now:        time.Now,
+	}
+}
+
+// WithMetrics allows you to specify the agent receiving the messages handled by outcome, the lag and the backlog
+func (c *Consumer) WithMetrics(metrics MetricsAgent) *Consumer {
+	c.metrics = metrics
+
+	return c
+}
+
+// Run handles the messages of the source until the context is done or the source is closed
+func (c *Consumer) Run(ctx context.Context) error {
+	for {
+		message, err := c.source.Receive(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !c.handle(ctx, message) {
+			// the message was requeued, waiting spares a busy loop while the repository fails
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(c.retryDelay):
+			}
+		}
+	}
+}
+
+// MeasureBacklog records the backlog of the source every interval until the context is done
+// The gauge keeps its last value while the source can't tell the backlog, e.g. disconnected from the broker
+func (c *Consumer) MeasureBacklog(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if backlog, err := c.source.Backlog(); err == nil {
+			c.metrics.Gauge("consumer_backlog_messages", float64(backlog), nil)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stats returns the counters of the handled messages and the backlog of the source
+func (c *Consumer) Stats() (Stats, error) {
+	backlog, err := c.source.Backlog()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := c.stats
+	stats.Backlog = backlog
+
+	return stats, nil
+}
+
+// handle stores the action of the message, it returns false when the message was requeued
+func (c *Consumer) handle(ctx context.Context, message Message) bool {
+	c.count(func(stats *Stats) { stats.Received++ })
+
+	action, err := decodeAction(message.Body())
+	if err != nil {
+		c.logger.Warn("invalid action message rejected", "error", err.Error())
+		c.count(func(stats *Stats) { stats.Rejected++ })
+		c.outcome("rejected")
+		c.settle(message.Nack(false))
+		return true
+	}
+
+	_, err = c.repository.Save(ctx, action)
+	switch {
+	case err == nil:
+		c.processed(message, func(stats *Stats) { stats.Processed++ })
+		c.outcome("processed")
+	case errors.Is(err, domain.ActionAlreadyExists):
+		c.processed(message, func(stats *Stats) { stats.Duplicates++ })
+		c.outcome("duplicate")
+	case errors.Is(err, domain.InvalidArgument):
+		c.logger.Warn("invalid action message rejected", "id", action.ID, "error", err.Error())
+		c.count(func(stats *Stats) { stats.Rejected++ })
+		c.outcome("rejected")
+		c.settle(message.Nack(false))
+	default:
+		c.logger.Error("error storing action message, requeued", "id", action.ID, "error", err.Error())
+		c.count(func(stats *Stats) { stats.Failed++ })
+		c.outcome("failed")
+		c.settle(message.Nack(true))
+		return false
+	}
+
+	return true
+}
+
+// processed acknowledges a handled message updating the lag
+func (c *Consumer) processed(message Message, update func(stats *Stats)) {
+	c.settle(message.Ack())
+
+	now := c.now()
+	c.count(func(stats *Stats) {
+		update(stats)
+		stats.LastProcessedAt = &now
+		if publishedAt := message.PublishedAt(); !publishedAt.IsZero() {
+			stats.Lag = now.Sub(publishedAt)
+			c.metrics.Gauge("consumer_lag_seconds", stats.Lag.Seconds(), nil)
+		}
+	})
+}
+
+// outcome counts a message handled in the consumer_messages_total counter
+func (c *Consumer) outcome(outcome string) {
+	c.metrics.Count("consumer_messages", 1, []string{"outcome:" + outcome})
+}
+
+// settle logs a failure acknowledging a message, the source delivers it again
+func (c *Consumer) settle(err error) {
+	if err != nil {
+		c.logger.Error("error acknowledging action message", "error", err.Error())
+	}
+}
+
+func (c *Consumer) count(update func(stats *Stats)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	update(&c.stats)
+}
+
+func decodeAction(body []byte) (domain.Action, error) {
+	var message actionMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		return domain.Action{}, fmt.Errorf("%w: %s", domain.InvalidArgument, err.Error())
+	}
+	if message.ID == nil {
+		return domain.Action{}, fmt.Errorf("%w: action id is required", domain.InvalidArgument)
+	}
+
+	action := domain.Action{
+		ID:         *message.ID,
+		Type:       message.Type,
+		UserID:     message.UserID,
+		TargetUser: message.TargetUser,
+		CreatedAt:  message.CreatedAt.UTC(),
+	}
+
+	return action, action.Validate()
+}
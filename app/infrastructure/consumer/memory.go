@@ -0,0 +1,133 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemorySource is an in process action source, messages rejected with requeue are delivered again
+type MemorySource struct {
+	mutex    sync.Mutex
+	queue    []*MemoryMessage
+	ready    chan struct{}
+	closed   bool
+	acked    int
+	rejected int
+}
+
+// NewMemorySource creates an empty source
+func NewMemorySource() *MemorySource {
+	return &MemorySource{ready: make(chan struct{}, 1)}
+}
+
+// Publish adds a message with the body to the source
+func (s *MemorySource) Publish(body []byte) {
+	s.push(&MemoryMessage{source: s, body: body, publishedAt: time.Now()})
+}
+
+// Receive returns the oldest message waiting to be delivered
+func (s *MemorySource) Receive(ctx context.Context) (Message, error) {
+	for {
+		s.mutex.Lock()
+		if len(s.queue) > 0 {
+			message := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mutex.Unlock()
+			return message, nil
+		}
+		closed := s.closed
+		s.mutex.Unlock()
+
+		if closed {
+			return nil, ErrSourceClosed
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.ready:
+		}
+	}
+}
+
+// Backlog returns the number of messages waiting to be delivered
+func (s *MemorySource) Backlog() (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.queue), nil
+}
+
+// Acked returns the number of messages acknowledged
+func (s *MemorySource) Acked() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.acked
+}
+
+// Rejected returns the number of messages rejected without requeue
+func (s *MemorySource) Rejected() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.rejected
+}
+
+// Close stops delivering messages once the waiting ones are delivered
+func (s *MemorySource) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	s.mutex.Unlock()
+
+	s.signal()
+	return nil
+}
+
+func (s *MemorySource) push(message *MemoryMessage) {
+	s.mutex.Lock()
+	s.queue = append(s.queue, message)
+	s.mutex.Unlock()
+
+	s.signal()
+}
+
+func (s *MemorySource) signal() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// MemoryMessage is a message of a MemorySource
+type MemoryMessage struct {
+	source      *MemorySource
+	body        []byte
+	publishedAt time.Time
+}
+
+func (m *MemoryMessage) Body() []byte { return m.body }
+
+func (m *MemoryMessage) PublishedAt() time.Time { return m.publishedAt }
+
+func (m *MemoryMessage) Ack() error {
+	m.source.mutex.Lock()
+	defer m.source.mutex.Unlock()
+
+	m.source.acked++
+	return nil
+}
+
+func (m *MemoryMessage) Nack(requeue bool) error {
+	if requeue {
+		m.source.push(m)
+		return nil
+	}
+
+	m.source.mutex.Lock()
+	defer m.source.mutex.Unlock()
+
+	m.source.rejected++
+	return nil
+}
@@ -0,0 +1,585 @@
+package persistence
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	domainAction "github.com/JoseBeteta/surfe/app/domain"
+)
+
+const (
+	// DefaultSegmentSize size in bytes after which the active segment is sealed and a new one started
+	DefaultSegmentSize int64 = 64 << 20
+
+	segmentExtension  = ".log"
+	outboxCursorFile  = "outbox.cursor"
+	recordHeaderSize  = 8
+	maxRecordSize     = 1 << 20
+	segmentNameDigits = 20
+)
+
+var (
+	// ErrCorruptSegment is returned when a sealed segment holds an invalid record
+	ErrCorruptSegment = errors.New("corrupt action log segment")
+	// errTornRecord is the corruption left by a record whose write was interrupted, which can only be the last one
+	errTornRecord = errors.New("torn record")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// recordLocation is where an action record is stored in the log
+type recordLocation struct {
+	segment int
+	offset  int64
+}
+
+// ActionLogRepository is a repository that stores actions in an append-only log split in segments
+// Every record is written as a 4 byte length and 4 byte CRC-32C header followed by the JSON action
+type ActionLogRepository struct {
+	dir            string
+	maxSegmentSize int64
+	logger         slog.Logger
+	mutex          sync.RWMutex
+
+	segments   []int
+	active     *os.File
+	activeSize int64
+
+	// index rebuilt from the segments when the repository is opened
+	locations   map[int]recordLocation
+	order       []int
+	countByUser map[int]int
+	nextID      int
+
+	// the log doubles as outbox, records after the cursor are pending to be dispatched
+	outboxCursor int
+	dispatched   map[int]struct{}
+}
+
+// NewActionLogRepository opens the action log stored in dir, creating it when it doesn't exist
+// Segments are scanned to rebuild the index and a partially written record left at the tail
+// of the last segment by a crash is truncated, any other corruption fails to open the log
+func NewActionLogRepository(dir string, maxSegmentSize int64, logger slog.Logger) (*ActionLogRepository, error) {
+	if maxSegmentSize <= 0 {
+		maxSegmentSize = DefaultSegmentSize
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating action log directory: %w", err)
+	}
+
+	r := &ActionLogRepository{
+		dir:            dir,
+		maxSegmentSize: maxSegmentSize,
+		logger:         logger,
+		locations:      make(map[int]recordLocation),
+		countByUser:    make(map[int]int),
+		dispatched:     make(map[int]struct{}),
+	}
+
+	segments, err := r.listSegments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		segments = []int{0}
+	}
+	r.segments = segments
+
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		if err := r.loadSegment(segment, last); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.openActive(segments[len(segments)-1]); err != nil {
+		return nil, err
+	}
+
+	if err := r.loadOutboxCursor(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// CountByUserID returns the count of actions for a given user ID
+func (r *ActionLogRepository) CountByUserID(ctx context.Context, userID int) (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.countByUser[userID], nil
+}
+
+// GetNextActionProbabilities calculates the probabilities of next actions after a given action type
+func (r *ActionLogRepository) GetNextActionProbabilities(ctx context.Context, actionType string) (map[string]float64, error) {
+	actions, err := r.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return nextActionProbabilities(actions, actionType), nil
+}
+
+// GetAll retrieves all actions from the log in the order they were written
+func (r *ActionLogRepository) GetAll(ctx context.Context) ([]domainAction.Action, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	actions := make([]domainAction.Action, 0, len(r.order))
+	for _, segment := range r.segments {
+		err := r.scanSegment(segment, func(action domainAction.Action, _ int64) error {
+			actions = append(actions, action)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return actions, nil
+}
+
+// Save appends the action to the log and syncs it to disk before returning
+func (r *ActionLogRepository) Save(ctx context.Context, action domainAction.Action) (domainAction.Action, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stored, err := r.append(action)
+	if err != nil {
+		return domainAction.Action{}, err
+	}
+
+	if err := r.active.Sync(); err != nil {
+		return domainAction.Action{}, err
+	}
+
+	return stored, nil
+}
+
+// Import appends a batch of actions syncing to disk once, intended to seed an empty log
+// Imported actions are not left pending in the outbox when nothing else was pending
+func (r *ActionLogRepository) Import(actions []domainAction.Action) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	caughtUp := r.outboxCursor == len(r.order)
+
+	for _, action := range actions {
+		if _, err := r.append(action); err != nil {
+			return fmt.Errorf("importing action %d: %w", action.ID, err)
+		}
+	}
+
+	if err := r.active.Sync(); err != nil {
+		return err
+	}
+
+	if caughtUp {
+		r.outboxCursor = len(r.order)
+		return r.saveOutboxCursor()
+	}
+
+	return nil
+}
+
+// PendingActions returns the actions appended after the outbox cursor and not dispatched yet, in log order
+func (r *ActionLogRepository) PendingActions(limit int) ([]domainAction.Action, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	actions := []domainAction.Action{}
+	for i := r.outboxCursor; i < len(r.order) && len(actions) < limit; i++ {
+		id := r.order[i]
+		if _, found := r.dispatched[id]; found {
+			continue
+		}
+
+		action, err := r.readAt(r.locations[id])
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+
+	return actions, nil
+}
+
+// MarkDispatched marks the actions as dispatched moving the outbox cursor past every dispatched record
+func (r *ActionLogRepository) MarkDispatched(actionIDs ...int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, id := range actionIDs {
+		r.dispatched[id] = struct{}{}
+	}
+
+	moved := false
+	for r.outboxCursor < len(r.order) {
+		id := r.order[r.outboxCursor]
+		if _, found := r.dispatched[id]; !found {
+			break
+		}
+		delete(r.dispatched, id)
+		r.outboxCursor++
+		moved = true
+	}
+
+	if !moved {
+		return nil
+	}
+	return r.saveOutboxCursor()
+}
+
+// Len returns the number of actions stored in the log
+func (r *ActionLogRepository) Len() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return len(r.order)
+}
+
+// Close closes the active segment
+func (r *ActionLogRepository) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.active.Close()
+}
+
+// append writes the action record rolling over to a new segment when the active one is full
+func (r *ActionLogRepository) append(action domainAction.Action) (domainAction.Action, error) {
+	if action.ID == domainAction.UnassignedID {
+		action.ID = r.nextID
+	}
+
+	if _, found := r.locations[action.ID]; found {
+		return domainAction.Action{}, domainAction.ActionAlreadyExists
+	}
+
+	if err := action.Validate(); err != nil {
+		return domainAction.Action{}, err
+	}
+
+	record, err := encodeRecord(action)
+	if err != nil {
+		return domainAction.Action{}, err
+	}
+
+	if r.activeSize > 0 && r.activeSize+int64(len(record)) > r.maxSegmentSize {
+		if err := r.rollover(); err != nil {
+			return domainAction.Action{}, err
+		}
+	}
+
+	offset := r.activeSize
+	if _, err := r.active.Write(record); err != nil {
+		// leave the segment as it was so later records are not written after a partial one
+		if truncErr := r.active.Truncate(offset); truncErr != nil {
+			return domainAction.Action{}, errors.Join(err, truncErr)
+		}
+		return domainAction.Action{}, err
+	}
+	r.activeSize += int64(len(record))
+
+	r.index(action, recordLocation{segment: r.segments[len(r.segments)-1], offset: offset})
+
+	return action, nil
+}
+
+// rollover seals the active segment and starts a new one
+func (r *ActionLogRepository) rollover() error {
+	if err := r.active.Sync(); err != nil {
+		return err
+	}
+	if err := r.active.Close(); err != nil {
+		return err
+	}
+
+	next := r.segments[len(r.segments)-1] + 1
+	r.segments = append(r.segments, next)
+
+	return r.openActive(next)
+}
+
+func (r *ActionLogRepository) openActive(segment int) error {
+	file, err := os.OpenFile(r.segmentPath(segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening action log segment: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.active = file
+	r.activeSize = info.Size()
+
+	return nil
+}
+
+func (r *ActionLogRepository) index(action domainAction.Action, location recordLocation) {
+	r.locations[action.ID] = location
+	r.order = append(r.order, action.ID)
+	r.countByUser[action.UserID]++
+	r.nextID = max(r.nextID, action.ID+1)
+}
+
+// loadOutboxCursor reads the outbox cursor, a log without cursor starts with nothing pending
+func (r *ActionLogRepository) loadOutboxCursor() error {
+	data, err := os.ReadFile(filepath.Join(r.dir, outboxCursorFile))
+	if os.IsNotExist(err) {
+		r.outboxCursor = len(r.order)
+		return r.saveOutboxCursor()
+	}
+	if err != nil {
+		return err
+	}
+
+	cursor, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid outbox cursor: %w", err)
+	}
+	// records after the cursor may have been lost with a truncated tail
+	r.outboxCursor = min(cursor, len(r.order))
+
+	return nil
+}
+
+// saveOutboxCursor atomically replaces the persisted outbox cursor
+func (r *ActionLogRepository) saveOutboxCursor() error {
+	path := filepath.Join(r.dir, outboxCursorFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(r.outboxCursor)), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// readAt reads the action stored at location
+func (r *ActionLogRepository) readAt(location recordLocation) (domainAction.Action, error) {
+	file, err := os.Open(r.segmentPath(location.segment))
+	if err != nil {
+		return domainAction.Action{}, err
+	}
+	defer file.Close()
+
+	var header [recordHeaderSize]byte
+	if _, err := file.ReadAt(header[:], location.offset); err != nil {
+		return domainAction.Action{}, r.corrupt(location.segment, location.offset, err)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := file.ReadAt(payload, location.offset+recordHeaderSize); err != nil {
+		return domainAction.Action{}, r.corrupt(location.segment, location.offset, err)
+	}
+
+	var action domainAction.Action
+	if err := json.Unmarshal(payload, &action); err != nil {
+		return domainAction.Action{}, r.corrupt(location.segment, location.offset, err)
+	}
+
+	return action, nil
+}
+
+// loadSegment indexes the records of a segment, truncating a torn record at the end when it is the last one
+func (r *ActionLogRepository) loadSegment(segment int, last bool) error {
+	var validSize int64
+	err := r.scanSegment(segment, func(action domainAction.Action, end int64) error {
+		r.index(action, recordLocation{segment: segment, offset: validSize})
+		validSize = end
+		return nil
+	})
+
+	if err == nil || !last || !errors.Is(err, errTornRecord) {
+		return err
+	}
+
+	info, err := os.Stat(r.segmentPath(segment))
+	if err != nil {
+		return err
+	}
+
+	// the record was not fully written before a crash, drop it so new records follow the last valid one
+	if err := os.Truncate(r.segmentPath(segment), validSize); err != nil {
+		return fmt.Errorf("truncating action log tail: %w", err)
+	}
+
+	r.logger.Warn("truncated torn record at the end of the action log",
+		slog.String("segment", r.segmentPath(segment)),
+		slog.Int64("offset", validSize),
+		slog.Int64("dropped_bytes", info.Size()-validSize))
+
+	return nil
+}
+
+// scanSegment streams the actions stored in a segment with the offset where each record ends
+func (r *ActionLogRepository) scanSegment(segment int, fn func(domainAction.Action, int64) error) error {
+	file, err := os.Open(r.segmentPath(segment))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	var (
+		offset int64
+		header [recordHeaderSize]byte
+	)
+
+	for {
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return r.corrupt(segment, offset, torn(err))
+		}
+
+		length := binary.BigEndian.Uint32(header[0:4])
+		checksum := binary.BigEndian.Uint32(header[4:8])
+		end := offset + recordHeaderSize + int64(length)
+		if length == 0 || length > maxRecordSize {
+			err := fmt.Errorf("invalid record length %d", length)
+			// space preallocated past the last write reads as zeros or garbage, no record can follow it
+			// when it runs past the end of the file or only zeros are left
+			if end > info.Size() {
+				return r.corrupt(segment, offset, torn(err))
+			}
+			if zeros, zerosErr := onlyZeros(reader); zerosErr != nil {
+				return zerosErr
+			} else if zeros {
+				err = torn(err)
+			}
+			return r.corrupt(segment, offset, err)
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return r.corrupt(segment, offset, torn(err))
+		}
+
+		// a complete but invalid record is only torn when nothing was written after it
+		invalid := func(err error) error {
+			if end == info.Size() {
+				err = torn(err)
+			}
+			return r.corrupt(segment, offset, err)
+		}
+		if crc32.Checksum(payload, crcTable) != checksum {
+			return invalid(errors.New("checksum mismatch"))
+		}
+
+		var action domainAction.Action
+		if err := json.Unmarshal(payload, &action); err != nil {
+			return invalid(err)
+		}
+
+		offset = end
+		if err := fn(action, offset); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *ActionLogRepository) corrupt(segment int, offset int64, err error) error {
+	return fmt.Errorf("%w %s at byte offset %d: %w", ErrCorruptSegment, r.segmentPath(segment), offset, err)
+}
+
+// torn marks the error of a record cut short by an interrupted write
+func torn(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("%w: %w", errTornRecord, err)
+}
+
+// onlyZeros reports whether every byte left to read is zero
+func onlyZeros(reader io.Reader) (bool, error) {
+	buffer := make([]byte, 32<<10)
+	for {
+		n, err := reader.Read(buffer)
+		for _, b := range buffer[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// listSegments returns the segment numbers found in the log directory in ascending order
+func (r *ActionLogRepository) listSegments() ([]int, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []int
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExtension) {
+			continue
+		}
+
+		segment, err := strconv.Atoi(strings.TrimSuffix(name, segmentExtension))
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	sort.Ints(segments)
+
+	return segments, nil
+}
+
+func (r *ActionLogRepository) segmentPath(segment int) string {
+	return filepath.Join(r.dir, fmt.Sprintf("%0*d%s", segmentNameDigits, segment, segmentExtension))
+}
+
+// encodeRecord encodes the action with its length and checksum header
+func encodeRecord(action domainAction.Action) ([]byte, error) {
+	payload, err := json.Marshal(action)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > maxRecordSize {
+		return nil, fmt.Errorf("action record of %d bytes exceeds the maximum size", len(payload))
+	}
+
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[recordHeaderSize:], payload)
+
+	return record, nil
+}
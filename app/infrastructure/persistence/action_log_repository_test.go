@@ -0,0 +1,284 @@
+package persistence_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+	"github.com/JoseBeteta/surfe/app/infrastructure/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var discardLogger = *slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func newAction(id, userID int, actionType string) domain.Action {
+	return domain.Action{
+		ID:        id,
+		Type:      actionType,
+		UserID:    userID,
+		CreatedAt: time.Date(2021, time.November, 19, 17, 0, id, 0, time.UTC),
+	}
+}
+
+// unassigned returns the action without ID so the repository assigns one
+func unassigned(action domain.Action) domain.Action {
+	action.ID = domain.UnassignedID
+	return action
+}
+
+func TestActionLogRepositorySaveAndReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	repo, err := persistence.NewActionLogRepository(dir, 0, discardLogger)
+	require.NoError(t, err)
+
+	_, err = repo.Save(context.Background(), newAction(0, 1, "WELCOME"))
+	require.NoError(t, err)
+	stored, err := repo.Save(context.Background(), unassigned(newAction(1, 1, "ADD_CONTACT")))
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored.ID)
+
+	_, err = repo.Save(context.Background(), newAction(1, 2, "WELCOME"))
+	assert.ErrorIs(t, err, domain.ActionAlreadyExists)
+
+	_, err = repo.Save(context.Background(), newAction(5, 2, ""))
+	assert.ErrorIs(t, err, domain.InvalidArgument)
+
+	require.NoError(t, repo.Close())
+
+	// the index is rebuilt from the segments
+	repo, err = persistence.NewActionLogRepository(dir, 0, discardLogger)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	count, err := repo.CountByUserID(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	actions, err := repo.GetAll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Action{newAction(0, 1, "WELCOME"), newAction(1, 1, "ADD_CONTACT")}, actions)
+
+	probabilities, err := repo.GetNextActionProbabilities(context.Background(), "WELCOME")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"ADD_CONTACT": 1}, probabilities)
+}
+
+func TestActionLogRepositorySegmentRollover(t *testing.T) {
+	dir := t.TempDir()
+
+	// small segments so every record starts a new one
+	repo, err := persistence.NewActionLogRepository(dir, 64, discardLogger)
+	require.NoError(t, err)
+
+	require.NoError(t, repo.Import([]domain.Action{
+		newAction(0, 1, "WELCOME"),
+		newAction(1, 1, "ADD_CONTACT"),
+		newAction(2, 1, "EDIT_CONTACT"),
+	}))
+	require.NoError(t, repo.Close())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	require.NoError(t, err)
+	assert.Len(t, segments, 3)
+
+	repo, err = persistence.NewActionLogRepository(dir, 64, discardLogger)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	actions, err := repo.GetAll(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, actions, 3)
+	assert.Equal(t, 2, actions[2].ID)
+}
+
+func TestActionLogRepositoryTruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+
+	repo, err := persistence.NewActionLogRepository(dir, 0, discardLogger)
+	require.NoError(t, err)
+	_, err = repo.Save(context.Background(), newAction(0, 1, "WELCOME"))
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	info, err := os.Stat(segments[0])
+	require.NoError(t, err)
+	validSize := info.Size()
+
+	// simulate a crash in the middle of writing a record
+	file, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 0, 40, 1, 2, 3, 4, '{', '"'})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	logs := &bytes.Buffer{}
+	repo, err = persistence.NewActionLogRepository(dir, 0, *slog.New(slog.NewTextHandler(logs, nil)))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	info, err = os.Stat(segments[0])
+	require.NoError(t, err)
+	assert.Equal(t, validSize, info.Size())
+	assert.Contains(t, logs.String(), "truncated torn record")
+	assert.Contains(t, logs.String(), "segment="+segments[0])
+	assert.Contains(t, logs.String(), "dropped_bytes=10")
+	assert.Equal(t, 1, repo.Len())
+
+	stored, err := repo.Save(context.Background(), unassigned(newAction(1, 1, "ADD_CONTACT")))
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored.ID)
+
+	actions, err := repo.GetAll(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, actions, 2)
+}
+
+func TestActionLogRepositoryTruncatesPreallocatedTail(t *testing.T) {
+	tests := map[string]struct {
+		tail []byte
+		torn bool
+	}{
+		"zero filled block": {tail: make([]byte, 4096), torn: true},
+		// the length reads past the end of the file, so no record can follow it
+		"garbage block":        {tail: append([]byte{0xde, 0xad, 0xbe, 0xef}, make([]byte, 60)...), torn: true},
+		"zeros before a write": {tail: append(make([]byte, 64), 0, 0, 0, 2, 1, 2, 3, 4, '{', '}'), torn: false},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			repo, err := persistence.NewActionLogRepository(dir, 0, discardLogger)
+			require.NoError(t, err)
+			_, err = repo.Save(context.Background(), newAction(0, 1, "WELCOME"))
+			require.NoError(t, err)
+			require.NoError(t, repo.Close())
+
+			segments, err := filepath.Glob(filepath.Join(dir, "*.log"))
+			require.NoError(t, err)
+			require.Len(t, segments, 1)
+			info, err := os.Stat(segments[0])
+			require.NoError(t, err)
+			validSize := info.Size()
+
+			file, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0o644)
+			require.NoError(t, err)
+			_, err = file.Write(tt.tail)
+			require.NoError(t, err)
+			require.NoError(t, file.Close())
+
+			repo, err = persistence.NewActionLogRepository(dir, 0, discardLogger)
+			if !tt.torn {
+				assert.ErrorIs(t, err, persistence.ErrCorruptSegment)
+				return
+			}
+			require.NoError(t, err)
+			defer repo.Close()
+
+			info, err = os.Stat(segments[0])
+			require.NoError(t, err)
+			assert.Equal(t, validSize, info.Size())
+			assert.Equal(t, 1, repo.Len())
+		})
+	}
+}
+
+func TestActionLogRepositoryRejectsCorruptRecordBeforeTail(t *testing.T) {
+	dir := t.TempDir()
+
+	repo, err := persistence.NewActionLogRepository(dir, 0, discardLogger)
+	require.NoError(t, err)
+	require.NoError(t, repo.Import([]domain.Action{newAction(0, 1, "WELCOME"), newAction(1, 1, "ADD_CONTACT")}))
+	require.NoError(t, repo.Close())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	// flip a payload byte of the first record, the one after it was fully written so it is not a torn write
+	data, err := os.ReadFile(segments[0])
+	require.NoError(t, err)
+	data[10] ^= 0xff
+	require.NoError(t, os.WriteFile(segments[0], data, 0o644))
+
+	_, err = persistence.NewActionLogRepository(dir, 0, discardLogger)
+	assert.ErrorIs(t, err, persistence.ErrCorruptSegment)
+
+	// nothing was dropped
+	info, err := os.Stat(segments[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size())
+}
+
+func TestActionLogRepositoryRejectsCorruptSealedSegment(t *testing.T) {
+	dir := t.TempDir()
+
+	repo, err := persistence.NewActionLogRepository(dir, 64, discardLogger)
+	require.NoError(t, err)
+	require.NoError(t, repo.Import([]domain.Action{newAction(0, 1, "WELCOME"), newAction(1, 1, "ADD_CONTACT")}))
+	require.NoError(t, repo.Close())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+
+	// flip a payload byte in the sealed segment so its checksum no longer matches
+	data, err := os.ReadFile(segments[0])
+	require.NoError(t, err)
+	data[len(data)-2] ^= 0xff
+	require.NoError(t, os.WriteFile(segments[0], data, 0o644))
+
+	_, err = persistence.NewActionLogRepository(dir, 64, discardLogger)
+	assert.ErrorIs(t, err, persistence.ErrCorruptSegment)
+}
+
+func TestActionLogRepositoryOutbox(t *testing.T) {
+	dir := t.TempDir()
+
+	repo, err := persistence.NewActionLogRepository(dir, 0, discardLogger)
+	require.NoError(t, err)
+
+	// imported actions are not dispatched
+	require.NoError(t, repo.Import([]domain.Action{newAction(0, 1, "WELCOME")}))
+
+	for _, action := range []domain.Action{
+		newAction(1, 1, "ADD_CONTACT"),
+		newAction(2, 1, "EDIT_CONTACT"),
+		newAction(3, 1, "VIEW_CONTACTS"),
+	} {
+		_, err := repo.Save(context.Background(), action)
+		require.NoError(t, err)
+	}
+
+	pending, err := repo.PendingActions(2)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, 1, pending[0].ID)
+
+	// dispatched out of order, the cursor only moves past 1
+	require.NoError(t, repo.MarkDispatched(1, 3))
+	require.NoError(t, repo.Close())
+
+	// the cursor survives a restart, 3 is dispatched again
+	repo, err = persistence.NewActionLogRepository(dir, 0, discardLogger)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	pending, err = repo.PendingActions(10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, 2, pending[0].ID)
+	assert.Equal(t, 3, pending[1].ID)
+}
@@ -1,118 +1,391 @@
 package persistence
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	domainAction "github.com/JoseBeteta/surfe/app/domain"
-	"io/ioutil"
-	"math"
 	"os"
-	"sort"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 )
 
-// ActionJSONRepository is a repository that interacts with a JSON file
+// ErrReadOnlyFormat is returned when saving into a data file that can't be rewritten
+var ErrReadOnlyFormat = errors.New("only uncompressed JSON array data files support writes")
+
+// outboxCursorSuffix names the file next to the data file holding the outbox cursor
+const outboxCursorSuffix = ".outbox"
+
+// ActionJSONRepository is a repository that interacts with a data file, either a JSON array,
+// NDJSON or CSV, optionally gzip compressed
 type ActionJSONRepository struct {
-	filePath string
-	mutex    sync.Mutex
+	filePath   string
+	csvMapping CSVMapping
+	meter      *fileMeter[domainAction.Action]
+	health     fileCheck
+	mutex      sync.Mutex
+
+	// the data file doubles as outbox, the actions after the cursor, by position, are pending to be dispatched
+	// Actions are only appended, so an action is pending from the rewrite storing it
+	outboxLoaded bool
+	outboxCursor int
+	dispatched   map[int]struct{}
+	// drained is the state of the data file when nothing was left pending, it isn't read again until it changes
+	drained os.FileInfo
 }
 
 // NewActionJSONRepository creates a new repository that uses a JSON file
 func NewActionJSONRepository(filePath string) *ActionJSONRepository {
-	return &ActionJSONRepository{filePath: filePath}
+	return &ActionJSONRepository{
+		filePath:   filePath,
+		csvMapping: DefaultActionCSVMapping(),
+		meter:      newFileMeter[domainAction.Action]("actions"),
+		dispatched: make(map[int]struct{}),
+	}
+}
+
+// WithCSVMapping allows you to specify the CSV columns holding each action field
+// Fields not present in the mapping keep their default column
+func (r *ActionJSONRepository) WithCSVMapping(mapping CSVMapping) *ActionJSONRepository {
+	r.csvMapping = r.csvMapping.merge(mapping)
+
+	return r
+}
+
+// WithMetrics allows you to specify the agent receiving the load times and records loaded of the data file
+func (r *ActionJSONRepository) WithMetrics(metrics MetricsAgent) *ActionJSONRepository {
+	r.meter.metrics = metrics
+
+	return r
 }
 
 // CountByUserID returns the count of actions for a given user ID
-func (r *ActionJSONRepository) CountByUserID(userID int) (int, error) {
+func (r *ActionJSONRepository) CountByUserID(ctx context.Context, userID int) (int, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	actions, err := r.readFromFile()
-	if err != nil {
-		return 0, err
-	}
-
 	count := 0
-	for _, action := range actions {
+	err := r.forEach(func(action domainAction.Action) error {
 		if action.UserID == userID {
 			count++
 		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
 
 	return count, nil
 }
 
 // GetNextActionProbabilities calculates the probabilities of next actions after a given action type
-func (r *ActionJSONRepository) GetNextActionProbabilities(actionType string) (map[string]float64, error) {
+func (r *ActionJSONRepository) GetNextActionProbabilities(ctx context.Context, actionType string) (map[string]float64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	actions, err := r.readFromFile()
 	if err != nil {
 		return nil, err
 	}
 
-	// Sort actions by userId and createdAt
-	sort.Slice(actions, func(i, j int) bool {
-		if actions[i].UserID == actions[j].UserID {
-			return actions[i].CreatedAt.Before(actions[j].CreatedAt)
-		}
-		return actions[i].UserID < actions[j].UserID
-	})
+	return nextActionProbabilities(actions, actionType), nil
+}
+
+// GetAll retrieves all actions from the JSON file
+func (r *ActionJSONRepository) GetAll(ctx context.Context) ([]domainAction.Action, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.readFromFile()
+}
 
-	// Count transitions from the given actionType
-	transitionCounts := make(map[string]int)
-	totalTransitions := 0
+// Save stores the action rewriting the whole data file
+// Every save reads and writes the full file, ActionLogRepository should be preferred for write loads
+func (r *ActionJSONRepository) Save(ctx context.Context, action domainAction.Action) (domainAction.Action, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 
-	for i := 0; i < len(actions)-1; i++ {
-		current := actions[i]
-		next := actions[i+1]
+	if formatFromExtension(strings.ToLower(r.filePath)) != FormatJSON {
+		return domainAction.Action{}, ErrReadOnlyFormat
+	}
 
-		if current.UserID == next.UserID && current.Type == actionType {
-			transitionCounts[next.Type]++
-			totalTransitions++
+	actions, err := r.readFromFile()
+	if err != nil {
+		return domainAction.Action{}, err
+	}
+
+	// the cursor is created before the action is stored, so it is left pending
+	if err := r.loadOutboxCursor(); err != nil {
+		return domainAction.Action{}, err
+	}
+	// the file may have been replaced by a shorter one
+	if r.outboxCursor > len(actions) {
+		r.outboxCursor = len(actions)
+		if err := r.saveOutboxCursor(); err != nil {
+			return domainAction.Action{}, err
+		}
+	}
+
+	nextID := 0
+	for _, stored := range actions {
+		if stored.ID == action.ID {
+			return domainAction.Action{}, domainAction.ActionAlreadyExists
 		}
+		nextID = max(nextID, stored.ID+1)
+	}
+	if action.ID == domainAction.UnassignedID {
+		action.ID = nextID
+	}
+
+	if err := action.Validate(); err != nil {
+		return domainAction.Action{}, err
 	}
 
-	// Calculate probabilities
-	probabilities := make(map[string]float64)
-	for action, count := range transitionCounts {
-		probabilities[action] = math.Round((float64(count)/float64(totalTransitions))*100) / 100
+	if err := writeJSONFile(r.filePath, append(actions, action)); err != nil {
+		return domainAction.Action{}, err
 	}
 
-	return probabilities, nil
+	return action, nil
 }
 
-// GetAll retrieves all actions from the JSON file
-func (r *ActionJSONRepository) GetAll() ([]domainAction.Action, error) {
+// PendingActions returns the actions stored after the outbox cursor and not dispatched yet, in file order
+// Data files that can't be written have nothing pending, no action is recorded into them
+func (r *ActionJSONRepository) PendingActions(limit int) ([]domainAction.Action, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	actions, err := r.readFromFile()
-	if err != nil {
+	actions := []domainAction.Action{}
+	if formatFromExtension(strings.ToLower(r.filePath)) != FormatJSON || r.unchangedSinceDrained() {
+		return actions, nil
+	}
+
+	if err := r.loadOutboxCursor(); err != nil {
 		return nil, err
 	}
 
+	info, _ := os.Stat(r.filePath)
+	position := 0
+	err := r.forEach(func(action domainAction.Action) error {
+		position++
+		if position <= r.outboxCursor {
+			return nil
+		}
+		if _, found := r.dispatched[action.ID]; found {
+			return nil
+		}
+		if len(actions) == limit {
+			return errStopIteration
+		}
+		actions = append(actions, action)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopIteration) {
+		return nil, err
+	}
+
+	if len(actions) == 0 {
+		r.drained = info
+	}
+
 	return actions, nil
 }
 
-// readFromFile reads the action data from the JSON file
-func (r *ActionJSONRepository) readFromFile() ([]domainAction.Action, error) {
-	file, err := os.Open(r.filePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []domainAction.Action{}, nil
+// MarkDispatched marks the actions as dispatched moving the outbox cursor past every dispatched action
+func (r *ActionJSONRepository) MarkDispatched(actionIDs ...int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.loadOutboxCursor(); err != nil {
+		return err
+	}
+
+	for _, id := range actionIDs {
+		r.dispatched[id] = struct{}{}
+	}
+
+	cursor, position := r.outboxCursor, 0
+	err := r.forEach(func(action domainAction.Action) error {
+		position++
+		if position <= cursor {
+			return nil
 		}
-		return nil, err
+		if _, found := r.dispatched[action.ID]; !found {
+			return errStopIteration
+		}
+		delete(r.dispatched, action.ID)
+		cursor++
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopIteration) {
+		return err
+	}
+
+	if cursor == r.outboxCursor {
+		return nil
+	}
+	r.outboxCursor = cursor
+	return r.saveOutboxCursor()
+}
+
+// unchangedSinceDrained reports whether the data file is as it was when nothing was left pending
+func (r *ActionJSONRepository) unchangedSinceDrained() bool {
+	if r.drained == nil {
+		return false
+	}
+
+	info, err := os.Stat(r.filePath)
+	return err == nil && sameFileState(r.drained, info)
+}
+
+// loadOutboxCursor reads the outbox cursor once, a data file without cursor starts with nothing pending
+func (r *ActionJSONRepository) loadOutboxCursor() error {
+	if r.outboxLoaded {
+		return nil
+	}
+
+	data, err := os.ReadFile(r.filePath + outboxCursorSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		count := 0
+		if err := r.forEach(func(domainAction.Action) error {
+			count++
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		r.outboxCursor, r.outboxLoaded = count, true
+		return r.saveOutboxCursor()
+	}
+	if err != nil {
+		return err
 	}
-	defer file.Close()
 
-	data, err := ioutil.ReadAll(file)
+	cursor, err := strconv.Atoi(strings.TrimSpace(string(data)))
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("invalid outbox cursor: %w", err)
+	}
+	r.outboxCursor, r.outboxLoaded = cursor, true
+
+	return nil
+}
+
+// saveOutboxCursor atomically replaces the persisted outbox cursor
+func (r *ActionJSONRepository) saveOutboxCursor() error {
+	path := r.filePath + outboxCursorSuffix
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(r.outboxCursor)), dataFileMode); err != nil {
+		return err
 	}
 
-	var actions []domainAction.Action
-	err = json.Unmarshal(data, &actions)
+	return os.Rename(tmp, path)
+}
+
+// readFromFile reads the action data from the JSON file
+func (r *ActionJSONRepository) readFromFile() ([]domainAction.Action, error) {
+	actions := []domainAction.Action{}
+	err := r.forEach(func(action domainAction.Action) error {
+		actions = append(actions, action)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	return actions, nil
 }
+
+// Ping fails when the data file is missing, can't be opened or holds a record that doesn't parse
+// The file is only decoded again once it changes
+func (r *ActionJSONRepository) Ping(ctx context.Context) error {
+	return r.health.check(ctx, r.filePath, func() error {
+		return r.decode(func(domainAction.Action) error { return nil })
+	})
+}
+
+// forEach streams every action stored in the data file into fn, recording the load
+func (r *ActionJSONRepository) forEach(fn func(domainAction.Action) error) error {
+	return r.meter.decode(r.decode, fn)
+}
+
+// decode streams every action stored in the data file into fn
+func (r *ActionJSONRepository) decode(fn func(domainAction.Action) error) error {
+	if _, err := os.Stat(r.filePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	return decodeFile(r.filePath, recordCodec[domainAction.Action]{
+		validate: domainAction.Action.Validate,
+		mapping:  r.csvMapping,
+		required: []string{"id", "type", "userId", "createdAt"},
+		fromCSV:  actionFromCSV,
+	}, fn)
+}
+
+// actionFromCSV builds an action from a CSV row
+func actionFromCSV(record csvRecord) (domainAction.Action, error) {
+	var (
+		action domainAction.Action
+		err    error
+	)
+
+	if action.ID, err = record.int("id"); err != nil {
+		return action, err
+	}
+	action.Type = record.get("type")
+	if action.UserID, err = record.int("userId"); err != nil {
+		return action, err
+	}
+	if action.TargetUser, err = record.optionalInt("targetUser"); err != nil {
+		return action, err
+	}
+	if action.CreatedAt, err = record.time("createdAt"); err != nil {
+		return action, err
+	}
+
+	return action, nil
+}
+
+// dataFileMode is the mode of the data files created, existing ones keep theirs
+const dataFileMode os.FileMode = 0o644
+
+// writeJSONFile atomically replaces the file at path with the JSON encoding of data, keeping the mode of the file
+func writeJSONFile(path string, data any) error {
+	mode := dataFileMode
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// temporary files are created only readable by the owner
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	encoder := json.NewEncoder(tmp)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("encoding data file: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
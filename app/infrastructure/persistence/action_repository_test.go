@@ -0,0 +1,205 @@
+package persistence_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+	"github.com/JoseBeteta/surfe/app/infrastructure/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActionRepositorySaveKeepsFileMode(t *testing.T) {
+	path := writeDataFile(t, "actions.json", `[]`)
+	require.NoError(t, os.Chmod(path, 0o640))
+
+	_, err := persistence.NewActionJSONRepository(path).
+		Save(context.Background(), domain.Action{ID: domain.UnassignedID, Type: "WELCOME", UserID: 1, CreatedAt: time.Now()})
+	require.NoError(t, err)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+}
+
+func TestActionRepositoryProbabilitiesKeepOrder(t *testing.T) {
+	repo := persistence.NewActionJSONRepository(writeDataFile(t, "actions.json", `[
+		{"id": 0, "type": "WELCOME", "userId": 2, "createdAt": "2021-11-19T17:00:10.202Z"},
+		{"id": 1, "type": "WELCOME", "userId": 1, "createdAt": "2021-11-19T17:00:11.202Z"},
+		{"id": 2, "type": "ADD_CONTACT", "userId": 1, "createdAt": "2021-11-19T17:00:12.202Z"}
+	]`))
+
+	before, err := repo.GetAll(context.Background())
+	require.NoError(t, err)
+
+	probabilities, err := repo.GetNextActionProbabilities(context.Background(), "WELCOME")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"ADD_CONTACT": 1}, probabilities)
+
+	after, err := repo.GetAll(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	// nor is the file saved sorted
+	_, err = repo.Save(context.Background(), domain.Action{ID: domain.UnassignedID, Type: "WELCOME", UserID: 3, CreatedAt: time.Now()})
+	require.NoError(t, err)
+	after, err = repo.GetAll(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, before, after[:3])
+}
+
+func TestAPIKeyRepositoryCreatesReadableFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+
+	repo, err := persistence.NewAPIKeyJSONRepository(path)
+	require.NoError(t, err)
+	require.NoError(t, repo.Save(domain.APIKey{ID: "key"}))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+}
+
+// keys created by another process sharing the file, such as --create-api-key, are served and not overwritten
+func TestAPIKeyRepositorySharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+
+	server, err := persistence.NewAPIKeyJSONRepository(path)
+	require.NoError(t, err)
+	require.NoError(t, server.Save(domain.APIKey{ID: "first"}))
+
+	cli, err := persistence.NewAPIKeyJSONRepository(path)
+	require.NoError(t, err)
+	require.NoError(t, cli.Save(domain.APIKey{ID: "created"}))
+
+	key, err := server.GetByID("created")
+	require.NoError(t, err)
+	assert.Equal(t, "created", key.ID)
+
+	require.NoError(t, server.MarkUsed("first", time.Now()))
+	_, err = server.Revoke("first", time.Now())
+	require.NoError(t, err)
+
+	reopened, err := persistence.NewAPIKeyJSONRepository(path)
+	require.NoError(t, err)
+	keys, err := reopened.GetAll()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.NotNil(t, keys[0].RevokedAt)
+	assert.NotNil(t, keys[0].LastUsedAt)
+	assert.Equal(t, "created", keys[1].ID)
+}
+
+func TestUserRepositoryGetByIDs(t *testing.T) {
+	repo := persistence.NewUserJSONRepository(writeDataFile(t, "users.json", `[
+		{"id": 1, "name": "Ferdinande", "createdAt": "2020-07-14T05:48:54.798Z"},
+		{"id": 2, "name": "Ada", "createdAt": "2020-07-15T05:48:54.798Z"}
+	]`))
+
+	users, err := repo.GetByIDs(context.Background(), []int{2, 3})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, "Ada", users[2].Name)
+
+	_, err = repo.GetByID(context.Background(), 3)
+	assert.ErrorIs(t, err, domain.UserNotFound)
+
+	// a file that doesn't parse fails instead of reading as missing users
+	corrupted := persistence.NewUserJSONRepository(writeDataFile(t, "corrupted.json", `[{"id": "one"`))
+	_, err = corrupted.GetByIDs(context.Background(), []int{1})
+	assert.Error(t, err)
+}
+
+// the version of the users changes with the content of the file only
+func TestUserRepositoryVersion(t *testing.T) {
+	path := writeDataFile(t, "users.json", `[]`)
+	repo := persistence.NewUserJSONRepository(path)
+
+	version, err := repo.Version(context.Background())
+	require.NoError(t, err)
+	again, err := repo.Version(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, version, again)
+
+	require.NoError(t, os.WriteFile(path, []byte(`[{"id": 1, "name": "Ferdinande", "createdAt": "2020-07-14T05:48:54.798Z"}]`), 0o644))
+	changed, err := repo.Version(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, version, changed)
+
+	// another replica reading the same file agrees on it
+	replica, err := persistence.NewUserJSONRepository(path).Version(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, changed, replica)
+}
+
+func TestJSONRepositoriesPing(t *testing.T) {
+	dir := t.TempDir()
+	actions := persistence.NewActionJSONRepository(writeDataFile(t, "actions.json", `[]`))
+	users := persistence.NewUserJSONRepository(writeDataFile(t, "users.json", `[]`))
+
+	assert.NoError(t, actions.Ping(context.Background()))
+	assert.NoError(t, users.Ping(context.Background()))
+
+	// a missing data file is down, even though it reads as empty
+	assert.ErrorIs(t, persistence.NewActionJSONRepository(filepath.Join(dir, "missing.json")).Ping(context.Background()), os.ErrNotExist)
+	assert.ErrorIs(t, persistence.NewUserJSONRepository(filepath.Join(dir, "missing.json")).Ping(context.Background()), os.ErrNotExist)
+
+	assert.Error(t, persistence.NewActionJSONRepository(dir).Ping(context.Background()))
+
+	// a file that stops parsing is down until it is fixed
+	path := writeDataFile(t, "truncated.json", `[{"id": 0, "type": "WELCOME", "userId": 1, "createdAt": "2021-11-19T17:00:10.202Z"}]`)
+	truncated := persistence.NewActionJSONRepository(path)
+	require.NoError(t, truncated.Ping(context.Background()))
+	require.NoError(t, os.WriteFile(path, []byte(`[{"id": 0, "type": "WELCOME", "userId": 1,`), 0o644))
+	assert.Error(t, truncated.Ping(context.Background()))
+	require.NoError(t, os.WriteFile(path, []byte(`[]`), 0o644))
+	assert.NoError(t, truncated.Ping(context.Background()))
+
+	corrupted := persistence.NewUserJSONRepository(writeDataFile(t, "corrupted.json", `[{"id": "one", "name": "John"}]`))
+	assert.Error(t, corrupted.Ping(context.Background()))
+}
+
+func TestActionRepositoryOutbox(t *testing.T) {
+	path := writeDataFile(t, "actions.json", `[
+		{"id": 0, "type": "WELCOME", "userId": 1, "createdAt": "2021-11-19T17:00:10.202Z"}
+	]`)
+	repo := persistence.NewActionJSONRepository(path)
+
+	// the actions stored before the outbox existed are not pending
+	pending, err := repo.PendingActions(10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	for i := 0; i < 3; i++ {
+		_, err := repo.Save(context.Background(), domain.Action{ID: domain.UnassignedID, Type: "WELCOME", UserID: 2, CreatedAt: time.Now()})
+		require.NoError(t, err)
+	}
+
+	pending, err = repo.PendingActions(2)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, []int{1, 2}, []int{pending[0].ID, pending[1].ID})
+
+	// the cursor only moves past actions dispatched in order, the rest are remembered
+	require.NoError(t, repo.MarkDispatched(2))
+	pending, err = repo.PendingActions(10)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 3}, []int{pending[0].ID, pending[1].ID})
+	require.NoError(t, repo.MarkDispatched(1))
+
+	// the actions left pending survive a restart
+	reopened := persistence.NewActionJSONRepository(path)
+	pending, err = reopened.PendingActions(10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, 3, pending[0].ID)
+
+	require.NoError(t, reopened.MarkDispatched(3))
+	pending, err = persistence.NewActionJSONRepository(path).PendingActions(10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
@@ -0,0 +1,198 @@
+package persistence
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+)
+
+// lastUsedResolution is how stale the persisted last used time of a key may be,
+// so authenticating a request doesn't rewrite the file every time
+const lastUsedResolution = time.Minute
+
+// APIKeyJSONRepository is a repository that keeps API keys in memory, persisting them to a JSON file
+// The file can be shared with other processes, such as --create-api-key: the keys are read again once it changes and
+// every change is applied to the keys stored, under a file lock, so the changes of the others are kept
+type APIKeyJSONRepository struct {
+	filePath string
+	mutex    sync.Mutex
+	keys     []domain.APIKey
+	// loaded is the state of the file the keys were read from, nil when it didn't exist
+	loaded os.FileInfo
+}
+
+// NewAPIKeyJSONRepository creates a new repository loading the JSON file when it exists
+func NewAPIKeyJSONRepository(filePath string) (*APIKeyJSONRepository, error) {
+	r := &APIKeyJSONRepository{filePath: filePath}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Save creates or replaces a key
+func (r *APIKeyJSONRepository) Save(key domain.APIKey) error {
+	return r.update(func() (bool, error) {
+		for i, stored := range r.keys {
+			if stored.ID == key.ID {
+				r.keys[i] = key
+				return true, nil
+			}
+		}
+		r.keys = append(r.keys, key)
+
+		return true, nil
+	})
+}
+
+// GetByID retrieves a key by ID
+func (r *APIKeyJSONRepository) GetByID(id string) (domain.APIKey, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.reload(); err != nil {
+		return domain.APIKey{}, err
+	}
+
+	for _, key := range r.keys {
+		if key.ID == id {
+			return key, nil
+		}
+	}
+
+	return domain.APIKey{}, domain.APIKeyNotFound
+}
+
+// GetAll retrieves every key, revoked and expired ones included
+func (r *APIKeyJSONRepository) GetAll() ([]domain.APIKey, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return append([]domain.APIKey{}, r.keys...), nil
+}
+
+// MarkUsed records the last time the key was used, it is only updated when the
+// stored time is older than lastUsedResolution
+func (r *APIKeyJSONRepository) MarkUsed(id string, at time.Time) error {
+	return r.update(func() (bool, error) {
+		for i, key := range r.keys {
+			if key.ID != id {
+				continue
+			}
+
+			if key.LastUsedAt != nil && at.Sub(*key.LastUsedAt) < lastUsedResolution {
+				return false, nil
+			}
+			r.keys[i].LastUsedAt = &at
+			return true, nil
+		}
+
+		return false, domain.APIKeyNotFound
+	})
+}
+
+// Revoke records the key was revoked at, a revoked key keeps the time it was first revoked
+// The key is updated under the lock, so a concurrent MarkUsed doesn't undo the revocation
+func (r *APIKeyJSONRepository) Revoke(id string, at time.Time) (domain.APIKey, error) {
+	var revoked domain.APIKey
+	err := r.update(func() (bool, error) {
+		for i, key := range r.keys {
+			if key.ID != id {
+				continue
+			}
+
+			if key.RevokedAt != nil {
+				revoked = key
+				return false, nil
+			}
+			r.keys[i].RevokedAt = &at
+			revoked = r.keys[i]
+			return true, nil
+		}
+
+		return false, domain.APIKeyNotFound
+	})
+	if err != nil {
+		return domain.APIKey{}, err
+	}
+
+	return revoked, nil
+}
+
+// update applies fn to the keys stored, read again under the file lock, persisting them when fn changed them
+func (r *APIKeyJSONRepository) update(fn func() (bool, error)) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	unlock, err := lockFile(r.filePath + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := r.reload(); err != nil {
+		return err
+	}
+
+	changed, err := fn()
+	if err != nil || !changed {
+		return err
+	}
+
+	return r.persist()
+}
+
+// reload reads the keys again when the file changed since they were read, a missing file has no keys
+func (r *APIKeyJSONRepository) reload() error {
+	info, err := os.Stat(r.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		r.keys, r.loaded = nil, nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if r.loaded != nil && sameFileState(r.loaded, info) {
+		return nil
+	}
+
+	data, err := os.ReadFile(r.filePath)
+	if err != nil {
+		return err
+	}
+
+	var keys []domain.APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("reading api keys: %w", err)
+	}
+	r.keys, r.loaded = keys, info
+
+	return nil
+}
+
+func (r *APIKeyJSONRepository) persist() error {
+	if err := writeJSONFile(r.filePath, r.keys); err != nil {
+		return err
+	}
+
+	// the keys written are the ones in memory, the file is only read again once someone else changes it
+	r.loaded, _ = os.Stat(r.filePath)
+	return nil
+}
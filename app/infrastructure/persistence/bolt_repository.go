@@ -0,0 +1,394 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// usersBucket user ID -> user
+	usersBucket = []byte("users")
+	// actionsBucket user ID -> nested bucket of createdAt+action ID -> action, so a cursor walks a user timeline
+	actionsBucket = []byte("actions")
+	// actionIDsBucket action ID -> user ID, the primary index used to reject duplicates
+	actionIDsBucket = []byte("action_ids")
+	// actionsByTypeBucket type+createdAt+action ID -> user ID, the secondary index by type and time
+	actionsByTypeBucket = []byte("actions_by_type")
+	// actionCountsBucket user ID -> number of actions
+	actionCountsBucket = []byte("action_counts")
+	// outboxBucket action ID -> action recorded but not yet dispatched, written in the same transaction as the action
+	outboxBucket = []byte("outbox")
+	// metaBucket holds bookkeeping values
+	metaBucket    = []byte("meta")
+	nextActionKey = []byte("next_action_id")
+
+	allBuckets = [][]byte{usersBucket, actionsBucket, actionIDsBucket, actionsByTypeBucket, actionCountsBucket, outboxBucket, metaBucket}
+)
+
+const boltOpenTimeout = 5 * time.Second
+
+// BoltRepository is a repository that stores users and actions in an embedded B+tree database file
+type BoltRepository struct {
+	db *bolt.DB
+}
+
+// NewBoltRepository opens the database file at path, creating it and its buckets when needed
+func NewBoltRepository(path string) (*BoltRepository, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("opening bolt database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range allBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating bolt buckets: %w", err)
+	}
+
+	return &BoltRepository{db: db}, nil
+}
+
+// GetByID retrieves a user by ID
+func (r *BoltRepository) GetByID(ctx context.Context, id int) (domain.User, error) {
+	var user domain.User
+	err := r.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(usersBucket).Get(itob(id))
+		if data == nil {
+			return domain.UserNotFound
+		}
+		return json.Unmarshal(data, &user)
+	})
+
+	return user, err
+}
+
+// GetByIDs retrieves the users with the ids within a single transaction
+func (r *BoltRepository) GetByIDs(ctx context.Context, ids []int) (map[int]domain.User, error) {
+	users := make(map[int]domain.User, len(ids))
+	err := r.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		for _, id := range ids {
+			data := bucket.Get(itob(id))
+			if data == nil {
+				continue
+			}
+
+			var user domain.User
+			if err := json.Unmarshal(data, &user); err != nil {
+				return err
+			}
+			users[id] = user
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// CountByUserID returns the count of actions for a given user ID
+func (r *BoltRepository) CountByUserID(ctx context.Context, userID int) (int, error) {
+	count := 0
+	err := r.db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(actionCountsBucket).Get(itob(userID)); data != nil {
+			count = btoi(data)
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// GetNextActionProbabilities calculates the probabilities of next actions after a given action type
+// The type index gives every action of that type, the next action is found seeking the user timeline
+func (r *BoltRepository) GetNextActionProbabilities(ctx context.Context, actionType string) (map[string]float64, error) {
+	transitionCounts := make(map[string]int)
+	totalTransitions := 0
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		actions := tx.Bucket(actionsBucket)
+		prefix := typePrefix(actionType)
+
+		c := tx.Bucket(actionsByTypeBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			timeline := actions.Bucket(v)
+			if timeline == nil {
+				continue
+			}
+
+			tc := timeline.Cursor()
+			if found, _ := tc.Seek(k[len(prefix):]); found == nil {
+				continue
+			}
+
+			_, data := tc.Next()
+			if data == nil {
+				continue
+			}
+
+			var next domain.Action
+			if err := json.Unmarshal(data, &next); err != nil {
+				return err
+			}
+			transitionCounts[next.Type]++
+			totalTransitions++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transitionProbabilities(transitionCounts, totalTransitions), nil
+}
+
+// GetAll retrieves all actions grouped by user in creation order
+func (r *BoltRepository) GetAll(ctx context.Context) ([]domain.Action, error) {
+	actions := []domain.Action{}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(actionsBucket).ForEachBucket(func(userKey []byte) error {
+			return tx.Bucket(actionsBucket).Bucket(userKey).ForEach(func(_, data []byte) error {
+				var action domain.Action
+				if err := json.Unmarshal(data, &action); err != nil {
+					return err
+				}
+				actions = append(actions, action)
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
+
+// Save stores the action, its index entries and its outbox entry in a single transaction
+func (r *BoltRepository) Save(ctx context.Context, action domain.Action) (domain.Action, error) {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		action, err = putAction(tx, action)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(action)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(outboxBucket).Put(itob(action.ID), data)
+	})
+	if err != nil {
+		return domain.Action{}, err
+	}
+
+	return action, nil
+}
+
+// PendingActions returns the saved actions not dispatched yet, in ID order
+func (r *BoltRepository) PendingActions(limit int) ([]domain.Action, error) {
+	actions := []domain.Action{}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(outboxBucket).Cursor()
+		for k, data := c.First(); k != nil && len(actions) < limit; k, data = c.Next() {
+			var action domain.Action
+			if err := json.Unmarshal(data, &action); err != nil {
+				return err
+			}
+			actions = append(actions, action)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
+
+// MarkDispatched removes the actions from the outbox
+func (r *BoltRepository) MarkDispatched(actionIDs ...int) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		outbox := tx.Bucket(outboxBucket)
+		for _, id := range actionIDs {
+			if err := outbox.Delete(itob(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Import stores a batch of actions in a single transaction, intended to seed an empty database
+// Imported actions are not added to the outbox
+func (r *BoltRepository) Import(actions []domain.Action) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return putActions(tx, actions)
+	})
+}
+
+// ImportUsers stores a batch of users in a single transaction, replacing existing ones with the same ID
+func (r *BoltRepository) ImportUsers(users []domain.User) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return putUsers(tx, users)
+	})
+}
+
+// Seed stores the users and actions in a single transaction, so a failure leaves the database empty
+// and the seed is imported again the next time it is opened
+func (r *BoltRepository) Seed(users []domain.User, actions []domain.Action) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		if err := putUsers(tx, users); err != nil {
+			return err
+		}
+		return putActions(tx, actions)
+	})
+}
+
+// IsEmpty reports whether the database holds neither users nor actions
+func (r *BoltRepository) IsEmpty() (bool, error) {
+	empty := true
+	err := r.db.View(func(tx *bolt.Tx) error {
+		users, _ := tx.Bucket(usersBucket).Cursor().First()
+		ids, _ := tx.Bucket(actionIDsBucket).Cursor().First()
+		empty = users == nil && ids == nil
+		return nil
+	})
+
+	return empty, err
+}
+
+// Ping opens a read transaction, failing once the database is closed
+func (r *BoltRepository) Ping(context.Context) error {
+	return r.db.View(func(*bolt.Tx) error {
+		return nil
+	})
+}
+
+// Close closes the database file
+func (r *BoltRepository) Close() error {
+	return r.db.Close()
+}
+
+func putUsers(tx *bolt.Tx, users []domain.User) error {
+	bucket := tx.Bucket(usersBucket)
+	for _, user := range users {
+		if err := user.Validate(); err != nil {
+			return fmt.Errorf("importing user %d: %w", user.ID, err)
+		}
+
+		data, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(itob(user.ID), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putActions(tx *bolt.Tx, actions []domain.Action) error {
+	for _, action := range actions {
+		if _, err := putAction(tx, action); err != nil {
+			return fmt.Errorf("importing action %d: %w", action.ID, err)
+		}
+	}
+	return nil
+}
+
+// putAction writes the action, its timeline entry and index entries within tx
+func putAction(tx *bolt.Tx, action domain.Action) (domain.Action, error) {
+	meta := tx.Bucket(metaBucket)
+	nextID := 0
+	if data := meta.Get(nextActionKey); data != nil {
+		nextID = btoi(data)
+	}
+
+	if action.ID == domain.UnassignedID {
+		action.ID = nextID
+	}
+
+	ids := tx.Bucket(actionIDsBucket)
+	if ids.Get(itob(action.ID)) != nil {
+		return domain.Action{}, domain.ActionAlreadyExists
+	}
+
+	if err := action.Validate(); err != nil {
+		return domain.Action{}, err
+	}
+
+	data, err := json.Marshal(action)
+	if err != nil {
+		return domain.Action{}, err
+	}
+
+	userKey := itob(action.UserID)
+	timeline, err := tx.Bucket(actionsBucket).CreateBucketIfNotExists(userKey)
+	if err != nil {
+		return domain.Action{}, err
+	}
+
+	key := timelineKey(action)
+	if err := timeline.Put(key, data); err != nil {
+		return domain.Action{}, err
+	}
+	if err := ids.Put(itob(action.ID), userKey); err != nil {
+		return domain.Action{}, err
+	}
+	if err := tx.Bucket(actionsByTypeBucket).Put(append(typePrefix(action.Type), key...), userKey); err != nil {
+		return domain.Action{}, err
+	}
+
+	counts := tx.Bucket(actionCountsBucket)
+	count := 0
+	if data := counts.Get(userKey); data != nil {
+		count = btoi(data)
+	}
+	if err := counts.Put(userKey, itob(count+1)); err != nil {
+		return domain.Action{}, err
+	}
+
+	return action, meta.Put(nextActionKey, itob(max(nextID, action.ID+1)))
+}
+
+// timelineKey orders actions by creation time, the ID keeps keys unique for actions created at the same time
+func timelineKey(action domain.Action) []byte {
+	key := make([]byte, 16)
+	// flipping the sign bit keeps times before the epoch ordered before the ones after it
+	binary.BigEndian.PutUint64(key[0:8], uint64(action.CreatedAt.UnixNano())^(1<<63))
+	binary.BigEndian.PutUint64(key[8:16], uint64(action.ID))
+	return key
+}
+
+// typePrefix is the prefix of every type index entry of actionType
+func typePrefix(actionType string) []byte {
+	return append([]byte(actionType), 0)
+}
+
+func itob(v int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func btoi(b []byte) int {
+	return int(binary.BigEndian.Uint64(b))
+}
@@ -0,0 +1,144 @@
+package persistence_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+	"github.com/JoseBeteta/surfe/app/infrastructure/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltRepository(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "surfe.db")
+
+	repo, err := persistence.NewBoltRepository(path)
+	require.NoError(t, err)
+
+	empty, err := repo.IsEmpty()
+	require.NoError(t, err)
+	assert.True(t, empty)
+
+	require.NoError(t, repo.ImportUsers([]domain.User{
+		{ID: 1, Name: "Ferdinande", CreatedAt: time.Date(2020, time.July, 14, 5, 48, 54, 0, time.UTC)},
+	}))
+
+	// imported out of order, the timeline is kept by creation time
+	require.NoError(t, repo.Import([]domain.Action{
+		newAction(2, 1, "EDIT_CONTACT"),
+		newAction(0, 1, "WELCOME"),
+		newAction(1, 1, "ADD_CONTACT"),
+		newAction(3, 2, "WELCOME"),
+		newAction(4, 2, "ADD_CONTACT"),
+	}))
+
+	stored, err := repo.Save(context.Background(), unassigned(newAction(5, 1, "ADD_CONTACT")))
+	require.NoError(t, err)
+	assert.Equal(t, 5, stored.ID)
+
+	_, err = repo.Save(context.Background(), newAction(3, 1, "WELCOME"))
+	assert.ErrorIs(t, err, domain.ActionAlreadyExists)
+
+	require.NoError(t, repo.Ping(context.Background()))
+	require.NoError(t, repo.Close())
+	assert.Error(t, repo.Ping(context.Background()))
+
+	repo, err = persistence.NewBoltRepository(path)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	user, err := repo.GetByID(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Ferdinande", user.Name)
+
+	_, err = repo.GetByID(context.Background(), 2)
+	assert.ErrorIs(t, err, domain.UserNotFound)
+
+	users, err := repo.GetByIDs(context.Background(), []int{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]domain.User{1: user}, users)
+
+	count, err := repo.CountByUserID(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	count, err = repo.CountByUserID(context.Background(), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	actions, err := repo.GetAll(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, actions, 6)
+
+	// matches the probabilities computed by sorting every action
+	expected, err := persistence.NewActionLogRepository(t.TempDir(), 0, discardLogger)
+	require.NoError(t, err)
+	defer expected.Close()
+	require.NoError(t, expected.Import(actions))
+
+	for _, actionType := range []string{"WELCOME", "ADD_CONTACT", "EDIT_CONTACT"} {
+		probabilities, err := repo.GetNextActionProbabilities(context.Background(), actionType)
+		assert.NoError(t, err)
+
+		expectedProbabilities, err := expected.GetNextActionProbabilities(context.Background(), actionType)
+		require.NoError(t, err)
+		assert.Equal(t, expectedProbabilities, probabilities, actionType)
+	}
+
+	probabilities, err := repo.GetNextActionProbabilities(context.Background(), "WELCOME")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"ADD_CONTACT": 1}, probabilities)
+}
+
+func TestBoltRepositoryOutbox(t *testing.T) {
+	repo, err := persistence.NewBoltRepository(filepath.Join(t.TempDir(), "surfe.db"))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	// imported actions are not dispatched
+	require.NoError(t, repo.Import([]domain.Action{newAction(0, 1, "WELCOME")}))
+
+	_, err = repo.Save(context.Background(), newAction(1, 1, "ADD_CONTACT"))
+	require.NoError(t, err)
+	_, err = repo.Save(context.Background(), newAction(2, 1, "EDIT_CONTACT"))
+	require.NoError(t, err)
+
+	pending, err := repo.PendingActions(10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, 1, pending[0].ID)
+	assert.Equal(t, 2, pending[1].ID)
+
+	require.NoError(t, repo.MarkDispatched(1))
+
+	pending, err = repo.PendingActions(10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, 2, pending[0].ID)
+}
+
+func TestBoltRepositorySeedIsAtomic(t *testing.T) {
+	repo, err := persistence.NewBoltRepository(filepath.Join(t.TempDir(), "surfe.db"))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	// the duplicated action rolls the users back with it
+	err = repo.Seed([]domain.User{{ID: 1, Name: "Ferdinande", CreatedAt: time.Now()}}, []domain.Action{newAction(0, 1, "WELCOME"), newAction(0, 1, "WELCOME")})
+	require.ErrorIs(t, err, domain.ActionAlreadyExists)
+
+	empty, err := repo.IsEmpty()
+	require.NoError(t, err)
+	assert.True(t, empty)
+
+	require.NoError(t, repo.Seed([]domain.User{{ID: 1, Name: "Ferdinande", CreatedAt: time.Now()}}, []domain.Action{newAction(0, 1, "WELCOME")}))
+
+	user, err := repo.GetByID(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, "Ferdinande", user.Name)
+	count, err := repo.CountByUserID(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,72 @@
+package persistence
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotJSONArray is returned when a data file does not hold a top-level JSON array
+var ErrNotJSONArray = errors.New("expected a top-level JSON array")
+
+// errStopIteration is returned from a record callback to stop decoding early
+var errStopIteration = errors.New("stop iteration")
+
+// DecodeError describes a malformed record found while streaming a data file
+type DecodeError struct {
+	Index  int
+	Offset int64
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("malformed record at index %d (byte offset %d): %v", e.Index, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeJSONArray decodes the top-level JSON array from r one record at a time,
+// so memory usage is bounded by the records kept by fn instead of the file size
+func decodeJSONArray[T any](r io.Reader, validate func(T) error, fn func(T) error) error {
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err == io.EOF {
+		// an empty file holds no records
+		return nil
+	}
+	if err != nil {
+		return &DecodeError{Index: 0, Offset: dec.InputOffset(), Err: err}
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return &DecodeError{Index: 0, Offset: 0, Err: ErrNotJSONArray}
+	}
+
+	index := 0
+	for ; dec.More(); index++ {
+		offset := dec.InputOffset()
+
+		var record T
+		if err := dec.Decode(&record); err != nil {
+			return &DecodeError{Index: index, Offset: offset, Err: err}
+		}
+
+		if err := validate(record); err != nil {
+			return &DecodeError{Index: index, Offset: offset, Err: err}
+		}
+
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	// consume the closing bracket so truncated files are reported
+	if _, err := dec.Token(); err != nil {
+		return &DecodeError{Index: index, Offset: dec.InputOffset(), Err: err}
+	}
+
+	return nil
+}
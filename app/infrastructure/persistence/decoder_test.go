@@ -0,0 +1,109 @@
+package persistence_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+	"github.com/JoseBeteta/surfe/app/infrastructure/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeDataFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	return path
+}
+
+func TestActionRepositoryStreamsRecords(t *testing.T) {
+	path := writeDataFile(t, "actions.json", `[
+  {"id": 0, "type": "WELCOME", "userId": 1, "createdAt": "2021-11-19T17:00:10.202Z"},
+  {"id": 1, "type": "REFER_USER", "userId": 1, "targetUser": 2, "createdAt": "2021-11-19T18:35:42.629Z"},
+  {"id": 2, "type": "WELCOME", "userId": 2, "createdAt": "2021-11-20T18:35:42.629Z"}
+]`)
+
+	repo := persistence.NewActionJSONRepository(path)
+
+	actions, err := repo.GetAll(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, actions, 3)
+	assert.Equal(t, 2, actions[1].TargetUser)
+
+	count, err := repo.CountByUserID(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestActionRepositoryReportsMalformedRecord(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		expectedIndex int
+		expectedErr   error
+	}{
+		{
+			"syntax error",
+			`[{"id": 0, "type": "WELCOME", "userId": 1, "createdAt": "2021-11-19T17:00:10.202Z"}, {"id": 1,, }]`,
+			1,
+			nil,
+		},
+		{
+			"invalid record",
+			`[{"id": 0, "type": "WELCOME", "userId": 1, "createdAt": "2021-11-19T17:00:10.202Z"}, {"id": 1, "userId": 1, "createdAt": "2021-11-19T17:00:10.202Z"}]`,
+			1,
+			domain.InvalidArgument,
+		},
+		{
+			"truncated file",
+			`[{"id": 0, "type": "WELCOME", "userId": 1, "createdAt": "2021-11-19T17:00:10.202Z"}`,
+			1,
+			nil,
+		},
+		{
+			"not an array",
+			`{"id": 0}`,
+			0,
+			persistence.ErrNotJSONArray,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := persistence.NewActionJSONRepository(writeDataFile(t, "actions.json", tt.content))
+
+			_, err := repo.GetAll(context.Background())
+
+			var decodeErr *persistence.DecodeError
+			require.True(t, errors.As(err, &decodeErr), "expected a decode error, got %v", err)
+			assert.Equal(t, tt.expectedIndex, decodeErr.Index)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+			}
+			if tt.expectedIndex > 0 {
+				assert.Greater(t, decodeErr.Offset, int64(0))
+			}
+		})
+	}
+}
+
+func TestUserRepositoryStopsAtFirstMatch(t *testing.T) {
+	// the record after the match is malformed, it must never be decoded
+	path := writeDataFile(t, "users.json", `[
+  {"id": 1, "name": "Ferdinande", "createdAt": "2020-07-14T05:48:54.798Z"},
+  {"id": 2,
+`)
+
+	user, err := persistence.NewUserJSONRepository(path).GetByID(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Ferdinande", user.Name)
+
+	_, err = persistence.NewUserJSONRepository(path).GetByID(context.Background(), 3)
+	assert.Error(t, err)
+}
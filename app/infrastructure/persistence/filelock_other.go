@@ -0,0 +1,8 @@
+//go:build !unix
+
+package persistence
+
+// lockFile doesn't lock files on platforms without flock, only the repository mutex guards them
+func lockFile(string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package persistence
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on the file at path, creating it, waiting for other processes holding it
+// The lock is released calling the function returned
+func lockFile(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, dataFileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// closing the file releases the lock
+	return func() { file.Close() }, nil
+}
@@ -0,0 +1,327 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is the encoding of a data file
+type Format string
+
+const (
+	// FormatJSON a top-level JSON array of records
+	FormatJSON Format = "json"
+	// FormatNDJSON one JSON record per line
+	FormatNDJSON Format = "ndjson"
+	// FormatCSV a header row followed by one record per row
+	FormatCSV Format = "csv"
+)
+
+const gzipExtension = ".gz"
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ErrMissingCSVColumn is returned when a CSV header lacks a mapped column
+var ErrMissingCSVColumn = errors.New("missing CSV column")
+
+// CSVMapping maps record fields (e.g. "userId") to the CSV header column holding them
+type CSVMapping map[string]string
+
+// DefaultActionCSVMapping the column mapping used for action CSV files unless overridden
+func DefaultActionCSVMapping() CSVMapping {
+	return CSVMapping{
+		"id":         "id",
+		"type":       "type",
+		"userId":     "userId",
+		"targetUser": "targetUser",
+		"createdAt":  "createdAt",
+	}
+}
+
+// DefaultUserCSVMapping the column mapping used for user CSV files unless overridden
+func DefaultUserCSVMapping() CSVMapping {
+	return CSVMapping{
+		"id":        "id",
+		"name":      "name",
+		"createdAt": "createdAt",
+	}
+}
+
+// ParseCSVMapping parses a mapping in the form "userId=user_id,createdAt=ts"
+func ParseCSVMapping(s string) (CSVMapping, error) {
+	mapping := CSVMapping{}
+	if strings.TrimSpace(s) == "" {
+		return mapping, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		field, column, ok := strings.Cut(pair, "=")
+		field, column = strings.TrimSpace(field), strings.TrimSpace(column)
+		if !ok || field == "" || column == "" {
+			return nil, fmt.Errorf("invalid CSV column mapping %q, expected field=column", pair)
+		}
+		mapping[field] = column
+	}
+
+	return mapping, nil
+}
+
+// merge returns a copy of m with the fields defined in overrides replaced
+func (m CSVMapping) merge(overrides CSVMapping) CSVMapping {
+	merged := CSVMapping{}
+	for field, column := range m {
+		merged[field] = column
+	}
+	for field, column := range overrides {
+		merged[field] = column
+	}
+	return merged
+}
+
+// csvRecord gives access to the values of a CSV row by record field
+type csvRecord struct {
+	row     []string
+	columns map[string]int
+}
+
+// get returns the raw value of field, empty when the column is not present
+func (r csvRecord) get(field string) string {
+	i, ok := r.columns[field]
+	if !ok || i >= len(r.row) {
+		return ""
+	}
+	return strings.TrimSpace(r.row[i])
+}
+
+func (r csvRecord) int(field string) (int, error) {
+	value, err := strconv.Atoi(r.get(field))
+	if err != nil {
+		return 0, fmt.Errorf("field %q: %w", field, err)
+	}
+	return value, nil
+}
+
+// optionalInt parses field allowing it to be empty
+func (r csvRecord) optionalInt(field string) (int, error) {
+	if r.get(field) == "" {
+		return 0, nil
+	}
+	return r.int(field)
+}
+
+func (r csvRecord) time(field string) (time.Time, error) {
+	value, err := time.Parse(time.RFC3339Nano, r.get(field))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("field %q: %w", field, err)
+	}
+	return value, nil
+}
+
+// recordCodec describes how a record type is validated and read from CSV rows
+type recordCodec[T any] struct {
+	validate func(T) error
+	mapping  CSVMapping
+	// required lists the fields that must have a column in the CSV header
+	required []string
+	fromCSV  func(csvRecord) (T, error)
+}
+
+// dataFile is an opened data file with compression already handled
+type dataFile struct {
+	io.Reader
+	format  Format
+	closers []io.Closer
+}
+
+func (f *dataFile) Close() error {
+	var errs []error
+	for i := len(f.closers) - 1; i >= 0; i-- {
+		errs = append(errs, f.closers[i].Close())
+	}
+	return errors.Join(errs...)
+}
+
+// openDataFile opens the file at path, transparently decompressing gzip, and detects
+// its format from the extension or, when the extension is unknown, from its content
+func openDataFile(path string) (*dataFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	df := &dataFile{closers: []io.Closer{file}}
+	reader := bufio.NewReader(file)
+
+	name := strings.ToLower(path)
+	magic, _ := reader.Peek(len(gzipMagic))
+	if strings.HasSuffix(name, gzipExtension) || bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			df.Close()
+			return nil, fmt.Errorf("opening gzip data file: %w", err)
+		}
+		df.closers = append(df.closers, gz)
+		name = strings.TrimSuffix(name, gzipExtension)
+		reader = bufio.NewReader(gz)
+	}
+
+	df.Reader = reader
+	df.format = formatFromExtension(name)
+	if df.format == "" {
+		df.format = sniffFormat(reader)
+	}
+
+	return df, nil
+}
+
+func formatFromExtension(name string) Format {
+	switch filepath.Ext(name) {
+	case ".json":
+		return FormatJSON
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	case ".csv":
+		return FormatCSV
+	}
+	return ""
+}
+
+// sniffFormat guesses the format from the first non blank byte of the content
+func sniffFormat(reader *bufio.Reader) Format {
+	for n := 1; ; n++ {
+		peeked, err := reader.Peek(n)
+		if len(peeked) < n {
+			// empty content, any decoder will report no records
+			return FormatJSON
+		}
+
+		switch peeked[n-1] {
+		case ' ', '\t', '\r', '\n':
+			if err != nil {
+				return FormatJSON
+			}
+			continue
+		case '[':
+			return FormatJSON
+		case '{':
+			return FormatNDJSON
+		default:
+			return FormatCSV
+		}
+	}
+}
+
+// decodeFile streams every record stored in the file at path into fn
+func decodeFile[T any](path string, codec recordCodec[T], fn func(T) error) error {
+	file, err := openDataFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	switch file.format {
+	case FormatNDJSON:
+		return decodeNDJSON(file, codec.validate, fn)
+	case FormatCSV:
+		return decodeCSV(file, codec, fn)
+	default:
+		return decodeJSONArray(file, codec.validate, fn)
+	}
+}
+
+// decodeNDJSON decodes one JSON record per line from r
+func decodeNDJSON[T any](r io.Reader, validate func(T) error, fn func(T) error) error {
+	dec := json.NewDecoder(r)
+
+	for index := 0; ; index++ {
+		offset := dec.InputOffset()
+
+		var record T
+		err := dec.Decode(&record)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &DecodeError{Index: index, Offset: offset, Err: err}
+		}
+
+		if err := validate(record); err != nil {
+			return &DecodeError{Index: index, Offset: offset, Err: err}
+		}
+
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeCSV decodes the rows following the header row from r using the codec column mapping
+func decodeCSV[T any](r io.Reader, codec recordCodec[T], fn func(T) error) error {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return &DecodeError{Index: 0, Offset: 0, Err: err}
+	}
+
+	positions := make(map[string]int, len(header))
+	for i, column := range header {
+		positions[strings.TrimSpace(column)] = i
+	}
+
+	columns := make(map[string]int, len(codec.mapping))
+	for field, column := range codec.mapping {
+		if i, ok := positions[column]; ok {
+			columns[field] = i
+		}
+	}
+	for _, field := range codec.required {
+		if _, ok := columns[field]; !ok {
+			return &DecodeError{
+				Index:  0,
+				Offset: 0,
+				Err:    fmt.Errorf("%w %q for field %q", ErrMissingCSVColumn, codec.mapping[field], field),
+			}
+		}
+	}
+
+	for index := 0; ; index++ {
+		offset := reader.InputOffset()
+
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &DecodeError{Index: index, Offset: offset, Err: err}
+		}
+
+		record, err := codec.fromCSV(csvRecord{row: row, columns: columns})
+		if err != nil {
+			return &DecodeError{Index: index, Offset: offset, Err: err}
+		}
+
+		if err := codec.validate(record); err != nil {
+			return &DecodeError{Index: index, Offset: offset, Err: err}
+		}
+
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package persistence_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/JoseBeteta/surfe/app/infrastructure/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const ndjsonActions = `{"id": 0, "type": "WELCOME", "userId": 1, "createdAt": "2021-11-19T17:00:10.202Z"}
+{"id": 1, "type": "REFER_USER", "userId": 1, "targetUser": 2, "createdAt": "2021-11-19T18:35:42.629Z"}
+`
+
+const csvActions = `id,type,userId,targetUser,createdAt
+0,WELCOME,1,,2021-11-19T17:00:10.202Z
+1,REFER_USER,1,2,2021-11-19T18:35:42.629Z
+`
+
+func gzipped(t *testing.T, content string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return buf.String()
+}
+
+func TestActionRepositoryFormats(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		content  string
+	}{
+		{"ndjson by extension", "actions.ndjson", ndjsonActions},
+		{"csv by extension", "actions.csv", csvActions},
+		{"gzip ndjson by extension", "actions.ndjson.gz", gzipped(t, ndjsonActions)},
+		{"gzip csv by extension", "actions.csv.gz", gzipped(t, csvActions)},
+		{"ndjson by content", "actions.data", ndjsonActions},
+		{"csv by content", "actions.data", csvActions},
+		{"gzip by magic bytes", "actions.export", gzipped(t, ndjsonActions)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := persistence.NewActionJSONRepository(writeDataFile(t, tt.fileName, tt.content))
+
+			actions, err := repo.GetAll(context.Background())
+			require.NoError(t, err)
+			require.Len(t, actions, 2)
+			assert.Equal(t, "WELCOME", actions[0].Type)
+			assert.Equal(t, 0, actions[0].TargetUser)
+			assert.Equal(t, "REFER_USER", actions[1].Type)
+			assert.Equal(t, 2, actions[1].TargetUser)
+		})
+	}
+}
+
+func TestActionRepositoryCSVMapping(t *testing.T) {
+	content := `action_id,user_id,action_type,ts
+7,3,ADD_CONTACT,2021-11-19T17:00:10.202Z
+`
+	mapping, err := persistence.ParseCSVMapping("id=action_id, userId=user_id, type=action_type, createdAt=ts")
+	require.NoError(t, err)
+
+	repo := persistence.NewActionJSONRepository(writeDataFile(t, "actions.csv", content)).
+		WithCSVMapping(mapping)
+
+	actions, err := repo.GetAll(context.Background())
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+	assert.Equal(t, 7, actions[0].ID)
+	assert.Equal(t, 3, actions[0].UserID)
+	assert.Equal(t, "ADD_CONTACT", actions[0].Type)
+
+	_, err = persistence.NewActionJSONRepository(writeDataFile(t, "actions.csv", content)).GetAll(context.Background())
+	assert.True(t, errors.Is(err, persistence.ErrMissingCSVColumn))
+
+	_, err = persistence.ParseCSVMapping("id")
+	assert.Error(t, err)
+}
+
+func TestUserRepositoryCSV(t *testing.T) {
+	content := `id,name,createdAt
+1,Ferdinande,2020-07-14T05:48:54.798Z
+2,Amelie,not-a-date
+`
+	repo := persistence.NewUserJSONRepository(writeDataFile(t, "users.csv", content))
+
+	user, err := repo.GetByID(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, "Ferdinande", user.Name)
+
+	_, err = repo.GetByID(context.Background(), 2)
+	var decodeErr *persistence.DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	assert.Equal(t, 1, decodeErr.Index)
+}
@@ -0,0 +1,84 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// fileCheck verifies a data file is readable and every record in it parses, decoding it again only once it changes
+// The file is decoded in the background, a check waits for it up to its deadline and reports the file down meanwhile
+type fileCheck struct {
+	mutex sync.Mutex
+	// checked is the state of the file err was found for, nil before the first decode finishes
+	checked os.FileInfo
+	err     error
+	// decoding is closed once the decode in progress finishes, nil when there is none
+	decoding chan struct{}
+}
+
+// check returns the result of decoding the file at path as it is, decode streams every record without keeping them
+func (f *fileCheck) check(ctx context.Context, path string, decode func() error) error {
+	info, err := statDataFile(path)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	if f.decoding == nil && f.checked != nil && sameFileState(f.checked, info) {
+		err := f.err
+		f.mutex.Unlock()
+		return err
+	}
+	if f.decoding == nil {
+		f.decoding = make(chan struct{})
+		go f.decode(info, decode, f.decoding)
+	}
+	decoding := f.decoding
+	f.mutex.Unlock()
+
+	select {
+	case <-decoding:
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		return f.err
+	case <-ctx.Done():
+		return fmt.Errorf("still parsing %s: %w", path, ctx.Err())
+	}
+}
+
+func (f *fileCheck) decode(info os.FileInfo, decode func() error, done chan struct{}) {
+	err := decode()
+
+	f.mutex.Lock()
+	f.checked, f.err, f.decoding = info, err, nil
+	f.mutex.Unlock()
+	close(done)
+}
+
+// statDataFile opens the data file without reading it, failing when it is missing or is not a regular file
+// The repositories don't lock it, as files are replaced atomically
+func statDataFile(path string) (os.FileInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	return info, nil
+}
+
+// sameFileState reports whether both describe the same file unchanged
+// Files are replaced when written, so a new file is told apart even within the resolution of modification times
+func sameFileState(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
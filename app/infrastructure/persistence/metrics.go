@@ -0,0 +1,50 @@
+package persistence
+
+import (
+	"time"
+)
+
+// MetricsAgent receives the metrics of the data files loaded
+type MetricsAgent interface {
+	Timing(name string, duration time.Duration, tags []string)
+	Count(name string, delta float64, tags []string)
+	Gauge(name string, value float64, tags []string)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Timing(string, time.Duration, []string) {}
+func (nopMetrics) Count(string, float64, []string)        {}
+func (nopMetrics) Gauge(string, float64, []string)        {}
+
+// fileMeter records how long decoding a data file takes and how many records it holds
+// Records are streamed through it, nothing is kept in memory
+type fileMeter[T any] struct {
+	tags    []string
+	metrics MetricsAgent
+}
+
+func newFileMeter[T any](repository string) *fileMeter[T] {
+	return &fileMeter[T]{
+		tags:    []string{"repository:" + repository},
+		metrics: nopMetrics{},
+	}
+}
+
+// decode streams the records into fn, recording the load once the whole file has been decoded
+// Reads stopped early or failing are not recorded, they don't tell the size of the file
+func (m *fileMeter[T]) decode(decode func(fn func(T) error) error, fn func(T) error) error {
+	start := time.Now()
+	records := 0
+	err := decode(func(record T) error {
+		records++
+		return fn(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.metrics.Timing("repository_load", time.Since(start), m.tags)
+	m.metrics.Gauge("repository_records_loaded", float64(records), m.tags)
+	return nil
+}
@@ -0,0 +1,80 @@
+package persistence_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+	"github.com/JoseBeteta/surfe/app/infrastructure/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// metricsRecorder records the counters and gauges received, and how many timings
+type metricsRecorder struct {
+	timings map[string]int
+	counts  map[string]float64
+	gauges  map[string]float64
+}
+
+func newMetricsRecorder() *metricsRecorder {
+	return &metricsRecorder{timings: map[string]int{}, counts: map[string]float64{}, gauges: map[string]float64{}}
+}
+
+func (m *metricsRecorder) Timing(name string, _ time.Duration, _ []string) { m.timings[name]++ }
+func (m *metricsRecorder) Count(name string, delta float64, _ []string)    { m.counts[name] += delta }
+func (m *metricsRecorder) Gauge(name string, value float64, _ []string)    { m.gauges[name] = value }
+
+func TestActionRepositoryMetersDataFileLoads(t *testing.T) {
+	metrics := newMetricsRecorder()
+	path := writeDataFile(t, "actions.json", `[
+		{"id": 0, "type": "WELCOME", "userId": 1, "createdAt": "2021-11-19T17:00:10.202Z"}
+	]`)
+	repo := persistence.NewActionJSONRepository(path).WithMetrics(metrics)
+
+	_, err := repo.GetAll(context.Background())
+	require.NoError(t, err)
+	count, err := repo.CountByUserID(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// every read streams the file again, nothing is kept in memory
+	assert.Equal(t, 2, metrics.timings["repository_load"])
+	assert.Equal(t, float64(1), metrics.gauges["repository_records_loaded"])
+
+	_, err = repo.Save(context.Background(), domain.Action{ID: domain.UnassignedID, Type: "ADD_CONTACT", UserID: 1, CreatedAt: time.Now()})
+	require.NoError(t, err)
+	count, err = repo.CountByUserID(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, float64(2), metrics.gauges["repository_records_loaded"])
+
+	// and so does a file changed by someone else
+	require.NoError(t, os.WriteFile(path, []byte(`[]`), 0o644))
+	count, err = repo.CountByUserID(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	assert.Zero(t, metrics.gauges["repository_records_loaded"])
+}
+
+func TestUserRepositoryMetersOnlyFullLoads(t *testing.T) {
+	metrics := newMetricsRecorder()
+	repo := persistence.NewUserJSONRepository(writeDataFile(t, "users.json", `[
+		{"id": 1, "name": "Ferdinande", "createdAt": "2020-07-14T05:48:54.798Z"},
+		{"id": 2, "name": "Mona", "createdAt": "2020-07-14T05:48:54.798Z"}
+	]`)).WithMetrics(metrics)
+
+	// the lookup stops at the user, it doesn't tell the records in the file
+	user, err := repo.GetByID(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, "Ferdinande", user.Name)
+	assert.Zero(t, metrics.timings["repository_load"])
+
+	users, err := repo.GetAll()
+	require.NoError(t, err)
+	assert.Len(t, users, 2)
+	assert.Equal(t, 1, metrics.timings["repository_load"])
+	assert.Equal(t, float64(2), metrics.gauges["repository_records_loaded"])
+}
@@ -0,0 +1,48 @@
+package persistence
+
+import (
+	"math"
+	"slices"
+	"sort"
+
+	domainAction "github.com/JoseBeteta/surfe/app/domain"
+)
+
+// nextActionProbabilities calculates the probabilities of next actions after a given action type
+// The actions are sorted by user and creation time in a copy, the slice given is left as it is
+func nextActionProbabilities(actions []domainAction.Action, actionType string) map[string]float64 {
+	// Sort actions by userId and createdAt
+	actions = slices.Clone(actions)
+	sort.Slice(actions, func(i, j int) bool {
+		if actions[i].UserID == actions[j].UserID {
+			return actions[i].CreatedAt.Before(actions[j].CreatedAt)
+		}
+		return actions[i].UserID < actions[j].UserID
+	})
+
+	// Count transitions from the given actionType
+	transitionCounts := make(map[string]int)
+	totalTransitions := 0
+
+	for i := 0; i < len(actions)-1; i++ {
+		current := actions[i]
+		next := actions[i+1]
+
+		if current.UserID == next.UserID && current.Type == actionType {
+			transitionCounts[next.Type]++
+			totalTransitions++
+		}
+	}
+
+	return transitionProbabilities(transitionCounts, totalTransitions)
+}
+
+// transitionProbabilities turns transition counts into probabilities rounded to two decimals
+func transitionProbabilities(transitionCounts map[string]int, totalTransitions int) map[string]float64 {
+	probabilities := make(map[string]float64)
+	for action, count := range transitionCounts {
+		probabilities[action] = math.Round((float64(count)/float64(totalTransitions))*100) / 100
+	}
+
+	return probabilities
+}
@@ -1,64 +1,198 @@
 package persistence
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	domainUser "github.com/JoseBeteta/surfe/app/domain"
-	"io/ioutil"
+	"io"
 	"os"
 	"sync"
 )
 
-// UserJSONRepository is a repository that interacts with a JSON file
+// UserJSONRepository is a repository that interacts with a data file, either a JSON array,
+// NDJSON or CSV, optionally gzip compressed
 type UserJSONRepository struct {
-	filePath string
-	mutex    sync.Mutex
+	filePath   string
+	csvMapping CSVMapping
+	meter      *fileMeter[domainUser.User]
+	health     fileCheck
+	mutex      sync.Mutex
+
+	// the version of the data file is its hash, computed again only once the file changes
+	versionMutex sync.Mutex
+	versioned    os.FileInfo
+	version      string
 }
 
 // NewUserJSONRepository creates a new repository that uses a JSON file
 func NewUserJSONRepository(filePath string) *UserJSONRepository {
-	return &UserJSONRepository{filePath: filePath}
+	return &UserJSONRepository{
+		filePath:   filePath,
+		csvMapping: DefaultUserCSVMapping(),
+		meter:      newFileMeter[domainUser.User]("users"),
+	}
+}
+
+// WithCSVMapping allows you to specify the CSV columns holding each user field
+// Fields not present in the mapping keep their default column
+func (r *UserJSONRepository) WithCSVMapping(mapping CSVMapping) *UserJSONRepository {
+	r.csvMapping = r.csvMapping.merge(mapping)
+
+	return r
+}
+
+// WithMetrics allows you to specify the agent receiving the load times and records loaded of the data file
+func (r *UserJSONRepository) WithMetrics(metrics MetricsAgent) *UserJSONRepository {
+	r.meter.metrics = metrics
+
+	return r
 }
 
 // GetByID retrieves a user by ID
-func (r *UserJSONRepository) GetByID(id int) (domainUser.User, error) {
+func (r *UserJSONRepository) GetByID(ctx context.Context, id int) (domainUser.User, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	users, err := r.readFromFile()
-	if err != nil {
+	// the file is streamed up to the user, so the records before a malformed one are still served
+	var found *domainUser.User
+	err := r.forEach(func(user domainUser.User) error {
+		if user.ID == id {
+			found = &user
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopIteration) {
 		return domainUser.User{}, err
 	}
 
-	for _, user := range users {
-		if user.ID == id {
-			return user, nil
-		}
+	if found == nil {
+		return domainUser.User{}, domainUser.UserNotFound
 	}
-	return domainUser.User{}, errors.New("user not found")
+	return *found, nil
 }
 
-// readFromFile reads the user data from the JSON file
-func (r *UserJSONRepository) readFromFile() ([]domainUser.User, error) {
-	file, err := os.Open(r.filePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []domainUser.User{}, nil
-		}
-		return nil, err
+// GetByIDs retrieves the users with the ids streaming the data file once
+func (r *UserJSONRepository) GetByIDs(ctx context.Context, ids []int) (map[int]domainUser.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	wanted := make(map[int]struct{}, len(ids))
+	for _, id := range ids {
+		wanted[id] = struct{}{}
 	}
-	defer file.Close()
 
-	data, err := ioutil.ReadAll(file)
-	if err != nil {
+	users := make(map[int]domainUser.User, len(ids))
+	err := r.forEach(func(user domainUser.User) error {
+		if _, ok := wanted[user.ID]; ok {
+			users[user.ID] = user
+		}
+		if len(users) == len(wanted) {
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopIteration) {
 		return nil, err
 	}
 
+	return users, nil
+}
+
+// GetAll retrieves all users from the data file
+func (r *UserJSONRepository) GetAll() ([]domainUser.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	users := []domainUser.User{}
-	err = json.Unmarshal(data, &users)
+	err := r.forEach(func(user domainUser.User) error {
+		users = append(users, user)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	return users, nil
 }
+
+// Version identifies the content of the data file, the same on every replica reading the same file
+// The file is only hashed again once it changes, a missing file has an empty version
+func (r *UserJSONRepository) Version(context.Context) (string, error) {
+	r.versionMutex.Lock()
+	defer r.versionMutex.Unlock()
+
+	info, err := os.Stat(r.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		r.versioned, r.version = nil, ""
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if r.versioned != nil && sameFileState(r.versioned, info) {
+		return r.version, nil
+	}
+
+	file, err := os.Open(r.filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	r.versioned, r.version = info, hex.EncodeToString(hash.Sum(nil)[:16])
+
+	return r.version, nil
+}
+
+// Ping fails when the data file is missing, can't be opened or holds a record that doesn't parse
+// The file is only decoded again once it changes
+func (r *UserJSONRepository) Ping(ctx context.Context) error {
+	return r.health.check(ctx, r.filePath, func() error {
+		return r.decode(func(domainUser.User) error { return nil })
+	})
+}
+
+// forEach streams every user stored in the data file into fn, recording the load
+func (r *UserJSONRepository) forEach(fn func(domainUser.User) error) error {
+	return r.meter.decode(r.decode, fn)
+}
+
+// decode streams every user stored in the data file into fn
+func (r *UserJSONRepository) decode(fn func(domainUser.User) error) error {
+	if _, err := os.Stat(r.filePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	return decodeFile(r.filePath, recordCodec[domainUser.User]{
+		validate: domainUser.User.Validate,
+		mapping:  r.csvMapping,
+		required: []string{"id", "name", "createdAt"},
+		fromCSV:  userFromCSV,
+	}, fn)
+}
+
+// userFromCSV builds a user from a CSV row
+func userFromCSV(record csvRecord) (domainUser.User, error) {
+	var (
+		user domainUser.User
+		err  error
+	)
+
+	if user.ID, err = record.int("id"); err != nil {
+		return user, err
+	}
+	user.Name = record.get("name")
+	if user.CreatedAt, err = record.time("createdAt"); err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
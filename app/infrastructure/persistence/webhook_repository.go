@@ -0,0 +1,221 @@
+package persistence
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+)
+
+// maxDeliveriesPerSubscription deliveries kept in the log of each subscription,
+// the oldest succeeded ones are dropped first
+const maxDeliveriesPerSubscription = 200
+
+// webhookState is the content of the webhooks file
+type webhookState struct {
+	Subscriptions []domain.WebhookSubscription `json:"subscriptions"`
+	Deliveries    []domain.WebhookDelivery     `json:"deliveries"`
+}
+
+// WebhookJSONRepository is a repository that keeps webhook subscriptions and deliveries
+// in memory, persisting them to a JSON file on every change
+type WebhookJSONRepository struct {
+	filePath string
+	mutex    sync.RWMutex
+	state    webhookState
+}
+
+// NewWebhookJSONRepository creates a new repository loading the JSON file when it exists
+func NewWebhookJSONRepository(filePath string) (*WebhookJSONRepository, error) {
+	r := &WebhookJSONRepository{filePath: filePath}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &r.state); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// SaveSubscription creates or replaces a subscription
+func (r *WebhookJSONRepository) SaveSubscription(subscription domain.WebhookSubscription) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, stored := range r.state.Subscriptions {
+		if stored.ID == subscription.ID {
+			r.state.Subscriptions[i] = subscription
+			return r.persist()
+		}
+	}
+	r.state.Subscriptions = append(r.state.Subscriptions, subscription)
+
+	return r.persist()
+}
+
+// GetSubscriptions retrieves every subscription
+func (r *WebhookJSONRepository) GetSubscriptions() ([]domain.WebhookSubscription, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return append([]domain.WebhookSubscription{}, r.state.Subscriptions...), nil
+}
+
+// GetSubscription retrieves a subscription by ID
+func (r *WebhookJSONRepository) GetSubscription(id string) (domain.WebhookSubscription, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, subscription := range r.state.Subscriptions {
+		if subscription.ID == id {
+			return subscription, nil
+		}
+	}
+
+	return domain.WebhookSubscription{}, domain.WebhookNotFound
+}
+
+// DeleteSubscription removes a subscription and its deliveries
+func (r *WebhookJSONRepository) DeleteSubscription(id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	subscriptions := r.state.Subscriptions[:0]
+	found := false
+	for _, subscription := range r.state.Subscriptions {
+		if subscription.ID == id {
+			found = true
+			continue
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	if !found {
+		return domain.WebhookNotFound
+	}
+	r.state.Subscriptions = subscriptions
+
+	deliveries := r.state.Deliveries[:0]
+	for _, delivery := range r.state.Deliveries {
+		if delivery.SubscriptionID != id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	r.state.Deliveries = deliveries
+
+	return r.persist()
+}
+
+// CreateDelivery creates a delivery, failing with DeliveryAlreadyExists when there is one with its ID
+func (r *WebhookJSONRepository) CreateDelivery(delivery domain.WebhookDelivery) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, stored := range r.state.Deliveries {
+		if stored.ID == delivery.ID {
+			return domain.DeliveryAlreadyExists
+		}
+	}
+	r.state.Deliveries = append(r.state.Deliveries, delivery)
+	r.prune(delivery.SubscriptionID)
+
+	return r.persist()
+}
+
+// SaveDelivery creates or updates a delivery by its ID
+func (r *WebhookJSONRepository) SaveDelivery(delivery domain.WebhookDelivery) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, stored := range r.state.Deliveries {
+		if stored.ID == delivery.ID {
+			r.state.Deliveries[i] = delivery
+			return r.persist()
+		}
+	}
+	r.state.Deliveries = append(r.state.Deliveries, delivery)
+	r.prune(delivery.SubscriptionID)
+
+	return r.persist()
+}
+
+// GetDueDeliveries returns pending deliveries whose next attempt is not after now, the most overdue first
+func (r *WebhookJSONRepository) GetDueDeliveries(now time.Time, limit int) ([]domain.WebhookDelivery, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	due := []domain.WebhookDelivery{}
+	for _, delivery := range r.state.Deliveries {
+		if delivery.Status == domain.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	return due, nil
+}
+
+// GetDeliveries retrieves the delivery log of a subscription, the newest first
+func (r *WebhookJSONRepository) GetDeliveries(subscriptionID string) ([]domain.WebhookDelivery, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	deliveries := []domain.WebhookDelivery{}
+	for i := len(r.state.Deliveries) - 1; i >= 0; i-- {
+		if r.state.Deliveries[i].SubscriptionID == subscriptionID {
+			deliveries = append(deliveries, r.state.Deliveries[i])
+		}
+	}
+
+	return deliveries, nil
+}
+
+// prune drops the oldest succeeded deliveries of a subscription over the limit,
+// pending and dead lettered ones are kept
+func (r *WebhookJSONRepository) prune(subscriptionID string) {
+	count := 0
+	for _, delivery := range r.state.Deliveries {
+		if delivery.SubscriptionID == subscriptionID {
+			count++
+		}
+	}
+
+	excess := count - maxDeliveriesPerSubscription
+	if excess <= 0 {
+		return
+	}
+
+	deliveries := r.state.Deliveries[:0]
+	for _, delivery := range r.state.Deliveries {
+		if excess > 0 && delivery.SubscriptionID == subscriptionID && delivery.Status == domain.DeliverySucceeded {
+			excess--
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	r.state.Deliveries = deliveries
+}
+
+func (r *WebhookJSONRepository) persist() error {
+	return writeJSONFile(r.filePath, r.state)
+}
@@ -0,0 +1,133 @@
+package projection
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+)
+
+// Subscriber is the interface of the event bus the projections listen to
+type Subscriber interface {
+	Subscribe(name string, fn any) error
+}
+
+// Projections are read models updated as actions are recorded instead of being
+// recomputed from every stored action on each read
+type Projections struct {
+	source domain.ActionReadRepository
+	mutex  sync.RWMutex
+
+	applied     map[int]struct{}
+	lastID      int
+	transitions *transitionProjector
+	countByUser map[int]int
+	referrals   *referralProjector
+}
+
+// NewProjections creates empty projections over the source repository, call Rebuild to fill them
+func NewProjections(source domain.ActionReadRepository) *Projections {
+	p := &Projections{source: source}
+	p.reset()
+
+	return p
+}
+
+// Subscribe keeps the projections updated with every ActionRecorded event
+func (p *Projections) Subscribe(subscriber Subscriber) error {
+	return subscriber.Subscribe(domain.ActionRecordedEvent, func(event domain.ActionRecorded) error {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		p.apply(event.Action)
+		return nil
+	})
+}
+
+// Rebuild discards the projections and replays every stored action, returning how many were applied
+func (p *Projections) Rebuild(ctx context.Context) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	actions, err := p.source.GetAll(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	p.reset()
+	for _, action := range actions {
+		p.apply(action)
+	}
+
+	return len(p.applied), nil
+}
+
+// Version identifies the actions the projections are computed from, by how many were applied and the highest ID
+// It changes with every action applied and every rebuild loading other actions, and replicas and restarts over the
+// same actions agree on it, so the responses computed from the projections can be cached until it changes
+func (p *Projections) Version() string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return strconv.Itoa(len(p.applied)) + "." + strconv.Itoa(p.lastID)
+}
+
+// CountByUserID returns the count of actions for a given user ID
+func (p *Projections) CountByUserID(ctx context.Context, userID int) (int, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.countByUser[userID], nil
+}
+
+// GetNextActionProbabilities returns the probabilities of next actions after a given action type
+func (p *Projections) GetNextActionProbabilities(ctx context.Context, actionType string) (map[string]float64, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.transitions.probabilities(actionType), nil
+}
+
+// GetNextActionCounts returns how many times each action followed a given action type
+func (p *Projections) GetNextActionCounts(ctx context.Context, actionType string) (map[string]int, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.transitions.countsFrom(actionType), nil
+}
+
+// GetReferralIndex returns the number of users referred directly or indirectly by each user
+func (p *Projections) GetReferralIndex(ctx context.Context) (map[int]int, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.referrals.snapshot(), nil
+}
+
+// GetAll retrieves all actions from the source repository
+func (p *Projections) GetAll(ctx context.Context) ([]domain.Action, error) {
+	return p.source.GetAll(ctx)
+}
+
+func (p *Projections) reset() {
+	p.applied = make(map[int]struct{})
+	p.lastID = -1
+	p.transitions = newTransitionProjector()
+	p.countByUser = make(map[int]int)
+	p.referrals = newReferralProjector()
+}
+
+// apply updates every projection, actions already applied are ignored so an event
+// delivered while rebuilding is not counted twice
+func (p *Projections) apply(action domain.Action) {
+	if _, found := p.applied[action.ID]; found {
+		return
+	}
+	p.applied[action.ID] = struct{}{}
+	p.lastID = max(p.lastID, action.ID)
+
+	p.transitions.apply(action)
+	p.countByUser[action.UserID]++
+	p.referrals.apply(action)
+}
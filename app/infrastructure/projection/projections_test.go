@@ -0,0 +1,184 @@
+package projection_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/eventbus"
+	"github.com/JoseBeteta/surfe/app/infrastructure/projection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sliceRepository is an in memory action repository
+type sliceRepository struct {
+	actions []domain.Action
+}
+
+func (r *sliceRepository) CountByUserID(context.Context, int) (int, error) { return 0, nil }
+
+func (r *sliceRepository) GetNextActionProbabilities(context.Context, string) (map[string]float64, error) {
+	return nil, nil
+}
+
+func (r *sliceRepository) GetAll(context.Context) ([]domain.Action, error) {
+	return append([]domain.Action{}, r.actions...), nil
+}
+
+func action(id, userID int, actionType string, minute int) domain.Action {
+	return domain.Action{
+		ID:        id,
+		Type:      actionType,
+		UserID:    userID,
+		CreatedAt: time.Date(2021, time.November, 19, 17, minute, 0, 0, time.UTC),
+	}
+}
+
+func referral(id, userID, targetUser int) domain.Action {
+	a := action(id, userID, "REFER_USER", id)
+	a.TargetUser = targetUser
+	return a
+}
+
+func TestProjectionsUpdatedByEvents(t *testing.T) {
+	repo := &sliceRepository{actions: []domain.Action{
+		action(0, 1, "WELCOME", 0),
+		action(1, 1, "ADD_CONTACT", 10),
+	}}
+
+	bus := eventbus.New()
+	projections := projection.NewProjections(repo)
+	require.NoError(t, projections.Subscribe(bus))
+
+	applied, err := projections.Rebuild(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, applied)
+
+	probabilities, err := projections.GetNextActionProbabilities(context.Background(), "WELCOME")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"ADD_CONTACT": 1}, probabilities)
+
+	// recorded out of order, it goes between the two stored actions
+	require.NoError(t, bus.Publish(domain.ActionRecordedEvent, domain.ActionRecorded{
+		Action: action(2, 1, "EDIT_CONTACT", 5),
+	}))
+	// delivered twice, applied once
+	require.NoError(t, bus.Publish(domain.ActionRecordedEvent, domain.ActionRecorded{
+		Action: action(2, 1, "EDIT_CONTACT", 5),
+	}))
+
+	probabilities, err = projections.GetNextActionProbabilities(context.Background(), "WELCOME")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"EDIT_CONTACT": 1}, probabilities)
+
+	counts, err := projections.GetNextActionCounts(context.Background(), "EDIT_CONTACT")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"ADD_CONTACT": 1}, counts)
+
+	count, err := projections.CountByUserID(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestProjectionsReferralIndex(t *testing.T) {
+	bus := eventbus.New()
+	projections := projection.NewProjections(&sliceRepository{})
+	require.NoError(t, projections.Subscribe(bus))
+
+	// 3 is referred before its referrer 2 is, the index of 1 must include it
+	for _, a := range []domain.Action{
+		referral(0, 2, 3),
+		referral(1, 1, 2),
+		referral(2, 1, 4),
+		referral(3, 3, 5),
+		// a user referring itself counts once
+		referral(4, 6, 6),
+	} {
+		require.NoError(t, bus.Publish(domain.ActionRecordedEvent, domain.ActionRecorded{Action: a}))
+	}
+
+	index, err := projections.GetReferralIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{
+		1: 4,
+		2: 2,
+		3: 1,
+		4: 0,
+		5: 0,
+		6: 1,
+	}, index)
+}
+
+// The version changes with every action applied and every rebuild loading other actions, so responses cached at
+// an older one are stale, and is the same for projections built from the same actions
+func TestProjectionsVersion(t *testing.T) {
+	bus := eventbus.New()
+	source := &sliceRepository{actions: []domain.Action{action(0, 1, "WELCOME", 0)}}
+	projections := projection.NewProjections(source)
+	require.NoError(t, projections.Subscribe(bus))
+
+	_, err := projections.Rebuild(context.Background())
+	require.NoError(t, err)
+	rebuilt := projections.Version()
+
+	require.NoError(t, bus.Publish(domain.ActionRecordedEvent, domain.ActionRecorded{Action: action(1, 1, "ADD_CONTACT", 1)}))
+	applied := projections.Version()
+	assert.NotEqual(t, rebuilt, applied)
+
+	// delivered twice, the projections don't change
+	require.NoError(t, bus.Publish(domain.ActionRecordedEvent, domain.ActionRecorded{Action: action(1, 1, "ADD_CONTACT", 1)}))
+	assert.Equal(t, applied, projections.Version())
+
+	// another replica, or a restart, over the same actions
+	source.actions = append(source.actions, action(1, 1, "ADD_CONTACT", 1))
+	replica := projection.NewProjections(source)
+	_, err = replica.Rebuild(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, applied, replica.Version())
+
+	// rebuilt from other actions
+	source.actions = append(source.actions, action(2, 2, "WELCOME", 2))
+	_, err = projections.Rebuild(context.Background())
+	require.NoError(t, err)
+	assert.NotContains(t, []string{rebuilt, applied}, projections.Version())
+}
+
+func TestProjectionsRebuildMatchesIncremental(t *testing.T) {
+	actions := []domain.Action{
+		action(0, 1, "WELCOME", 0),
+		action(1, 1, "CONNECT_CRM", 3),
+		referral(2, 1, 2),
+		action(3, 2, "WELCOME", 1),
+		action(4, 1, "ADD_CONTACT", 1),
+		referral(5, 2, 3),
+		action(6, 2, "ADD_CONTACT", 2),
+	}
+
+	bus := eventbus.New()
+	incremental := projection.NewProjections(&sliceRepository{})
+	require.NoError(t, incremental.Subscribe(bus))
+	for i := len(actions) - 1; i >= 0; i-- {
+		require.NoError(t, bus.Publish(domain.ActionRecordedEvent, domain.ActionRecorded{Action: actions[i]}))
+	}
+
+	rebuilt := projection.NewProjections(&sliceRepository{actions: actions})
+	_, err := rebuilt.Rebuild(context.Background())
+	require.NoError(t, err)
+
+	for _, actionType := range []string{"WELCOME", "CONNECT_CRM", "ADD_CONTACT", "REFER_USER"} {
+		expected, err := rebuilt.GetNextActionCounts(context.Background(), actionType)
+		require.NoError(t, err)
+		actual, err := incremental.GetNextActionCounts(context.Background(), actionType)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual, actionType)
+	}
+
+	expected, err := rebuilt.GetReferralIndex(context.Background())
+	require.NoError(t, err)
+	actual, err := incremental.GetReferralIndex(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, map[int]int{1: 2, 2: 1, 3: 0}, actual)
+}
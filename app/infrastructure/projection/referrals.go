@@ -0,0 +1,62 @@
+package projection
+
+import "github.com/JoseBeteta/surfe/app/domain"
+
+// referralProjector keeps the referral index, the number of users referred directly or
+// indirectly by each user, updated as referrals are recorded
+type referralProjector struct {
+	referrers map[int][]int
+	index     map[int]int
+}
+
+func newReferralProjector() *referralProjector {
+	return &referralProjector{
+		referrers: make(map[int][]int),
+		index:     make(map[int]int),
+	}
+}
+
+// apply adds the referred user, and everyone it referred, to the referrer and its own referrers
+func (p *referralProjector) apply(action domain.Action) {
+	if action.Type != domain.ActionReferUser {
+		return
+	}
+
+	referrer, referred := action.UserID, action.TargetUser
+	if _, found := p.index[referred]; !found {
+		p.index[referred] = 0
+	}
+
+	// a user referring itself counts once, without adding its own referrals again
+	if referrer == referred {
+		p.propagate(referrer, 1, map[int]bool{})
+		return
+	}
+
+	p.propagate(referrer, 1+p.index[referred], map[int]bool{referred: true})
+	p.referrers[referred] = append(p.referrers[referred], referrer)
+}
+
+// propagate adds delta to the user and all of its referrers, visited guards against referral cycles
+func (p *referralProjector) propagate(userID, delta int, visited map[int]bool) {
+	if visited[userID] {
+		return
+	}
+	visited[userID] = true
+	defer delete(visited, userID)
+
+	p.index[userID] += delta
+	for _, referrer := range p.referrers[userID] {
+		p.propagate(referrer, delta, visited)
+	}
+}
+
+// snapshot returns a copy of the referral index
+func (p *referralProjector) snapshot() map[int]int {
+	index := make(map[int]int, len(p.index))
+	for userID, count := range p.index {
+		index[userID] = count
+	}
+
+	return index
+}
@@ -0,0 +1,88 @@
+package projection
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+)
+
+// timelineEntry is an action in a user timeline
+type timelineEntry struct {
+	createdAt  time.Time
+	actionType string
+}
+
+// transitionProjector keeps the count of transitions between consecutive actions of each user
+type transitionProjector struct {
+	timelines map[int][]timelineEntry
+	counts    map[string]map[string]int
+	totals    map[string]int
+}
+
+func newTransitionProjector() *transitionProjector {
+	return &transitionProjector{
+		timelines: make(map[int][]timelineEntry),
+		counts:    make(map[string]map[string]int),
+		totals:    make(map[string]int),
+	}
+}
+
+// apply inserts the action in its user timeline by creation time, so actions recorded
+// out of order replace the transition between their neighbours
+func (p *transitionProjector) apply(action domain.Action) {
+	timeline := p.timelines[action.UserID]
+	i := sort.Search(len(timeline), func(i int) bool {
+		return timeline[i].createdAt.After(action.CreatedAt)
+	})
+
+	hasPrevious, hasNext := i > 0, i < len(timeline)
+	if hasPrevious && hasNext {
+		p.add(timeline[i-1].actionType, timeline[i].actionType, -1)
+	}
+	if hasPrevious {
+		p.add(timeline[i-1].actionType, action.Type, 1)
+	}
+	if hasNext {
+		p.add(action.Type, timeline[i].actionType, 1)
+	}
+
+	timeline = append(timeline, timelineEntry{})
+	copy(timeline[i+1:], timeline[i:])
+	timeline[i] = timelineEntry{createdAt: action.CreatedAt, actionType: action.Type}
+	p.timelines[action.UserID] = timeline
+}
+
+func (p *transitionProjector) add(from, to string, delta int) {
+	if p.counts[from] == nil {
+		p.counts[from] = make(map[string]int)
+	}
+	p.counts[from][to] += delta
+	p.totals[from] += delta
+
+	if p.counts[from][to] == 0 {
+		delete(p.counts[from], to)
+	}
+}
+
+// probabilities of each action following actionType rounded to two decimals
+func (p *transitionProjector) probabilities(actionType string) map[string]float64 {
+	probabilities := make(map[string]float64)
+	total := p.totals[actionType]
+	for next, count := range p.counts[actionType] {
+		probabilities[next] = math.Round((float64(count)/float64(total))*100) / 100
+	}
+
+	return probabilities
+}
+
+// countsFrom returns a copy of the transition counts from actionType
+func (p *transitionProjector) countsFrom(actionType string) map[string]int {
+	counts := make(map[string]int, len(p.counts[actionType]))
+	for next, count := range p.counts[actionType] {
+		counts[next] = count
+	}
+
+	return counts
+}
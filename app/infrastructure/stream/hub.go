@@ -0,0 +1,143 @@
+package stream
+
+import (
+	"sync"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+)
+
+// Config are the configurations related to the live actions stream
+type Config struct {
+	HeartbeatInterval time.Duration `env:"ACTIONS_STREAM_HEARTBEAT_INTERVAL" env-default:"15s"`
+	BufferSize        int           `env:"ACTIONS_STREAM_BUFFER_SIZE" env-default:"64"`
+}
+
+// Subscriber is the interface of the event bus the hub listens to
+type Subscriber interface {
+	Subscribe(name string, fn any) error
+}
+
+// Filter selects the actions sent to a subscription, zero values match every action
+type Filter struct {
+	UserID *int
+	Type   string
+}
+
+// Matches reports whether the action passes the filter
+func (f Filter) Matches(action domain.Action) bool {
+	if f.UserID != nil && *f.UserID != action.UserID {
+		return false
+	}
+
+	return f.Type == "" || f.Type == action.Type
+}
+
+// Subscription receives the recorded actions matching its filter
+type Subscription struct {
+	filter  Filter
+	actions chan domain.Action
+	dropped chan struct{}
+}
+
+// Actions returns the channel the matching actions are sent to
+func (s *Subscription) Actions() <-chan domain.Action {
+	return s.actions
+}
+
+// Dropped is closed when the subscription fell behind or the hub was closed
+func (s *Subscription) Dropped() <-chan struct{} {
+	return s.dropped
+}
+
+// Hub fans out recorded actions to the registered subscriptions
+// Every subscription has a bounded buffer, a subscription that fills it is dropped
+// instead of blocking the publisher, so a slow client never slows down writes
+type Hub struct {
+	mutex         sync.Mutex
+	bufferSize    int
+	subscriptions map[*Subscription]struct{}
+	closed        bool
+}
+
+// NewHub creates a hub without subscriptions
+func NewHub(bufferSize int) *Hub {
+	return &Hub{
+		bufferSize:    bufferSize,
+		subscriptions: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe forwards every recorded action to the hub
+func (h *Hub) Subscribe(subscriber Subscriber) error {
+	return subscriber.Subscribe(domain.ActionRecordedEvent, func(event domain.ActionRecorded) error {
+		h.publish(event.Action)
+		return nil
+	})
+}
+
+// Register adds a subscription receiving the actions matching filter
+func (h *Hub) Register(filter Filter) *Subscription {
+	subscription := &Subscription{
+		filter:  filter,
+		actions: make(chan domain.Action, h.bufferSize),
+		dropped: make(chan struct{}),
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.closed {
+		close(subscription.dropped)
+		return subscription
+	}
+	h.subscriptions[subscription] = struct{}{}
+
+	return subscription
+}
+
+// Unregister removes the subscription, it is safe to call it after the subscription was dropped
+func (h *Hub) Unregister(subscription *Subscription) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	delete(h.subscriptions, subscription)
+}
+
+// Close drops every subscription so the streams end, subscriptions registered afterwards are dropped right away
+func (h *Hub) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.closed = true
+	for subscription := range h.subscriptions {
+		delete(h.subscriptions, subscription)
+		close(subscription.dropped)
+	}
+}
+
+// Len returns the number of registered subscriptions
+func (h *Hub) Len() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return len(h.subscriptions)
+}
+
+func (h *Hub) publish(action domain.Action) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for subscription := range h.subscriptions {
+		if !subscription.filter.Matches(action) {
+			continue
+		}
+
+		select {
+		case subscription.actions <- action:
+		default:
+			delete(h.subscriptions, subscription)
+			close(subscription.dropped)
+		}
+	}
+}
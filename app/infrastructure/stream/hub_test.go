@@ -0,0 +1,89 @@
+package stream_test
+
+import (
+	"testing"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/eventbus"
+	"github.com/JoseBeteta/surfe/app/infrastructure/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func record(t *testing.T, bus *eventbus.Bus, id, userID int, actionType string) {
+	t.Helper()
+
+	require.NoError(t, bus.Publish(domain.ActionRecordedEvent, domain.ActionRecorded{Action: domain.Action{
+		ID:     id,
+		Type:   actionType,
+		UserID: userID,
+	}}))
+}
+
+func TestHubFiltersActions(t *testing.T) {
+	bus := eventbus.New()
+	hub := stream.NewHub(10)
+	require.NoError(t, hub.Subscribe(bus))
+
+	userID := 1
+	byUser := hub.Register(stream.Filter{UserID: &userID})
+	byType := hub.Register(stream.Filter{Type: "WELCOME"})
+
+	record(t, bus, 0, 1, "WELCOME")
+	record(t, bus, 1, 2, "WELCOME")
+	record(t, bus, 2, 1, "ADD_CONTACT")
+
+	assert.Equal(t, 0, (<-byUser.Actions()).ID)
+	assert.Equal(t, 2, (<-byUser.Actions()).ID)
+	assert.Empty(t, byUser.Actions())
+
+	assert.Equal(t, 0, (<-byType.Actions()).ID)
+	assert.Equal(t, 1, (<-byType.Actions()).ID)
+	assert.Empty(t, byType.Actions())
+
+	hub.Unregister(byUser)
+	hub.Unregister(byType)
+	assert.Zero(t, hub.Len())
+}
+
+func TestHubDropsSlowSubscriptions(t *testing.T) {
+	bus := eventbus.New()
+	hub := stream.NewHub(2)
+	require.NoError(t, hub.Subscribe(bus))
+
+	slow := hub.Register(stream.Filter{})
+	fast := hub.Register(stream.Filter{})
+
+	record(t, bus, 0, 1, "WELCOME")
+	record(t, bus, 1, 1, "WELCOME")
+	<-fast.Actions()
+	<-fast.Actions()
+
+	// the buffer of slow is full, it is dropped without blocking the publisher
+	record(t, bus, 2, 1, "WELCOME")
+
+	select {
+	case <-slow.Dropped():
+	default:
+		t.Fatal("slow subscription not dropped")
+	}
+	assert.Equal(t, 2, (<-fast.Actions()).ID)
+	assert.Equal(t, 1, hub.Len())
+
+	// unregistering a dropped subscription is safe
+	hub.Unregister(slow)
+	assert.Equal(t, 1, hub.Len())
+}
+
+func TestHubClose(t *testing.T) {
+	hub := stream.NewHub(1)
+	open := hub.Register(stream.Filter{})
+
+	hub.Close()
+	<-open.Dropped()
+	assert.Zero(t, hub.Len())
+
+	// registered after closing
+	<-hub.Register(stream.Filter{}).Dropped()
+	assert.Zero(t, hub.Len())
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"sync"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+)
+
+// Subscriber is the interface of the event bus the event outbox listens to
+type Subscriber interface {
+	Subscribe(name string, fn any) error
+}
+
+// EventOutbox is an in memory outbox filled from ActionRecorded events, for repositories without an outbox of their own
+// such as test doubles. The server doesn't use it: actions recorded right before the process stops would be lost,
+// unlike with the outbox the storage backends write with the action
+type EventOutbox struct {
+	mutex   sync.Mutex
+	pending []domain.Action
+}
+
+// NewEventOutbox creates an empty outbox
+func NewEventOutbox() *EventOutbox {
+	return &EventOutbox{}
+}
+
+// Subscribe adds every recorded action to the outbox
+func (o *EventOutbox) Subscribe(subscriber Subscriber) error {
+	return subscriber.Subscribe(domain.ActionRecordedEvent, func(event domain.ActionRecorded) error {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		o.pending = append(o.pending, event.Action)
+		return nil
+	})
+}
+
+// PendingActions returns the actions not dispatched yet in the order they were recorded
+func (o *EventOutbox) PendingActions(limit int) ([]domain.Action, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return append([]domain.Action{}, o.pending[:min(limit, len(o.pending))]...), nil
+}
+
+// MarkDispatched removes the actions from the outbox
+func (o *EventOutbox) MarkDispatched(actionIDs ...int) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	dispatched := make(map[int]struct{}, len(actionIDs))
+	for _, id := range actionIDs {
+		dispatched[id] = struct{}{}
+	}
+
+	pending := o.pending[:0]
+	for _, action := range o.pending {
+		if _, found := dispatched[action.ID]; !found {
+			pending = append(pending, action)
+		}
+	}
+	o.pending = pending
+
+	return nil
+}
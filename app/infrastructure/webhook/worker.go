@@ -0,0 +1,330 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	// SignatureHeader holds the HMAC-SHA256 of the payload signed with the subscription secret
+	SignatureHeader = "X-Surfe-Signature"
+	// DeliveryHeader holds the delivery ID, retries of a delivery keep the same ID
+	DeliveryHeader = "X-Surfe-Delivery"
+	// EventHeader holds the name of the event delivered
+	EventHeader = "X-Surfe-Event"
+
+	signaturePrefix = "sha256="
+	maxErrorLength  = 256
+)
+
+// Config are the configurations related to the webhook delivery worker
+type Config struct {
+	WebhooksFile   string        `env:"WEBHOOKS_FILE" env-default:"data/webhooks.json"`
+	PollInterval   time.Duration `env:"WEBHOOKS_POLL_INTERVAL" env-default:"1s"`
+	BatchSize      int           `env:"WEBHOOKS_BATCH_SIZE" env-default:"100"`
+	MaxAttempts    int           `env:"WEBHOOKS_MAX_ATTEMPTS" env-default:"8"`
+	InitialBackoff time.Duration `env:"WEBHOOKS_INITIAL_BACKOFF" env-default:"5s"`
+	MaxBackoff     time.Duration `env:"WEBHOOKS_MAX_BACKOFF" env-default:"1h"`
+	RequestTimeout time.Duration `env:"WEBHOOKS_REQUEST_TIMEOUT" env-default:"10s"`
+	// Concurrency bounds the deliveries attempted at the same time, so a slow receiver doesn't hold back the rest
+	Concurrency int `env:"WEBHOOKS_CONCURRENCY" env-default:"20" validate:"gt=0"`
+}
+
+// Payload is the body posted to webhook receivers
+type Payload struct {
+	ID        string        `json:"id"`
+	Event     string        `json:"event"`
+	CreatedAt time.Time     `json:"createdAt"`
+	Data      domain.Action `json:"data"`
+}
+
+// Worker dispatches the actions in the outbox to the matching subscriptions and delivers them,
+// retrying failed deliveries with exponential backoff until they are dead lettered
+type Worker struct {
+	outbox     domain.ActionOutbox
+	repository domain.WebhookRepository
+	client     *http.Client
+	cfg        Config
+	logger     slog.Logger
+	tracer     trace.Tracer
+	now        func() time.Time
+}
+
+// NewWorker creates a new webhook delivery worker
+func NewWorker(
+	outbox domain.ActionOutbox,
+	repository domain.WebhookRepository,
+	cfg Config,
+	logger slog.Logger,
+) *Worker {
+	return &Worker{
+		outbox:     outbox,
+		repository: repository,
+		client:     &http.Client{Timeout: cfg.RequestTimeout},
+		cfg:        cfg,
+		logger:     logger,
+		tracer:     noop.NewTracerProvider().Tracer(tracing.InstrumentationName),
+		now:        time.Now,
+	}
+}
+
+// WithTracer allows you to specify the tracer starting a span for every delivery attempt
+func (w *Worker) WithTracer(tracer trace.Tracer) *Worker {
+	w.tracer = tracer
+
+	return w
+}
+
+// WithClock allows you to specify the clock used to schedule retries
+func (w *Worker) WithClock(now func() time.Time) *Worker {
+	w.now = now
+
+	return w
+}
+
+// Run processes the outbox and due deliveries every poll interval until the context is done
+func (w *Worker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.Process(ctx); err != nil {
+			w.logger.Error("error processing webhooks", "error", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Process dispatches pending outbox actions and attempts every due delivery once,
+// up to the configured concurrency at the same time
+func (w *Worker) Process(ctx context.Context) error {
+	if err := w.dispatch(); err != nil {
+		return err
+	}
+
+	due, err := w.repository.GetDueDeliveries(w.now(), w.cfg.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		errs  []error
+	)
+	slots := make(chan struct{}, max(w.cfg.Concurrency, 1))
+	for _, delivery := range due {
+		if ctx.Err() != nil {
+			break
+		}
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if err := w.attempt(ctx, delivery); err != nil {
+				mutex.Lock()
+				errs = append(errs, err)
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// dispatch creates a pending delivery for each subscription matching the outbox actions
+// Delivery IDs are derived from the subscription and action, so dispatching again after
+// a failure marking the outbox neither duplicates deliveries nor resets their progress
+func (w *Worker) dispatch() error {
+	actions, err := w.outbox.PendingActions(w.cfg.BatchSize)
+	if err != nil || len(actions) == 0 {
+		return err
+	}
+
+	subscriptions, err := w.repository.GetSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int, 0, len(actions))
+	for _, action := range actions {
+		for _, subscription := range subscriptions {
+			if !subscription.Matches(action.Type) {
+				continue
+			}
+
+			now := w.now()
+			err := w.repository.CreateDelivery(domain.WebhookDelivery{
+				ID:             fmt.Sprintf("%s-%d", subscription.ID, action.ID),
+				SubscriptionID: subscription.ID,
+				Action:         action,
+				Status:         domain.DeliveryPending,
+				NextAttemptAt:  now,
+				CreatedAt:      now,
+			})
+			if err != nil && !errors.Is(err, domain.DeliveryAlreadyExists) {
+				return err
+			}
+		}
+		ids = append(ids, action.ID)
+	}
+
+	return w.outbox.MarkDispatched(ids...)
+}
+
+// attempt posts the delivery once, scheduling a retry or dead lettering it when it fails
+func (w *Worker) attempt(ctx context.Context, delivery domain.WebhookDelivery) error {
+	subscription, err := w.repository.GetSubscription(delivery.SubscriptionID)
+	if errors.Is(err, domain.WebhookNotFound) {
+		// the subscription was deleted after the delivery was due
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	delivery.Attempts++
+	statusCode, err := w.tracedPost(ctx, subscription, delivery)
+	delivery.LastStatusCode = statusCode
+
+	switch {
+	case err == nil:
+		deliveredAt := w.now()
+		delivery.Status = domain.DeliverySucceeded
+		delivery.DeliveredAt = &deliveredAt
+		delivery.LastError = ""
+	case delivery.Attempts >= w.cfg.MaxAttempts:
+		delivery.Status = domain.DeliveryDeadLettered
+		delivery.LastError = truncate(err.Error())
+		w.logger.Warn("webhook delivery dead lettered",
+			"delivery", delivery.ID,
+			"subscription", subscription.ID,
+			"attempts", delivery.Attempts,
+			"error", err.Error(),
+		)
+	default:
+		delivery.NextAttemptAt = w.now().Add(w.backoff(delivery.Attempts))
+		delivery.LastError = truncate(err.Error())
+	}
+
+	return w.repository.SaveDelivery(delivery)
+}
+
+// tracedPost posts the delivery within a client span, the receivers continue its trace
+func (w *Worker) tracedPost(ctx context.Context, subscription domain.WebhookSubscription, delivery domain.WebhookDelivery) (int, error) {
+	ctx, span := w.tracer.Start(ctx, "webhook delivery",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("webhook.subscription.id", subscription.ID),
+			attribute.String("webhook.delivery.id", delivery.ID),
+			attribute.Int("webhook.delivery.attempt", delivery.Attempts),
+			attribute.Int("action.id", delivery.Action.ID),
+		),
+	)
+	defer span.End()
+
+	statusCode, err := w.post(ctx, subscription, delivery)
+	if statusCode != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return statusCode, err
+}
+
+// post sends the signed payload, any response other than 2xx is a failure
+func (w *Worker) post(ctx context.Context, subscription domain.WebhookSubscription, delivery domain.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(Payload{
+		ID:        delivery.ID,
+		Event:     domain.ActionRecordedEvent,
+		CreatedAt: delivery.CreatedAt,
+		Data:      delivery.Action,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, domain.ActionRecordedEvent)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(subscription.Secret, body))
+	tracing.Propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// backoff doubles the wait after every failed attempt up to the maximum backoff
+func (w *Worker) backoff(attempts int) time.Duration {
+	backoff := w.cfg.InitialBackoff
+	for i := 1; i < attempts && backoff < w.cfg.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, w.cfg.MaxBackoff)
+}
+
+// Sign returns the signature header value of body for the secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether the signature header value matches body for the secret
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+func truncate(s string) string {
+	if len(s) <= maxErrorLength {
+		return s
+	}
+	return s[:maxErrorLength]
+}
@@ -0,0 +1,297 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JoseBeteta/surfe/app/domain"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/eventbus"
+	"github.com/JoseBeteta/surfe/app/infrastructure/persistence"
+	"github.com/JoseBeteta/surfe/app/infrastructure/webhook"
+	"github.com/JoseBeteta/surfe/test/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const secret = "0123456789abcdef"
+
+// receiver is a webhook receiver answering with the queued status codes, 200 once they run out
+type receiver struct {
+	mutex        sync.Mutex
+	statuses     []int
+	payloads     []webhook.Payload
+	traceparents []string
+	invalid      int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	body, _ := io.ReadAll(req.Body)
+	if !webhook.Verify(secret, body, req.Header.Get(webhook.SignatureHeader)) {
+		r.invalid++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var payload webhook.Payload
+	_ = json.Unmarshal(body, &payload)
+	r.payloads = append(r.payloads, payload)
+	r.traceparents = append(r.traceparents, req.Header.Get("traceparent"))
+
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+// clock is a manually advanced clock
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func setup(t *testing.T, statuses ...int) (*webhook.Worker, *persistence.WebhookJSONRepository, *eventbus.Bus, *receiver, *clock) {
+	t.Helper()
+
+	rec := &receiver{statuses: statuses}
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+
+	repository, err := persistence.NewWebhookJSONRepository(filepath.Join(t.TempDir(), "webhooks.json"))
+	require.NoError(t, err)
+	require.NoError(t, repository.SaveSubscription(domain.WebhookSubscription{
+		ID:          "sub",
+		URL:         server.URL,
+		Secret:      secret,
+		ActionTypes: []string{"WELCOME"},
+	}))
+
+	bus := eventbus.New()
+	outbox := webhook.NewEventOutbox()
+	require.NoError(t, outbox.Subscribe(bus))
+
+	c := &clock{now: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)}
+	worker := webhook.NewWorker(outbox, repository, webhook.Config{
+		BatchSize:      10,
+		MaxAttempts:    3,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		RequestTimeout: time.Second,
+	}, mocks.NewNullLogger()).WithClock(c.Now)
+
+	return worker, repository, bus, rec, c
+}
+
+func record(t *testing.T, bus *eventbus.Bus, id int, actionType string) {
+	t.Helper()
+
+	require.NoError(t, bus.Publish(domain.ActionRecordedEvent, domain.ActionRecorded{Action: domain.Action{
+		ID:        id,
+		Type:      actionType,
+		UserID:    1,
+		CreatedAt: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}}))
+}
+
+func TestWorkerDeliversSignedPayloads(t *testing.T) {
+	worker, repository, bus, rec, _ := setup(t)
+
+	record(t, bus, 1, "WELCOME")
+	// not matching the subscription
+	record(t, bus, 2, "ADD_CONTACT")
+
+	require.NoError(t, worker.Process(context.Background()))
+
+	assert.Zero(t, rec.invalid)
+	require.Len(t, rec.payloads, 1)
+	assert.Equal(t, "sub-1", rec.payloads[0].ID)
+	assert.Equal(t, domain.ActionRecordedEvent, rec.payloads[0].Event)
+	assert.Equal(t, 1, rec.payloads[0].Data.ID)
+
+	deliveries, err := repository.GetDeliveries("sub")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, domain.DeliverySucceeded, deliveries[0].Status)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.NotNil(t, deliveries[0].DeliveredAt)
+
+	// nothing left to deliver
+	require.NoError(t, worker.Process(context.Background()))
+	assert.Len(t, rec.payloads, 1)
+}
+
+func TestWorkerRetriesWithBackoff(t *testing.T) {
+	worker, repository, bus, rec, c := setup(t, http.StatusInternalServerError, http.StatusServiceUnavailable)
+
+	record(t, bus, 1, "WELCOME")
+	start := c.now
+
+	require.NoError(t, worker.Process(context.Background()))
+	deliveries, err := repository.GetDeliveries("sub")
+	require.NoError(t, err)
+	assert.Equal(t, domain.DeliveryPending, deliveries[0].Status)
+	assert.Equal(t, http.StatusInternalServerError, deliveries[0].LastStatusCode)
+	assert.Equal(t, start.Add(time.Second), deliveries[0].NextAttemptAt)
+
+	// not due yet
+	require.NoError(t, worker.Process(context.Background()))
+	assert.Len(t, rec.payloads, 1)
+
+	c.now = start.Add(time.Second)
+	require.NoError(t, worker.Process(context.Background()))
+	deliveries, err = repository.GetDeliveries("sub")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deliveries[0].Attempts)
+	assert.Equal(t, c.now.Add(2*time.Second), deliveries[0].NextAttemptAt)
+
+	c.now = c.now.Add(2 * time.Second)
+	require.NoError(t, worker.Process(context.Background()))
+	deliveries, err = repository.GetDeliveries("sub")
+	require.NoError(t, err)
+	assert.Equal(t, domain.DeliverySucceeded, deliveries[0].Status)
+	assert.Equal(t, 3, deliveries[0].Attempts)
+
+	// retries keep the delivery ID so receivers can deduplicate
+	require.Len(t, rec.payloads, 3)
+	for _, payload := range rec.payloads {
+		assert.Equal(t, "sub-1", payload.ID)
+	}
+}
+
+func TestWorkerDeadLettersAfterMaxAttempts(t *testing.T) {
+	worker, repository, bus, rec, c := setup(t,
+		http.StatusInternalServerError,
+		http.StatusInternalServerError,
+		http.StatusInternalServerError,
+	)
+
+	record(t, bus, 1, "WELCOME")
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, worker.Process(context.Background()))
+		c.now = c.now.Add(time.Hour)
+	}
+
+	assert.Len(t, rec.payloads, 3)
+
+	deliveries, err := repository.GetDeliveries("sub")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, domain.DeliveryDeadLettered, deliveries[0].Status)
+	assert.Equal(t, 3, deliveries[0].Attempts)
+	assert.Equal(t, "receiver responded with status 500", deliveries[0].LastError)
+}
+
+func TestWorkerTracesDeliveries(t *testing.T) {
+	worker, _, bus, rec, _ := setup(t, http.StatusInternalServerError)
+
+	recorder := tracetest.NewSpanRecorder()
+	worker.WithTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test"))
+
+	record(t, bus, 1, "WELCOME")
+	require.NoError(t, worker.Process(context.Background()))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "webhook delivery", spans[0].Name())
+	assert.Equal(t, trace.SpanKindClient, spans[0].SpanKind())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Contains(t, spans[0].Attributes(), attribute.String("webhook.delivery.id", "sub-1"))
+	assert.Contains(t, spans[0].Attributes(), attribute.Int("http.response.status_code", http.StatusInternalServerError))
+
+	// the receiver continues the trace of the span
+	require.Len(t, rec.traceparents, 1)
+	spanContext := spans[0].SpanContext()
+	assert.Equal(t, "00-"+spanContext.TraceID().String()+"-"+spanContext.SpanID().String()+"-01", rec.traceparents[0])
+}
+
+func TestWorkerAttemptsDeliveriesConcurrently(t *testing.T) {
+	// the receiver only answers once both deliveries are in flight
+	arrived := make(chan struct{}, 2)
+	both := make(chan struct{})
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		arrived <- struct{}{}
+		if len(arrived) == cap(arrived) {
+			once.Do(func() { close(both) })
+		}
+		select {
+		case <-both:
+		case <-time.After(time.Second):
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	repository, err := persistence.NewWebhookJSONRepository(filepath.Join(t.TempDir(), "webhooks.json"))
+	require.NoError(t, err)
+	for _, id := range []string{"first", "second"} {
+		require.NoError(t, repository.SaveSubscription(domain.WebhookSubscription{ID: id, URL: server.URL, Secret: secret}))
+	}
+
+	bus := eventbus.New()
+	outbox := webhook.NewEventOutbox()
+	require.NoError(t, outbox.Subscribe(bus))
+	worker := webhook.NewWorker(outbox, repository, webhook.Config{
+		BatchSize:      10,
+		MaxAttempts:    3,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		RequestTimeout: 2 * time.Second,
+		Concurrency:    2,
+	}, mocks.NewNullLogger())
+
+	record(t, bus, 1, "WELCOME")
+	require.NoError(t, worker.Process(context.Background()))
+
+	for _, id := range []string{"first", "second"} {
+		deliveries, err := repository.GetDeliveries(id)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, domain.DeliverySucceeded, deliveries[0].Status, id)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	body := []byte(`{"id":"sub-1"}`)
+	signature := webhook.Sign(secret, body)
+
+	assert.True(t, webhook.Verify(secret, body, signature))
+	assert.False(t, webhook.Verify("another secret!!", body, signature))
+	assert.False(t, webhook.Verify(secret, []byte(`{"id":"sub-2"}`), signature))
+}
+
+// an action dispatched again, after marking the outbox failed, keeps the progress of its delivery
+func TestWorkerDispatchesActionOnce(t *testing.T) {
+	worker, repository, bus, rec, _ := setup(t)
+
+	record(t, bus, 1, "WELCOME")
+	require.NoError(t, worker.Process(context.Background()))
+
+	record(t, bus, 1, "WELCOME")
+	require.NoError(t, worker.Process(context.Background()))
+
+	assert.Len(t, rec.payloads, 1)
+
+	deliveries, err := repository.GetDeliveries("sub")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, domain.DeliverySucceeded, deliveries[0].Status)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+}
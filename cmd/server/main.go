@@ -1,54 +1,245 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/JoseBeteta/surfe/app"
 	user_application "github.com/JoseBeteta/surfe/app/application"
+	"github.com/JoseBeteta/surfe/app/domain"
 	"github.com/JoseBeteta/surfe/app/infrastructure/common/configx"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/eventbus"
 	http2 "github.com/JoseBeteta/surfe/app/infrastructure/common/http"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/lifecycle"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/logging"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/metrics"
+	"github.com/JoseBeteta/surfe/app/infrastructure/common/tracing"
+	"github.com/JoseBeteta/surfe/app/infrastructure/consumer"
 	action_infrastructure "github.com/JoseBeteta/surfe/app/infrastructure/persistence"
+	"github.com/JoseBeteta/surfe/app/infrastructure/projection"
+	"github.com/JoseBeteta/surfe/app/infrastructure/stream"
+	"github.com/JoseBeteta/surfe/app/infrastructure/webhook"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
 )
 
 var (
 	hash, version string
+
+	printConfig   = flag.Bool("print-config", false, "Print the effective configuration, secrets redacted, and exit")
+	explainConfig = flag.Bool("explain-config", false, "Print the source of every configuration value, secrets redacted, and exit")
+	createAPIKey  = flag.String("create-api-key", "", "Create an API key with the admin scope and the given name, print it and exit")
 )
 
 func main() {
-	config, err := app.LoadConfig(configx.NewLoader())
+	loader := configx.NewLoader()
+	config, err := app.LoadConfig(loader)
 
 	// panic if there is an error
 	if err != nil {
 		panic(err)
 	}
 
-	logger := createLogger(hash, version, config.ServiceName, "test", "server")
+	if *explainConfig {
+		for _, origin := range loader.Explain() {
+			fmt.Println(origin)
+		}
+		return
+	}
+	if *printConfig {
+		if err := configx.Dump(os.Stdout, config); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	level := new(slog.LevelVar)
+	level.Set(config.Log.Level)
+	logger := createLogger(config.Log, level, hash, version, config.ServiceName, "server")
+	if config.Log.Format == logging.FormatJSON {
+		// the routes gin prints in debug mode would break the stream of JSON lines
+		gin.SetMode(gin.ReleaseMode)
+	}
 
-	// Setup and start the server
-	server := setupServer(*config, *logger)
-	err = server.ListenAndServe()
-	if err != nil {
-		logger.Error("error listening server", slog.String("error", err.Error()))
+	if *createAPIKey != "" {
+		if err := createAdminAPIKey(config.Auth.APIKeysFile, *createAPIKey, *logger); err != nil {
+			logger.Error("error creating api key", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		return
+	}
+
+	reloader := configx.NewReloader(func() (*app.Config, error) {
+		return app.LoadConfig(loader)
+	}, config, *logger)
+	reloader.Subscribe(func(old, new *app.Config) {
+		if old.Log.Level != new.Log.Level {
+			level.Set(new.Log.Level)
+			logger.Info("log level changed", slog.String("level", new.Log.Level.String()))
+		}
+	})
+
+	// Setup the components, they are started in the order they are registered and stopped in reverse order
+	lc := lifecycle.New(*logger)
+	server := setupServer(*config, *logger, lc, reloader)
+
+	if config.Reload.Enabled {
+		reloader.WatchFiles(config.Reload.Interval, loader.Files()...)
+		lc.Append(lc.Worker("config reloader", reloader.Run))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := lc.Start(ctx); err != nil {
+		logger.Error("error starting server", slog.String("error", err.Error()))
 		panic(err)
 	}
+	logger.Info("server started", slog.String("address", server.Addr))
+
+	select {
+	case <-ctx.Done():
+	case <-lc.Failed():
+		// the failure was logged by the worker
+	}
+	logger.Info("shutting down, draining in-flight requests", slog.String("timeout", config.Server.ShutdownTimeout.String()))
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := lc.Stop(shutdownCtx); err != nil {
+		logger.Error("error shutting down", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	logger.Info("server stopped")
+}
+
+// serverHook starts listening on start, so the address being in use fails the start,
+// and on stop waits for in-flight requests to finish
+func serverHook(server *http.Server, log slog.Logger) lifecycle.Hook {
+	return lifecycle.Hook{
+		Name: "http server",
+		OnStart: func(context.Context) error {
+			listener, err := net.Listen("tcp", server.Addr)
+			if err != nil {
+				return err
+			}
+
+			go func() {
+				if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					log.Error("error serving", slog.String("error", err.Error()))
+				}
+			}()
+			return nil
+		},
+		OnStop: server.Shutdown,
+	}
 }
 
-func setupServer(cfg app.Config, log slog.Logger) *http.Server {
+func setupServer(cfg app.Config, log slog.Logger, lc *lifecycle.Lifecycle, reloader *configx.Reloader[app.Config]) *http.Server {
 	r := gin.New()
+	// the client IP identifies the callers rate limited, it is only read from X-Forwarded-For behind trusted proxies
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxyList()); err != nil {
+		log.Error("error setting the trusted proxies", slog.String("error", err.Error()))
+		panic(err)
+	}
+
+	// every request is identified first, so the records logged while handling it are tagged with its ID
+	r.Use(http2.RequestID(), http2.AccessLog(log))
+
+	// requests are timed outside the recovery so panics are recorded with their status
+	registry := metrics.NewRegistry()
+	r.Use(http2.MetricsMiddleware(registry))
+	http2.RegisterMetricsHandler(r, registry)
+
+	// the tracer is flushed last, once the spans of the components stopped before have ended
+	tracerProvider, shutdownTracing, err := tracing.NewTracerProvider(cfg.Tracing, cfg.ServiceName, version)
+	if err != nil {
+		log.Error("error setting up tracing", slog.String("error", err.Error()))
+		panic(err)
+	}
+	lc.Append(lifecycle.Hook{Name: "tracing", OnStop: shutdownTracing})
+	tracer := tracerProvider.Tracer(tracing.InstrumentationName)
+	r.Use(tracing.Middleware(tracer))
+
 	httpMapper := http2.NewHttpMapper(log)
 	httpMapper.Initialize(r)
 
-	usersFile := os.Getenv("USERS_FILE")
-	actionsFile := os.Getenv("ACTIONS_FILE")
+	// readiness fails until every component is started, the listener being the last one
+	health := http2.NewHealthChecker(cfg.Server.HealthCheckTimeout)
+	http2.RegisterHealthHandlers(r, health)
+
+	repos, err := setupRepositories(cfg.Storage, registry, log)
+	if err != nil {
+		log.Error("error setting up storage", slog.String("error", err.Error()))
+		panic(err)
+	}
+	checkNames := make([]string, 0, len(repos.checks))
+	for name := range repos.checks {
+		checkNames = append(checkNames, name)
+	}
+	sort.Strings(checkNames)
+	for _, name := range checkNames {
+		health.Register(name, repos.checks[name])
+	}
+	lc.Append(lifecycle.Hook{
+		Name: "storage",
+		OnStop: func(context.Context) error {
+			return repos.close()
+		},
+	})
+
+	// Read endpoints are served from projections updated with every recorded action, they are built before the
+	// listener is started so no request is answered from empty projections
+	bus := eventbus.New()
+	projections := projection.NewProjections(repos.actionRead)
+	if err := projections.Subscribe(bus); err != nil {
+		panic(err)
+	}
+	lc.Append(lifecycle.Hook{
+		Name: "projections",
+		OnStart: func(ctx context.Context) error {
+			count, err := projections.Rebuild(ctx)
+			if err != nil {
+				return err
+			}
+			log.Info("projections built", slog.Int("actions", count))
+			return nil
+		},
+	})
+	actionWriteRepository := tracing.NewActionWriteRepository(
+		user_application.NewPublishingActionWriteRepository(repos.actionWrite, bus, log),
+		tracer,
+	)
+	actionReadRepository := tracing.NewActionReadRepository(projections, tracer)
+
+	hub := stream.NewHub(cfg.Stream.BufferSize)
+	if err := hub.Subscribe(bus); err != nil {
+		panic(err)
+	}
 
-	userReadRepository := action_infrastructure.NewUserJSONRepository(usersFile)
-	actionReadRepository := action_infrastructure.NewActionJSONRepository(actionsFile)
+	webhookRepository, err := setupWebhooks(cfg.Webhooks, repos.actionWrite, tracer, log, lc)
+	if err != nil {
+		log.Error("error setting up webhooks", slog.String("error", err.Error()))
+		panic(err)
+	}
 
 	http2.RegisterHomeHandler(r)
+	http2.RegisterOpenAPIHandlers(r, user_application.OpenAPISpec())
 
+	userReadRepository := tracing.NewUserReadRepository(repos.userRead, tracer)
 	userHandler := user_application.NewUserHandler(
 		userReadRepository,
 		log,
@@ -57,25 +248,421 @@ func setupServer(cfg app.Config, log slog.Logger) *http.Server {
 
 	actionHandler := user_application.NewActionHandler(
 		actionReadRepository,
+		actionWriteRepository,
+		userReadRepository,
+		log,
+		httpMapper,
+	)
+
+	projectionHandler := user_application.NewProjectionHandler(
+		projections,
+		log,
+		httpMapper,
+	)
+
+	streamHandler := user_application.NewStreamHandler(
+		hub,
+		actionReadRepository,
+		cfg.Stream.HeartbeatInterval,
+		log,
+		httpMapper,
+	)
+
+	webhookHandler := user_application.NewWebhookHandler(
+		webhookRepository,
+		log,
+		httpMapper,
+	)
+
+	apiKeyRepository, err := action_infrastructure.NewAPIKeyJSONRepository(cfg.Auth.APIKeysFile)
+	if err != nil {
+		log.Error("error opening api keys", slog.String("error", err.Error()))
+		panic(err)
+	}
+	apiKeyService := user_application.NewAPIKeyService(apiKeyRepository, log)
+
+	apiKeyHandler := user_application.NewAPIKeyHandler(
+		apiKeyService,
 		log,
 		httpMapper,
 	)
 
-	userHandler.Initialize(r)
-	actionHandler.Initialize(r)
+	auth, err := setupAuth(cfg.Auth, apiKeyService, log)
+	if err != nil {
+		log.Error("error setting up authentication", slog.String("error", err.Error()))
+		panic(err)
+	}
+
+	requestValidator, err := http2.NewRequestValidator(user_application.OpenAPISpec())
+	if err != nil {
+		log.Error("error compiling the OpenAPI document", slog.String("error", err.Error()))
+		panic(err)
+	}
+
+	// clients are rate limited by IP before authentication, so failed attempts are limited too, and once
+	// authenticated by API key or token subject
+	// requests are validated against the OpenAPI document once allowed, so only valid ones are answered from the cache
+	// the responses in v1 announce its deprecation, errors included, and bodies over the limit are rejected up front
+	ipRateLimiter := http2.NewRateLimiter(rateLimits(cfg.RateLimit.Enabled, cfg.RateLimit.IPLimits), httpMapper).ByIP()
+	rateLimiter := http2.NewRateLimiter(rateLimits(cfg.RateLimit.Enabled, cfg.RateLimit.Limits), httpMapper)
+	middlewares := []gin.HandlerFunc{
+		http2.Deprecate(http2.V1, cfg.Versions.V1DeprecatedAt, cfg.Versions.V1SunsetAt),
+		http2.LimitBody(cfg.Server.MaxBodySize),
+	}
+	middlewares = append(middlewares, ipRateLimiter.Limit())
+	middlewares = append(middlewares, auth...)
+	middlewares = append(middlewares, rateLimiter.Limit(), requestValidator.Validate())
+	// clients whose copy of a read response is still current get a 304 instead of the response computed again
+	// the responses are computed from the projections and the users, the tags change with either
+	cachePolicy, err := http2.NewCachePolicy(cfg.Cache)
+	if err != nil {
+		log.Error("error setting up the cache policy", slog.String("error", err.Error()))
+		panic(err)
+	}
+	middlewares = append(middlewares, http2.Conditional(cachePolicy, func(ctx context.Context) (string, error) {
+		users, err := repos.usersVersion(ctx)
+		if err != nil {
+			return "", err
+		}
+		return projections.Version() + "." + users, nil
+	}))
+	reloader.Subscribe(func(old, new *app.Config) {
+		if old.RateLimit != new.RateLimit {
+			ipRateLimiter.SetLimits(rateLimits(new.RateLimit.Enabled, new.RateLimit.IPLimits))
+			rateLimiter.SetLimits(rateLimits(new.RateLimit.Enabled, new.RateLimit.Limits))
+			log.Info("rate limits changed",
+				slog.Bool("enabled", new.RateLimit.Enabled),
+				slog.String("limits", new.RateLimit.Limits),
+				slog.String("ip_limits", new.RateLimit.IPLimits),
+			)
+		}
+	})
+
+	// write requests retried with the same Idempotency-Key get the response of the first one
+	idempotency := http2.Idempotent(http2.NewMemoryIdempotencyStore(), cfg.Server.IdempotencyKeyTTL)
+
+	userHandler.Initialize(r, append(middlewares, idempotency)...)
+	actionHandler.Initialize(r, append(middlewares, idempotency)...)
+	projectionHandler.Initialize(r, middlewares...)
+	streamHandler.Initialize(r, middlewares...)
+	webhookHandler.Initialize(r, middlewares...)
+	apiKeyHandler.Initialize(r, middlewares...)
+
+	if cfg.Consumer.Source != consumer.SourceNone {
+		actionConsumer := setupConsumer(cfg.Consumer, actionWriteRepository, health, registry, log, lc)
 
-	return http2.NewServer(cfg.Server, r)
+		consumerHandler := user_application.NewConsumerHandler(
+			actionConsumer,
+			log,
+			httpMapper,
+		)
+		consumerHandler.Initialize(r, middlewares...)
+	}
+
+	// the server is started after the workers and stopped before them, so the actions accepted while
+	// in-flight requests are drained still reach the outbox and the projections
+	server := http2.NewServer(cfg.Server, r)
+	// open streams would keep the server from shutting down until the drain timeout
+	server.RegisterOnShutdown(hub.Close)
+	lc.Append(serverHook(server, log))
+
+	lc.Append(readinessHook(health, cfg.Server.ShutdownDelay))
+
+	return server
+}
+
+// readinessHook marks the service as running once every other component is started,
+// and as stopping before they are stopped, waiting delay for load balancers to notice
+func readinessHook(health *http2.HealthChecker, delay time.Duration) lifecycle.Hook {
+	return lifecycle.Hook{
+		Name: "readiness",
+		OnStart: func(context.Context) error {
+			health.SetPhase(http2.PhaseRunning)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			health.SetPhase(http2.PhaseStopping)
+
+			select {
+			case <-time.After(delay):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+// repositories groups the repositories built on the configured storage backend
+type repositories struct {
+	userRead    domain.UserReadRepository
+	actionRead  domain.ActionReadRepository
+	actionWrite domain.ActionWriteRepository
+	// closer closes the storage files, nil when there is nothing to close
+	closer io.Closer
+	// checks verify the storage is usable, by name
+	checks map[string]http2.Check
+	// usersVersion changes with the users stored
+	usersVersion http2.DataVersion
+}
+
+func (r repositories) close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+func setupRepositories(cfg app.StorageConfig, metrics action_infrastructure.MetricsAgent, log slog.Logger) (repositories, error) {
+	usersCSVMapping, err := action_infrastructure.ParseCSVMapping(cfg.UsersCSVColumns)
+	if err != nil {
+		return repositories{}, err
+	}
+	actionsCSVMapping, err := action_infrastructure.ParseCSVMapping(cfg.ActionsCSVColumns)
+	if err != nil {
+		return repositories{}, err
+	}
+
+	userJSONRepository := action_infrastructure.NewUserJSONRepository(cfg.UsersFile).
+		WithCSVMapping(usersCSVMapping).
+		WithMetrics(metrics)
+	actionJSONRepository := action_infrastructure.NewActionJSONRepository(cfg.ActionsFile).
+		WithCSVMapping(actionsCSVMapping).
+		WithMetrics(metrics)
+
+	switch cfg.Backend {
+	case app.StorageLog:
+		// actions are stored in the append-only log, seeded from the actions file the first time
+		actionLogRepository, err := openActionLog(cfg.ActionsLogDir, actionJSONRepository, log)
+		if err != nil {
+			return repositories{}, err
+		}
+		return repositories{
+			userRead:     userJSONRepository,
+			actionRead:   actionLogRepository,
+			actionWrite:  actionLogRepository,
+			closer:       actionLogRepository,
+			checks:       map[string]http2.Check{"users file": userJSONRepository.Ping},
+			usersVersion: userJSONRepository.Version,
+		}, nil
+	case app.StorageBolt:
+		boltRepository, err := openBolt(cfg.BoltPath, userJSONRepository, actionJSONRepository)
+		if err != nil {
+			return repositories{}, err
+		}
+		return repositories{
+			userRead:    boltRepository,
+			actionRead:  boltRepository,
+			actionWrite: boltRepository,
+			closer:      boltRepository,
+			checks:      map[string]http2.Check{"database": boltRepository.Ping},
+			// users are only written when the database is seeded
+			usersVersion: func(context.Context) (string, error) { return "", nil },
+		}, nil
+	default:
+		return repositories{
+			userRead:    userJSONRepository,
+			actionRead:  actionJSONRepository,
+			actionWrite: actionJSONRepository,
+			checks: map[string]http2.Check{
+				"users file":   userJSONRepository.Ping,
+				"actions file": actionJSONRepository.Ping,
+			},
+			usersVersion: userJSONRepository.Version,
+		}, nil
+	}
+}
+
+// openBolt opens the embedded database importing the seed users and actions when it is empty
+// The database is closed when it can't be seeded, so its file lock is released
+func openBolt(
+	path string,
+	users *action_infrastructure.UserJSONRepository,
+	actions domain.ActionReadRepository,
+) (*action_infrastructure.BoltRepository, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	repository, err := action_infrastructure.NewBoltRepository(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := seedBolt(repository, users, actions); err != nil {
+		repository.Close()
+		return nil, err
+	}
+
+	return repository, nil
+}
+
+// seedBolt imports the seed users and actions together when the database is empty
+func seedBolt(
+	repository *action_infrastructure.BoltRepository,
+	users *action_infrastructure.UserJSONRepository,
+	actions domain.ActionReadRepository,
+) error {
+	empty, err := repository.IsEmpty()
+	if err != nil || !empty {
+		return err
+	}
+
+	seedUsers, err := users.GetAll()
+	if err != nil {
+		return err
+	}
+
+	seedActions, err := actions.GetAll(context.Background())
+	if err != nil {
+		return err
+	}
+
+	return repository.Seed(seedUsers, seedActions)
+}
+
+// openActionLog opens the actions log importing the seed actions when the log is empty
+func openActionLog(dir string, seed domain.ActionReadRepository, log slog.Logger) (*action_infrastructure.ActionLogRepository, error) {
+	repository, err := action_infrastructure.NewActionLogRepository(dir, action_infrastructure.DefaultSegmentSize, log)
+	if err != nil {
+		return nil, err
+	}
+
+	if repository.Len() > 0 {
+		return repository, nil
+	}
+
+	actions, err := seed.GetAll(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return repository, repository.Import(actions)
+}
+
+// createAdminAPIKey creates an API key with the admin scope and prints it, so the first key can be
+// created before any caller is able to authenticate
+func createAdminAPIKey(file, name string, log slog.Logger) error {
+	repository, err := action_infrastructure.NewAPIKeyJSONRepository(file)
+	if err != nil {
+		return err
+	}
+
+	plain, key, err := user_application.NewAPIKeyService(repository, log).Create(name, []string{http2.ScopeAdmin}, nil)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("created api key %s\n%s\n", key.ID, plain)
+	return nil
+}
+
+// setupAuth returns the middlewares authenticating and authorizing the API requests, none when authentication is disabled
+// Requests are authenticated with an API key or a JWT, only API keys are accepted when no JWT key is configured
+// Without JWT keys nor active API keys no request could ever be authenticated, so it refuses to start
+func setupAuth(cfg http2.AuthConfig, apiKeys *user_application.APIKeyService, log slog.Logger) ([]gin.HandlerFunc, error) {
+	if !cfg.Enabled {
+		log.Warn("authentication disabled, every endpoint is public")
+		return nil, nil
+	}
+
+	authenticator, err := http2.NewAuthenticator(cfg)
+	if errors.Is(err, http2.ErrNoVerificationKeys) {
+		active, err := apiKeys.HasActiveKeys()
+		if err != nil {
+			return nil, err
+		}
+		if !active {
+			return nil, errors.New("authentication enabled without JWT verification keys nor active api keys, create one with --create-api-key")
+		}
+		log.Warn("no JWT verification keys configured, only api keys are accepted")
+	} else if err != nil {
+		return nil, err
+	}
+
+	return []gin.HandlerFunc{
+		http2.AuthenticateAPIKey(apiKeys),
+		http2.Authenticate(authenticator),
+		http2.NewScopeAuthorizer(log),
+	}, nil
+}
+
+// setupWebhooks starts the delivery worker reading the outbox of the storage backend
+// Backends without an outbox written with the actions are refused, actions would be lost on restarts
+func setupWebhooks(
+	cfg webhook.Config,
+	actionWrite domain.ActionWriteRepository,
+	tracer trace.Tracer,
+	log slog.Logger,
+	lc *lifecycle.Lifecycle,
+) (domain.WebhookRepository, error) {
+	webhookRepository, err := action_infrastructure.NewWebhookJSONRepository(cfg.WebhooksFile)
+	if err != nil {
+		return nil, err
+	}
+
+	outbox, ok := actionWrite.(domain.ActionOutbox)
+	if !ok {
+		return nil, fmt.Errorf("the storage backend %T has no outbox to deliver webhooks from", actionWrite)
+	}
+
+	worker := webhook.NewWorker(outbox, webhookRepository, cfg, log).WithTracer(tracer)
+	lc.Append(lc.Worker("webhook worker", worker.Run))
+
+	return webhookRepository, nil
+}
+
+// setupConsumer starts consuming the actions published to the broker, storing them as the POST endpoint does
+// The broker is dialed by the consumer with backoff, so the service starts while it is unreachable and
+// readiness reports it down until connected
+func setupConsumer(
+	cfg consumer.Config,
+	actionWrite domain.ActionWriteRepository,
+	health *http2.HealthChecker,
+	registry *metrics.Registry,
+	log slog.Logger,
+	lc *lifecycle.Lifecycle,
+) *consumer.Consumer {
+	source := consumer.NewAMQPSource(cfg.AMQPURL, cfg.AMQPQueue, cfg.Prefetch, cfg.RetryDelay, cfg.ReconnectMaxDelay, log)
+	health.Register("broker", source.Ping)
+
+	actionConsumer := consumer.NewConsumer(source, actionWrite, cfg.RetryDelay, log).WithMetrics(registry)
+	lc.Append(lifecycle.Hook{
+		Name: "actions source",
+		OnStop: func(context.Context) error {
+			return source.Close()
+		},
+	})
+	lc.Append(lc.Worker("actions consumer", actionConsumer.Run))
+	lc.Append(lc.Worker("actions consumer backlog", func(ctx context.Context) error {
+		return actionConsumer.MeasureBacklog(ctx, cfg.BacklogInterval)
+	}))
+
+	return actionConsumer
+}
+
+// rateLimits returns the configured limits, none when rate limiting is disabled
+// The limits were validated when the configuration was loaded
+func rateLimits(enabled bool, configured string) http2.RateLimits {
+	if !enabled {
+		return http2.RateLimits{}
+	}
+
+	limits, err := http2.ParseRateLimits(configured)
+	if err != nil {
+		panic(err)
+	}
+	return limits
 }
 
 // Custom function to create a logger with default fields
-func createLogger(hash, version, serviceName, env, component string) *slog.Logger {
-	handler := slog.NewTextHandler(os.Stdout, nil)
-	logger := slog.New(handler)
+func createLogger(cfg logging.Config, level slog.Leveler, hash, version, serviceName, component string) *slog.Logger {
+	logger := logging.NewWithLevel(cfg, level, os.Stdout)
 	return logger.With(
 		slog.String("hash", hash),
 		slog.String("version", version),
 		slog.String("service", serviceName),
-		slog.String("environment", env),
+		slog.String("environment", cfg.Environment),
 		slog.String("component", component),
 	)
 }